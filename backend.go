@@ -0,0 +1,305 @@
+package synapse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backend is a system of record behind the cache, such as a database. The
+// namespace of a call is carried by its context.
+type Backend[K comparable, V any] interface {
+	// Load returns the value stored for key, or false if there is none
+	Load(ctx context.Context, key K) (V, bool, error)
+	// Store writes a value
+	Store(ctx context.Context, key K, value V) error
+	// Delete removes a key; deleting a missing key is not an error
+	Delete(ctx context.Context, key K) error
+}
+
+// WriteMode selects how writes reach the backend
+type WriteMode int
+
+const (
+	// WriteNone leaves the backend untouched by writes
+	WriteNone WriteMode = iota
+	// WriteThrough writes to the backend synchronously
+	WriteThrough
+	// WriteBehind queues writes and flushes them in batches
+	WriteBehind
+)
+
+// BackendError reports a failed backend call
+type BackendError struct {
+	// Op is the backend method that failed: load, store or delete
+	Op        string
+	Namespace string
+	Key       any
+	Err       error
+}
+
+// Error implements error
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("synapse: backend %s %q/%v: %v", e.Op, e.Namespace, e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// backing connects a cache to its backend
+type backing[K comparable, V any] struct {
+	backend Backend[K, V]
+	options *Options
+
+	mu      sync.Mutex
+	pending map[nsKey[K]]pendingWrite[V] // Queued write-behind writes
+
+	flushMu sync.Mutex // Serializes flushes so writes reach the backend in order
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	refreshMu  sync.Mutex
+	refreshing map[nsKey[K]]struct{} // Keys being reloaded in the background
+	refreshWG  sync.WaitGroup
+	closed     bool // Set by close to stop new refreshes, guarded by refreshMu
+}
+
+// pendingWrite is a queued write-behind write
+type pendingWrite[V any] struct {
+	value  V
+	delete bool
+}
+
+// newBacking connects a backend and starts the write-behind flusher if needed
+func newBacking[K comparable, V any](options *Options) *backing[K, V] {
+	backend, ok := options.Backend.(Backend[K, V])
+	if !ok {
+		var k K
+		var v V
+		panic(fmt.Sprintf("synapse: backend %T does not implement Backend[%T, %T]", options.Backend, k, v))
+	}
+
+	b := &backing[K, V]{
+		backend:    backend,
+		options:    options,
+		pending:    make(map[nsKey[K]]pendingWrite[V]),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		refreshing: make(map[nsKey[K]]struct{}),
+	}
+
+	if options.WriteMode == WriteBehind {
+		go b.flushLoop()
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// fetch loads a key from the backend. Keys with a queued write-behind write
+// are answered from the queue, as the backend is not up to date yet.
+func (b *backing[K, V]) fetch(ctx context.Context, key K) (V, bool, error) {
+	namespace := GetNamespace(ctx)
+
+	b.mu.Lock()
+	w, queued := b.pending[nsKey[K]{Namespace: namespace, Key: key}]
+	b.mu.Unlock()
+	if queued {
+		return w.value, !w.delete, nil
+	}
+
+	var v V
+	var found bool
+	err := b.retry(ctx, func() error {
+		var err error
+		v, found, err = b.backend.Load(ctx, key)
+		return err
+	})
+	if err != nil {
+		var zero V
+		return zero, false, &BackendError{Op: "load", Namespace: namespace, Key: key, Err: err}
+	}
+	return v, found, nil
+}
+
+// store writes a value to the backend according to the write mode
+func (b *backing[K, V]) store(ctx context.Context, key K, value V) error {
+	switch b.options.WriteMode {
+	case WriteThrough:
+		err := b.retry(ctx, func() error {
+			return b.backend.Store(ctx, key, value)
+		})
+		if err != nil {
+			return &BackendError{Op: "store", Namespace: GetNamespace(ctx), Key: key, Err: err}
+		}
+	case WriteBehind:
+		b.enqueue(nsKey[K]{Namespace: GetNamespace(ctx), Key: key}, pendingWrite[V]{value: value})
+	}
+	return nil
+}
+
+// delete removes a key from the backend according to the write mode
+func (b *backing[K, V]) delete(ctx context.Context, key K) error {
+	switch b.options.WriteMode {
+	case WriteThrough:
+		err := b.retry(ctx, func() error {
+			return b.backend.Delete(ctx, key)
+		})
+		if err != nil {
+			return &BackendError{Op: "delete", Namespace: GetNamespace(ctx), Key: key, Err: err}
+		}
+	case WriteBehind:
+		b.enqueue(nsKey[K]{Namespace: GetNamespace(ctx), Key: key}, pendingWrite[V]{delete: true})
+	}
+	return nil
+}
+
+// enqueue queues a write-behind write, replacing any earlier write of the key
+func (b *backing[K, V]) enqueue(key nsKey[K], w pendingWrite[V]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[key] = w
+}
+
+// flushLoop flushes the write-behind queue every interval until closed
+func (b *backing[K, V]) flushLoop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.options.WriteBehindInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flush(context.Background())
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// flush sends the queued writes to the backend. Writes that still fail after
+// retrying are reported and dropped. It returns the first error.
+func (b *backing[K, V]) flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = make(map[nsKey[K]]pendingWrite[V])
+	b.mu.Unlock()
+
+	var first error
+	for key, w := range batch {
+		if err := ctx.Err(); err != nil {
+			// Put the rest back for the next flush, unless overwritten since
+			b.requeue(key, w)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+
+		keyCtx := WithNamespace(ctx, key.Namespace)
+		op := "store"
+		err := b.retry(keyCtx, func() error {
+			if w.delete {
+				return b.backend.Delete(keyCtx, key.Key)
+			}
+			return b.backend.Store(keyCtx, key.Key, w.value)
+		})
+		if w.delete {
+			op = "delete"
+		}
+		if err != nil {
+			err = &BackendError{Op: op, Namespace: key.Namespace, Key: key.Key, Err: err}
+			b.report(err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// requeue puts back a write that could not be flushed, unless the key has
+// been written again since
+func (b *backing[K, V]) requeue(key nsKey[K], w pendingWrite[V]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[key]; !ok {
+		b.pending[key] = w
+	}
+}
+
+// close waits for background refreshes, stops the flusher and flushes the
+// remaining writes
+func (b *backing[K, V]) close() error {
+	var err error
+	b.once.Do(func() {
+		// No refresh may start once Wait has begun
+		b.refreshMu.Lock()
+		b.closed = true
+		b.refreshMu.Unlock()
+		b.refreshWG.Wait()
+		close(b.stop)
+		<-b.done
+		if b.options.WriteMode == WriteBehind {
+			err = b.flush(context.Background())
+		}
+	})
+	return err
+}
+
+// retry calls fn until it succeeds, the attempts are exhausted or ctx is done
+func (b *backing[K, V]) retry(ctx context.Context, fn func() error) error {
+	backoff := b.options.BackendRetryBackoff
+
+	var err error
+	for attempt := 0; attempt < b.options.BackendRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return err
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// report logs an error and passes it to the backend error handler
+func (b *backing[K, V]) report(err error) {
+	if b.options.Logger != nil {
+		logBackendError(b.options.Logger, err)
+	}
+	if b.options.OnBackendError != nil {
+		b.options.OnBackendError(err)
+	}
+}
+
+// Flush sends the writes queued by WithWriteBehind to the backend now
+func (c *Cache[K, V]) Flush(ctx context.Context) error {
+	if c.backing == nil || c.options.WriteMode != WriteBehind {
+		return nil
+	}
+	return c.backing.flush(ctx)
+}
+
+// Close stops the cache's background work and flushes the writes queued by
+// WithWriteBehind. The cache remains usable, but later write-behind writes
+// are only sent to the backend by Flush.
+func (c *Cache[K, V]) Close() error {
+	if c.backing == nil {
+		return nil
+	}
+	return c.backing.close()
+}
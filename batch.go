@@ -0,0 +1,139 @@
+package synapse
+
+import (
+	"context"
+)
+
+// Item is a key-value pair used by bulk operations
+type Item[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Result reports the outcome of a bulk operation for a single key
+type Result[V any] struct {
+	// Value is the stored value for GetMany and the removed value for DeleteMany
+	Value V
+	// Found reports whether the key was present
+	Found bool
+	// Err is set when the key was not processed, e.g. due to cancellation
+	Err error
+}
+
+// GetMany retrieves several keys by exact match. Keys are grouped by shard and
+// each shard's lock is taken once. The returned results are in the same order
+// as keys. If ctx is cancelled between shards, the keys that were not yet
+// processed report ctx.Err(). With a backend, keys are looked up one at a
+// time like Get, so that read-through, refresh-ahead and negative caching
+// apply.
+func (c *Cache[K, V]) GetMany(ctx context.Context, keys []K) []Result[V] {
+	results := make([]Result[V], len(keys))
+
+	if c.backing != nil {
+		for i, key := range keys {
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				continue
+			}
+			v, status := c.lookup(ctx, key)
+			results[i].Value, results[i].Found = v, status == LookupHit || status == LookupLoaded
+		}
+		return results
+	}
+
+	for shard, positions := range c.groupByShard(len(keys), func(i int) K { return keys[i] }) {
+		if err := ctx.Err(); err != nil {
+			for _, i := range positions {
+				results[i].Err = err
+			}
+			continue
+		}
+		c.shards[shard].getMany(ctx, keys, positions, results)
+	}
+
+	return results
+}
+
+// SetMany stores several key-value pairs, applying opts to each of them.
+// Items are grouped by shard and each shard's lock is taken once. The returned
+// errors are in the same order as items and are nil for items that were
+// stored. If ctx is cancelled between shards, the items that were not yet
+// processed report ctx.Err(). Items reach the backend as they do with Set.
+func (c *Cache[K, V]) SetMany(ctx context.Context, items []Item[K, V], opts ...SetOption) []error {
+	errs := make([]error, len(items))
+	options := NewSetOptions(ctx, opts...)
+
+	for shard, positions := range c.groupByShard(len(items), func(i int) K { return items[i].Key }) {
+		if err := ctx.Err(); err != nil {
+			for _, i := range positions {
+				errs[i] = err
+			}
+			continue
+		}
+
+		if c.backing != nil && c.options.WriteMode == WriteThrough {
+			// Only cache the items the backend has stored
+			stored := positions[:0]
+			for _, i := range positions {
+				if err := c.backing.store(ctx, items[i].Key, items[i].Value); err != nil {
+					errs[i] = err
+					continue
+				}
+				stored = append(stored, i)
+			}
+			positions = stored
+		}
+
+		c.shards[shard].setMany(ctx, items, options, positions, errs)
+
+		if c.backing != nil && c.options.WriteMode == WriteBehind {
+			for _, i := range positions {
+				if errs[i] == nil {
+					errs[i] = c.backing.store(ctx, items[i].Key, items[i].Value)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// DeleteMany removes several keys. Keys are grouped by shard and each shard's
+// lock is taken once. The returned results are in the same order as keys and
+// report the removed value. If ctx is cancelled between shards, the keys that
+// were not yet processed report ctx.Err(). Keys are removed from the backend
+// as they are with Delete.
+func (c *Cache[K, V]) DeleteMany(ctx context.Context, keys []K) []Result[V] {
+	results := make([]Result[V], len(keys))
+
+	for shard, positions := range c.groupByShard(len(keys), func(i int) K { return keys[i] }) {
+		if err := ctx.Err(); err != nil {
+			for _, i := range positions {
+				results[i].Err = err
+			}
+			continue
+		}
+		c.shards[shard].deleteMany(ctx, keys, positions, results)
+
+		if c.backing != nil {
+			for _, i := range positions {
+				if err := c.backing.delete(ctx, keys[i]); err != nil {
+					c.backing.report(err)
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+// groupByShard returns, for each shard index, the positions of the n keys that
+// belong to it. Shards without keys are omitted.
+func (c *Cache[K, V]) groupByShard(n int, keyAt func(int) K) map[int][]int {
+	groups := make(map[int][]int)
+	for i := 0; i < n; i++ {
+		idx := c.shardIndex(keyAt(i))
+		groups[idx] = append(groups[idx], i)
+	}
+	return groups
+}
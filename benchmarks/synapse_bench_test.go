@@ -3,6 +3,7 @@ package benchmarks
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"testing"
 
 	"github.com/kolosys/synapse"
@@ -62,6 +63,41 @@ func BenchmarkCacheGetSimilar(b *testing.B) {
 	}
 }
 
+func BenchmarkCacheSetMany(b *testing.B) {
+	cache := synapse.New[string, string](
+		synapse.WithShards(16),
+		synapse.WithMaxSize(10000),
+	)
+	ctx := context.Background()
+
+	items := make([]synapse.Item[string, string], 1000)
+	for i := range items {
+		items[i] = synapse.Item[string, string]{Key: fmt.Sprintf("key%d", i), Value: "value"}
+	}
+
+	for b.Loop() {
+		cache.SetMany(ctx, items)
+	}
+}
+
+func BenchmarkCacheGetMany(b *testing.B) {
+	cache := synapse.New[string, string](
+		synapse.WithShards(16),
+		synapse.WithMaxSize(10000),
+	)
+	ctx := context.Background()
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		cache.Set(ctx, keys[i], "value")
+	}
+
+	for b.Loop() {
+		cache.GetMany(ctx, keys)
+	}
+}
+
 func BenchmarkCacheConcurrentSet(b *testing.B) {
 	cache := synapse.New[string, string](
 		synapse.WithShards(32),
@@ -167,3 +203,131 @@ func benchmarkSharding(b *testing.B, numShards int) {
 		}
 	})
 }
+
+// structKey is a composite key for the key hashing benchmarks
+type structKey struct {
+	Tenant string
+	ID     int
+}
+
+// sprintfHasher hashes keys the way shards were picked before WithHasher,
+// formatting them with fmt, as a baseline for the built-in hasher
+func sprintfHasher[K comparable](key K) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("%v", key)))
+	return h.Sum64()
+}
+
+func BenchmarkCacheGetStringKey(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) string { return fmt.Sprintf("key%d", i) })
+}
+
+func BenchmarkCacheGetStringKeySprintf(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) string { return fmt.Sprintf("key%d", i) },
+		synapse.WithHasher(synapse.Hasher[string](sprintfHasher[string])))
+}
+
+func BenchmarkCacheGetIntKey(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) int { return i })
+}
+
+func BenchmarkCacheGetIntKeySprintf(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) int { return i },
+		synapse.WithHasher(synapse.Hasher[int](sprintfHasher[int])))
+}
+
+func BenchmarkCacheGetByteArrayKey(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) [16]byte { return [16]byte{byte(i), byte(i >> 8)} })
+}
+
+func BenchmarkCacheGetByteArrayKeySprintf(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) [16]byte { return [16]byte{byte(i), byte(i >> 8)} },
+		synapse.WithHasher(synapse.Hasher[[16]byte](sprintfHasher[[16]byte])))
+}
+
+func BenchmarkCacheGetStructKey(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) structKey { return structKey{"tenant", i} })
+}
+
+func BenchmarkCacheGetStructKeySprintf(b *testing.B) {
+	benchmarkGetKeys(b, func(i int) structKey { return structKey{"tenant", i} },
+		synapse.WithHasher(synapse.Hasher[structKey](sprintfHasher[structKey])))
+}
+
+// benchmarkGetKeys measures cache hits for 1000 keys built before the timer
+// starts, so that allocations come from the cache alone
+func benchmarkGetKeys[K comparable](b *testing.B, key func(i int) K, opts ...synapse.Option) {
+	cache := synapse.New[K, string](append([]synapse.Option{
+		synapse.WithShards(16),
+		synapse.WithMaxSize(10000),
+	}, opts...)...)
+	ctx := context.Background()
+
+	keys := make([]K, 1000)
+	for i := range keys {
+		keys[i] = key(i)
+		cache.Set(ctx, keys[i], "value")
+	}
+
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		cache.Get(ctx, keys[i%len(keys)])
+	}
+}
+
+func BenchmarkShardDelete_100k(b *testing.B) {
+	benchmarkShardDelete(b, 100_000)
+}
+
+func BenchmarkShardDelete_1M(b *testing.B) {
+	benchmarkShardDelete(b, 1_000_000)
+}
+
+// benchmarkShardDelete deletes keys from a single shard holding n entries,
+// putting each back so the shard stays full
+func benchmarkShardDelete(b *testing.B, n int) {
+	cache := synapse.New[int, string](
+		synapse.WithShards(1),
+		synapse.WithMaxSize(n),
+	)
+	ctx := context.Background()
+
+	for i := range n {
+		cache.Set(ctx, i, "value")
+	}
+
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		// Stride through the shard so deletes hit every position
+		key := (i * 7919) % n
+		cache.Delete(ctx, key)
+		cache.Set(ctx, key, "value")
+	}
+}
+
+func BenchmarkShardEvict_100k(b *testing.B) {
+	benchmarkShardEvict(b, 100_000)
+}
+
+func BenchmarkShardEvict_1M(b *testing.B) {
+	benchmarkShardEvict(b, 1_000_000)
+}
+
+// benchmarkShardEvict adds new keys to a full single shard holding n entries,
+// evicting the oldest entry on every Set
+func benchmarkShardEvict(b *testing.B, n int) {
+	cache := synapse.New[int, string](
+		synapse.WithShards(1),
+		synapse.WithMaxSize(n),
+	)
+	ctx := context.Background()
+
+	for i := range n {
+		cache.Set(ctx, i, "value")
+	}
+
+	b.ReportAllocs()
+	for i := n; b.Loop(); i++ {
+		cache.Set(ctx, i, "value")
+	}
+}
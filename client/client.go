@@ -0,0 +1,513 @@
+// Package client provides RemoteCache, a client for caches served with the
+// wire package. RemoteCache has the same method set as synapse.Cache, so code
+// can switch between a local and a remote cache behind one interface.
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/codec"
+	"github.com/kolosys/synapse/wire"
+)
+
+var (
+	// ErrClosed is returned when using a closed RemoteCache
+	ErrClosed = errors.New("client: closed")
+	// ErrFilterUnsupported is reported by GetSimilar when given a
+	// WithMetadataFilter option, as functions cannot be sent to the server
+	ErrFilterUnsupported = errors.New("client: metadata filters cannot be sent to the server; use WithMetadataMatch")
+)
+
+// RemoteError is an error returned by the server
+type RemoteError struct {
+	Msg string
+}
+
+// Error implements error
+func (e *RemoteError) Error() string {
+	return "client: remote error: " + e.Msg
+}
+
+// Options contains configuration options for a RemoteCache
+type Options struct {
+	// PoolSize is the number of connections to the server
+	PoolSize int
+	// Codec encodes keys, values and metadata; the server must use the same
+	Codec codec.Codec
+	// DialTimeout limits the time spent connecting to the server
+	DialTimeout time.Duration
+	// OnError is called with errors of methods that cannot return them, such
+	// as Get and Len
+	OnError func(error)
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithPoolSize sets the number of connections to the server
+func WithPoolSize(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.PoolSize = n
+		}
+	}
+}
+
+// WithCodec sets the codec used to encode keys, values and metadata
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Codec = c
+		}
+	}
+}
+
+// WithDialTimeout limits the time spent connecting to the server
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		if d > 0 {
+			o.DialTimeout = d
+		}
+	}
+}
+
+// WithErrorHandler sets a function called with the errors of methods that
+// cannot return them
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *Options) {
+		o.OnError = fn
+	}
+}
+
+// RemoteCache is a cache served by a remote wire.Server. Requests from
+// concurrent callers are pipelined over a pool of connections. Broken
+// connections are re-dialed on next use.
+//
+// Methods that cannot return an error, such as Get, report failures as a
+// miss; the error is available from Err and passed to the error handler.
+type RemoteCache[K comparable, V any] struct {
+	addr    string
+	options *Options
+	next    atomic.Uint64
+	lastErr atomic.Pointer[error]
+
+	mu   sync.Mutex
+	pool []*conn
+	// dialing holds, per pool slot, a channel closed when the dial in
+	// progress for that slot finishes
+	dialing []chan struct{}
+	closed  bool
+}
+
+// Dial connects to the server at addr
+func Dial[K comparable, V any](addr string, opts ...Option) (*RemoteCache[K, V], error) {
+	options := &Options{
+		PoolSize:    4,
+		Codec:       codec.Gob{},
+		DialTimeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	c := &RemoteCache[K, V]{
+		addr:    addr,
+		options: options,
+		pool:    make([]*conn, options.PoolSize),
+		dialing: make([]chan struct{}, options.PoolSize),
+	}
+
+	// Fail early if the server is unreachable
+	first, err := c.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.pool[0] = first
+
+	return c, nil
+}
+
+// Get retrieves a value by exact key match
+func (c *RemoteCache[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	var zero V
+
+	data, err := c.options.Codec.Marshal(key)
+	if err != nil {
+		c.report(err)
+		return zero, false
+	}
+
+	resp, err := c.call(ctx, wire.OpGet, &wire.Request{Namespace: synapse.GetNamespace(ctx), Key: data})
+	if err != nil {
+		c.report(err)
+		return zero, false
+	}
+	if !resp.Found {
+		return zero, false
+	}
+
+	var v V
+	if err := c.options.Codec.Unmarshal(resp.Value, &v); err != nil {
+		c.report(err)
+		return zero, false
+	}
+	return v, true
+}
+
+// Set stores a value
+func (c *RemoteCache[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	options := synapse.NewSetOptions(ctx, opts...)
+	req := &wire.Request{
+		Namespace: synapse.GetNamespace(ctx),
+		Tags:      options.Tags,
+		TTL:       int64(options.TTL),
+		KeepTTL:   options.KeepTTL,
+		IfNewer:   options.IfNewer,
+	}
+	if !options.UpdatedAt.IsZero() {
+		req.UpdatedAt = options.UpdatedAt.UnixNano()
+	}
+
+	var err error
+	if req.Key, err = c.options.Codec.Marshal(key); err != nil {
+		return err
+	}
+	if req.Value, err = c.options.Codec.Marshal(value); err != nil {
+		return err
+	}
+	if len(options.Metadata) > 0 {
+		if req.Metadata, err = c.options.Codec.Marshal(options.Metadata); err != nil {
+			return err
+		}
+	}
+
+	_, err = c.call(ctx, wire.OpSet, req)
+	return err
+}
+
+// GetSimilar finds the most similar key above the server's threshold
+func (c *RemoteCache[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	var (
+		zeroV V
+		zeroK K
+	)
+
+	options := synapse.NewSimilarOptions(opts...)
+	if options.Filter != nil {
+		c.report(ErrFilterUnsupported)
+		return zeroV, zeroK, 0, false
+	}
+
+	req := &wire.Request{Namespace: synapse.GetNamespace(ctx)}
+	var err error
+	if req.Key, err = c.options.Codec.Marshal(key); err != nil {
+		c.report(err)
+		return zeroV, zeroK, 0, false
+	}
+	if len(options.Match) > 0 {
+		if req.Match, err = c.options.Codec.Marshal(options.Match); err != nil {
+			c.report(err)
+			return zeroV, zeroK, 0, false
+		}
+	}
+
+	resp, err := c.call(ctx, wire.OpSimilar, req)
+	if err != nil {
+		c.report(err)
+		return zeroV, zeroK, 0, false
+	}
+	if !resp.Found {
+		return zeroV, zeroK, 0, false
+	}
+
+	var (
+		v V
+		k K
+	)
+	if err := c.options.Codec.Unmarshal(resp.Value, &v); err != nil {
+		c.report(err)
+		return zeroV, zeroK, 0, false
+	}
+	if err := c.options.Codec.Unmarshal(resp.Key, &k); err != nil {
+		c.report(err)
+		return zeroV, zeroK, 0, false
+	}
+	return v, k, resp.Score, true
+}
+
+// Delete removes a key from the context's namespace
+func (c *RemoteCache[K, V]) Delete(ctx context.Context, key K) bool {
+	data, err := c.options.Codec.Marshal(key)
+	if err != nil {
+		c.report(err)
+		return false
+	}
+
+	resp, err := c.call(ctx, wire.OpDelete, &wire.Request{Namespace: synapse.GetNamespace(ctx), Key: data})
+	if err != nil {
+		c.report(err)
+		return false
+	}
+	return resp.Found
+}
+
+// Len returns the total number of entries in the remote cache
+func (c *RemoteCache[K, V]) Len() int {
+	resp, err := c.call(context.Background(), wire.OpLen, &wire.Request{})
+	if err != nil {
+		c.report(err)
+		return 0
+	}
+	return int(resp.Count)
+}
+
+// Stats returns the statistics of the remote cache
+func (c *RemoteCache[K, V]) Stats() synapse.Stats {
+	resp, err := c.call(context.Background(), wire.OpStats, &wire.Request{})
+	if err != nil {
+		c.report(err)
+		return synapse.Stats{}
+	}
+	return wire.StatsFromValues(resp.Stats)
+}
+
+// Err returns the most recent error of a method that cannot return errors
+func (c *RemoteCache[K, V]) Err() error {
+	if err := c.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Close closes the connections to the server
+func (c *RemoteCache[K, V]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	var errs []error
+	for i, cn := range c.pool {
+		if cn != nil {
+			errs = append(errs, cn.close(ErrClosed))
+			c.pool[i] = nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// report records an error of a method that cannot return it
+func (c *RemoteCache[K, V]) report(err error) {
+	c.lastErr.Store(&err)
+	if c.options.OnError != nil {
+		c.options.OnError(err)
+	}
+}
+
+// call sends a request over one of the pooled connections and waits for
+// the response
+func (c *RemoteCache[K, V]) call(ctx context.Context, op wire.Op, req *wire.Request) (wire.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return wire.Response{}, err
+	}
+
+	cn, err := c.conn(ctx)
+	if err != nil {
+		return wire.Response{}, err
+	}
+
+	status, payload, err := cn.roundTrip(ctx, op, req.Marshal())
+	if err != nil {
+		return wire.Response{}, err
+	}
+
+	var resp wire.Response
+	if err := resp.Unmarshal(payload); err != nil {
+		return wire.Response{}, err
+	}
+	switch wire.Status(status) {
+	case wire.StatusError:
+		return wire.Response{}, &RemoteError{Msg: resp.Err}
+	case wire.StatusStale:
+		return wire.Response{}, synapse.ErrStale
+	}
+	return resp, nil
+}
+
+// conn picks a pooled connection round-robin, re-dialing it if it is broken
+func (c *RemoteCache[K, V]) conn(ctx context.Context) (*conn, error) {
+	i := int(c.next.Add(1) % uint64(len(c.pool)))
+
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if cn := c.pool[i]; cn != nil && !cn.broken() {
+			c.mu.Unlock()
+			return cn, nil
+		}
+		wait := c.dialing[i]
+		if wait == nil {
+			break
+		}
+		c.mu.Unlock()
+
+		// Another caller is dialing this slot; use its connection
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Reserve the slot and dial without the lock, so that a slow server
+	// does not stall callers using the other connections, or Close
+	done := make(chan struct{})
+	c.dialing[i] = done
+	c.mu.Unlock()
+
+	cn, err := c.dial(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialing[i] = nil
+	close(done)
+
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		cn.close(ErrClosed)
+		return nil, ErrClosed
+	}
+	c.pool[i] = cn
+	return cn, nil
+}
+
+// dial opens a new connection to the server
+func (c *RemoteCache[K, V]) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: c.options.DialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{
+		nc:      nc,
+		w:       bufio.NewWriter(nc),
+		pending: make(map[uint64]chan frame),
+	}
+	go cn.readLoop()
+	return cn, nil
+}
+
+// frame is a response frame, or the error that ended the connection
+type frame struct {
+	status  uint8
+	payload []byte
+	err     error
+}
+
+// conn is a connection carrying pipelined requests
+type conn struct {
+	nc net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	pending map[uint64]chan frame
+	nextID  uint64
+	err     error
+}
+
+// roundTrip sends a request frame and waits for the matching response
+func (cn *conn) roundTrip(ctx context.Context, op wire.Op, payload []byte) (uint8, []byte, error) {
+	ch := make(chan frame, 1)
+
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return 0, nil, cn.err
+	}
+	cn.nextID++
+	id := cn.nextID
+	cn.pending[id] = ch
+	cn.mu.Unlock()
+
+	cn.wmu.Lock()
+	err := wire.WriteFrame(cn.w, id, uint8(op), payload)
+	if err == nil {
+		err = cn.w.Flush()
+	}
+	cn.wmu.Unlock()
+	if err != nil {
+		cn.close(err)
+		return 0, nil, err
+	}
+
+	select {
+	case f := <-ch:
+		return f.status, f.payload, f.err
+	case <-ctx.Done():
+		// The response is discarded when it arrives
+		cn.mu.Lock()
+		delete(cn.pending, id)
+		cn.mu.Unlock()
+		return 0, nil, ctx.Err()
+	}
+}
+
+// readLoop dispatches response frames to the waiting callers
+func (cn *conn) readLoop() {
+	r := bufio.NewReader(cn.nc)
+	for {
+		id, status, payload, err := wire.ReadFrame(r)
+		if err != nil {
+			cn.close(err)
+			return
+		}
+
+		cn.mu.Lock()
+		ch, ok := cn.pending[id]
+		delete(cn.pending, id)
+		cn.mu.Unlock()
+
+		if ok {
+			ch <- frame{status: status, payload: payload}
+		}
+	}
+}
+
+// broken reports whether the connection has failed
+func (cn *conn) broken() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err != nil
+}
+
+// close fails the pending requests with err and closes the connection
+func (cn *conn) close(err error) error {
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return nil
+	}
+	cn.err = err
+	pending := cn.pending
+	cn.pending = nil
+	cn.mu.Unlock()
+
+	for _, ch := range pending {
+		ch <- frame{err: err}
+	}
+	return cn.nc.Close()
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+	"github.com/kolosys/synapse/wire"
+)
+
+var _ synapse.Store[string, string] = (*RemoteCache[string, string])(nil)
+
+// serve starts a wire server on a loopback port and returns its cache and
+// address. opts are applied after the defaults.
+func serve(t *testing.T, opts ...synapse.Option) (*synapse.Cache[string, string], string) {
+	t.Helper()
+
+	local := synapse.New[string, string](append([]synapse.Option{
+		synapse.WithStats(true),
+		synapse.WithThreshold(0.7),
+	}, opts...)...)
+	local.WithSimilarity(algorithms.Levenshtein)
+	srv := wire.NewServer(local)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	return local, ln.Addr().String()
+}
+
+func TestRemoteCache(t *testing.T) {
+	local, addr := serve(t)
+	remote, err := Dial[string, string](addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	ctx := synapse.WithNamespace(context.Background(), "tenant")
+
+	err = remote.Set(ctx, "hello", "world",
+		synapse.WithEntryMetadata("model", "x"),
+		synapse.WithTags("greeting"),
+		synapse.WithEntryTTL(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	entry, ok := local.Peek(ctx, "hello")
+	if !ok || entry.Metadata["model"] != "x" || len(entry.Tags) != 1 || entry.ExpiresAt.IsZero() {
+		t.Fatalf("Set options not applied remotely: %+v", entry)
+	}
+
+	if v, ok := remote.Get(ctx, "hello"); !ok || v != "world" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	if _, ok := remote.Get(context.Background(), "hello"); ok {
+		t.Fatal("Expected key to be scoped to its namespace")
+	}
+
+	v, k, score, ok := remote.GetSimilar(ctx, "helo")
+	if !ok || k != "hello" || v != "world" || score < 0.7 {
+		t.Fatalf("GetSimilar = %q, %q, %f, %v", v, k, score, ok)
+	}
+	if _, _, _, ok := remote.GetSimilar(ctx, "helo", synapse.WithMetadataMatch("model", "y")); ok {
+		t.Fatal("Expected metadata match to be sent to the server")
+	}
+
+	if n := remote.Len(); n != 1 {
+		t.Fatalf("Len = %d", n)
+	}
+	if stats := remote.Stats(); stats.Sets != 1 || stats.Hits != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	if !remote.Delete(ctx, "hello") || remote.Delete(ctx, "hello") {
+		t.Fatal("Expected Delete to report the key once")
+	}
+	if err := remote.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	remote.GetSimilar(ctx, "helo", synapse.WithMetadataFilter(func(map[string]any) bool { return true }))
+	if !errors.Is(remote.Err(), ErrFilterUnsupported) {
+		t.Fatalf("Expected ErrFilterUnsupported, got %v", remote.Err())
+	}
+}
+
+func TestRemoteCachePipelining(t *testing.T) {
+	// Each cache seeds its own hasher, so how the 800 keys spread over the
+	// shards changes from run to run. Leave every shard room for all of them
+	// so that none is evicted.
+	_, addr := serve(t, synapse.WithMaxSize(800*16))
+	remote, err := Dial[string, string](addr, WithPoolSize(2))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i)
+				if err := remote.Set(ctx, key, key); err != nil {
+					t.Errorf("Set failed: %v", err)
+					return
+				}
+				if v, ok := remote.Get(ctx, key); !ok || v != key {
+					t.Errorf("Get(%s) = %q, %v", key, v, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := remote.Len(); n != 800 {
+		t.Fatalf("Expected 800 entries, got %d", n)
+	}
+}
+
+func TestRemoteCacheConditionalWrites(t *testing.T) {
+	local, addr := serve(t)
+	ctx := context.Background()
+
+	remote, err := Dial[string, string](addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	if err := remote.Set(ctx, "key", "v1", synapse.WithEntryTTL(time.Hour)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	before, _ := local.Peek(ctx, "key")
+
+	if err := remote.Set(ctx, "key", "v2", synapse.KeepTTL()); err != nil {
+		t.Fatalf("Set with KeepTTL failed: %v", err)
+	}
+	if entry, _ := local.Peek(ctx, "key"); entry.Value != "v2" || !entry.ExpiresAt.Equal(before.ExpiresAt) {
+		t.Fatalf("Expected KeepTTL to keep expiry %v, got %+v", before.ExpiresAt, entry)
+	}
+
+	old := before.UpdatedAt.Add(-time.Minute)
+	if err := remote.Set(ctx, "key", "old", synapse.IfNewer(), synapse.WithUpdateTime(old)); !errors.Is(err, synapse.ErrStale) {
+		t.Fatalf("Expected ErrStale, got %v", err)
+	}
+	if v, _ := local.Get(ctx, "key"); v != "v2" {
+		t.Fatalf("Expected the stale write to be ignored, got %q", v)
+	}
+}
+
+func TestRemoteCacheErrors(t *testing.T) {
+	_, addr := serve(t)
+
+	// A client decoding values as another type gets a remote error back
+	ints, err := Dial[string, int](addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ints.Close()
+
+	var remoteErr *RemoteError
+	if err := ints.Set(context.Background(), "key", 1); !errors.As(err, &remoteErr) {
+		t.Fatalf("Expected a remote error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ints.Set(ctx, "key", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	ints.Close()
+	if err := ints.Set(context.Background(), "key", 1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+
+	if _, err := Dial[string, string]("127.0.0.1:1", WithDialTimeout(time.Second)); err == nil {
+		t.Fatal("Expected Dial to fail for an unreachable server")
+	}
+}
+
+func TestRemoteCacheConcurrentDial(t *testing.T) {
+	local, addr := serve(t)
+	ctx := context.Background()
+
+	// Concurrent first calls dial the empty pool slots at the same time
+	remote, err := Dial[string, string](addr, WithPoolSize(4))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := remote.Set(ctx, fmt.Sprint(i), "v"); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := local.Len(); n != 32 {
+		t.Fatalf("Expected 32 keys, got %d", n)
+	}
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	for i, cn := range remote.pool {
+		if cn == nil || remote.dialing[i] != nil {
+			t.Fatalf("Expected slot %d to hold a connection and no dial", i)
+		}
+	}
+}
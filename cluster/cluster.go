@@ -0,0 +1,383 @@
+// Package cluster spreads a synapse cache over several nodes. Keys are
+// placed on nodes with a consistent-hash ring and reads and writes are
+// forwarded to the owning node over a pluggable Transport. Similarity
+// searches are sent to every node and the results merged by score.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/codec"
+)
+
+var (
+	// ErrNoNodes is returned when the ring has no nodes
+	ErrNoNodes = errors.New("cluster: no nodes")
+	// ErrFilterUnsupported is returned by GetSimilar when given a
+	// WithMetadataFilter option, as functions cannot be sent to other nodes
+	ErrFilterUnsupported = errors.New("cluster: metadata filters cannot be forwarded; use WithMetadataMatch")
+)
+
+// Options contains configuration options for a Cluster
+type Options struct {
+	// VirtualNodes is the number of points each node has on the ring
+	VirtualNodes int
+	// Codec encodes keys and values
+	Codec codec.Codec
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithVirtualNodes sets the number of points each node has on the ring
+func WithVirtualNodes(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.VirtualNodes = n
+		}
+	}
+}
+
+// WithCodec sets the codec used to encode keys and values. Every node must
+// use the same codec, since keys are placed on the ring by their encoding.
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Codec = c
+		}
+	}
+}
+
+// Cluster is a cache spread over several nodes. Each node runs a Cluster
+// around its local cache and serves Handle to the others.
+type Cluster[K comparable, V any] struct {
+	self      string
+	local     *synapse.Cache[K, V]
+	transport Transport
+	ring      *Ring
+	options   *Options
+}
+
+// New creates the cluster member self, storing its share of the keys in
+// local and reaching the other nodes over transport
+func New[K comparable, V any](self string, local *synapse.Cache[K, V], transport Transport, opts ...Option) *Cluster[K, V] {
+	options := &Options{
+		VirtualNodes: 128,
+		Codec:        codec.Gob{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	c := &Cluster[K, V]{
+		self:      self,
+		local:     local,
+		transport: transport,
+		ring:      NewRing(options.VirtualNodes),
+		options:   options,
+	}
+	c.ring.Add(self)
+	return c
+}
+
+// Self returns the name of this node
+func (c *Cluster[K, V]) Self() string {
+	return c.self
+}
+
+// Local returns the cache holding this node's share of the keys
+func (c *Cluster[K, V]) Local() *synapse.Cache[K, V] {
+	return c.local
+}
+
+// AddNode adds a node to the ring. Keys it now owns are not moved; they are
+// missed until written again.
+func (c *Cluster[K, V]) AddNode(node string) {
+	c.ring.Add(node)
+}
+
+// RemoveNode removes a node from the ring
+func (c *Cluster[K, V]) RemoveNode(node string) {
+	c.ring.Remove(node)
+}
+
+// Nodes returns the sorted names of the nodes on the ring
+func (c *Cluster[K, V]) Nodes() []string {
+	return c.ring.Nodes()
+}
+
+// Owner returns the node owning key
+func (c *Cluster[K, V]) Owner(key K) (string, error) {
+	_, node, err := c.locate(key)
+	return node, err
+}
+
+// locate encodes key and finds its owner
+func (c *Cluster[K, V]) locate(key K) ([]byte, string, error) {
+	data, err := c.options.Codec.Marshal(key)
+	if err != nil {
+		return nil, "", err
+	}
+	node, ok := c.ring.Get(data)
+	if !ok {
+		return nil, "", ErrNoNodes
+	}
+	return data, node, nil
+}
+
+// Get retrieves a value from the node owning key
+func (c *Cluster[K, V]) Get(ctx context.Context, key K) (V, bool, error) {
+	var zero V
+
+	data, node, err := c.locate(key)
+	if err != nil {
+		return zero, false, err
+	}
+	if node == c.self {
+		v, ok := c.local.Get(ctx, key)
+		return v, ok, nil
+	}
+
+	resp, err := c.transport.Call(ctx, node, Request{
+		Op:        OpGet,
+		Namespace: synapse.GetNamespace(ctx),
+		Key:       data,
+	})
+	if err != nil || !resp.Found {
+		return zero, false, err
+	}
+
+	var v V
+	if err := c.options.Codec.Unmarshal(resp.Value, &v); err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// Set stores a value on the node owning key
+func (c *Cluster[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	data, node, err := c.locate(key)
+	if err != nil {
+		return err
+	}
+	if node == c.self {
+		return c.local.Set(ctx, key, value, opts...)
+	}
+
+	options := synapse.NewSetOptions(ctx, opts...)
+	req := Request{
+		Op:        OpSet,
+		Namespace: synapse.GetNamespace(ctx),
+		Key:       data,
+		Metadata:  options.Metadata,
+		Tags:      options.Tags,
+		TTL:       int64(options.TTL),
+		KeepTTL:   options.KeepTTL,
+		IfNewer:   options.IfNewer,
+	}
+	if !options.UpdatedAt.IsZero() {
+		req.UpdatedAt = options.UpdatedAt.UnixNano()
+	}
+	if req.Value, err = c.options.Codec.Marshal(value); err != nil {
+		return err
+	}
+
+	resp, err := c.transport.Call(ctx, node, req)
+	if err != nil {
+		return err
+	}
+	if resp.Stale {
+		return synapse.ErrStale
+	}
+	return nil
+}
+
+// Delete removes a key from the node owning it
+func (c *Cluster[K, V]) Delete(ctx context.Context, key K) (bool, error) {
+	data, node, err := c.locate(key)
+	if err != nil {
+		return false, err
+	}
+	if node == c.self {
+		return c.local.Delete(ctx, key), nil
+	}
+
+	resp, err := c.transport.Call(ctx, node, Request{
+		Op:        OpDelete,
+		Namespace: synapse.GetNamespace(ctx),
+		Key:       data,
+	})
+	return resp.Found, err
+}
+
+// GetSimilar searches every node for the most similar key above its
+// threshold and returns the best match. Nodes that fail are skipped and
+// their errors are returned along with the best match of the others.
+func (c *Cluster[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool, error) {
+	var (
+		zeroV V
+		zeroK K
+	)
+
+	options := synapse.NewSimilarOptions(opts...)
+	if options.Filter != nil {
+		return zeroV, zeroK, 0, false, ErrFilterUnsupported
+	}
+
+	data, err := c.options.Codec.Marshal(key)
+	if err != nil {
+		return zeroV, zeroK, 0, false, err
+	}
+
+	nodes := c.ring.Nodes()
+	if len(nodes) == 0 {
+		return zeroV, zeroK, 0, false, ErrNoNodes
+	}
+
+	type result struct {
+		resp Response
+		err  error
+	}
+	results := make([]result, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if node == c.self {
+				results[i].resp, results[i].err = c.similarLocal(ctx, key, options.Match)
+				return
+			}
+			results[i].resp, results[i].err = c.transport.Call(ctx, node, Request{
+				Op:        OpSimilar,
+				Namespace: synapse.GetNamespace(ctx),
+				Key:       data,
+				Match:     options.Match,
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Merge by score; ties go to the first node in name order so that every
+	// caller sees the same winner
+	var (
+		best  Response
+		found bool
+		errs  []error
+	)
+	for i, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("cluster: node %s: %w", nodes[i], r.err))
+			continue
+		}
+		if r.resp.Found && (!found || r.resp.Score > best.Score) {
+			best = r.resp
+			found = true
+		}
+	}
+	if !found {
+		return zeroV, zeroK, 0, false, errors.Join(errs...)
+	}
+
+	var (
+		v V
+		k K
+	)
+	if err := c.options.Codec.Unmarshal(best.Value, &v); err != nil {
+		return zeroV, zeroK, 0, false, err
+	}
+	if err := c.options.Codec.Unmarshal(best.Key, &k); err != nil {
+		return zeroV, zeroK, 0, false, err
+	}
+	return v, k, best.Score, true, errors.Join(errs...)
+}
+
+// similarLocal searches the local cache and encodes the match as a Response
+func (c *Cluster[K, V]) similarLocal(ctx context.Context, key K, match map[string]any) (Response, error) {
+	opts := make([]synapse.SimilarOption, 0, len(match))
+	for k, v := range match {
+		opts = append(opts, synapse.WithMetadataMatch(k, v))
+	}
+
+	entry, score, ok := c.local.GetSimilarEntry(ctx, key, opts...)
+	if !ok {
+		return Response{}, nil
+	}
+
+	resp := Response{Found: true, Score: score}
+	var err error
+	if resp.Key, err = c.options.Codec.Marshal(entry.Key); err != nil {
+		return Response{}, err
+	}
+	if resp.Value, err = c.options.Codec.Marshal(entry.Value); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// Handle serves a request forwarded by another node against the local
+// cache. Register it with the transport's server, e.g. ListenTCP.
+func (c *Cluster[K, V]) Handle(ctx context.Context, req Request) (Response, error) {
+	var key K
+	if err := c.options.Codec.Unmarshal(req.Key, &key); err != nil {
+		return Response{}, err
+	}
+	ctx = synapse.WithNamespace(ctx, req.Namespace)
+
+	switch req.Op {
+	case OpGet:
+		v, ok := c.local.Get(ctx, key)
+		if !ok {
+			return Response{}, nil
+		}
+		data, err := c.options.Codec.Marshal(v)
+		if err != nil {
+			return Response{}, err
+		}
+		return Response{Found: true, Value: data}, nil
+
+	case OpSet:
+		var v V
+		if err := c.options.Codec.Unmarshal(req.Value, &v); err != nil {
+			return Response{}, err
+		}
+		opts := []synapse.SetOption{synapse.WithTags(req.Tags...)}
+		for k, val := range req.Metadata {
+			opts = append(opts, synapse.WithEntryMetadata(k, val))
+		}
+		if req.UpdatedAt != 0 {
+			opts = append(opts, synapse.WithUpdateTime(time.Unix(0, req.UpdatedAt)))
+		}
+		if req.TTL > 0 {
+			opts = append(opts, synapse.WithEntryTTL(time.Duration(req.TTL)))
+		}
+		if req.KeepTTL {
+			opts = append(opts, synapse.KeepTTL())
+		}
+		if req.IfNewer {
+			opts = append(opts, synapse.IfNewer())
+		}
+		// A stale write is reported in the response, as errors do not keep
+		// their identity over every transport
+		err := c.local.Set(ctx, key, v, opts...)
+		if errors.Is(err, synapse.ErrStale) {
+			return Response{Stale: true}, nil
+		}
+		return Response{}, err
+
+	case OpDelete:
+		return Response{Found: c.local.Delete(ctx, key)}, nil
+
+	case OpSimilar:
+		return c.similarLocal(ctx, key, req.Match)
+
+	default:
+		return Response{}, fmt.Errorf("cluster: unknown op %d", req.Op)
+	}
+}
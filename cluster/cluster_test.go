@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+)
+
+// newNode creates a cluster member with its own local cache
+func newNode(self string, transport Transport) *Cluster[string, string] {
+	cache := synapse.New[string, string](synapse.WithThreshold(0.7))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	return New(self, cache, transport)
+}
+
+// memoryCluster creates n nodes connected over a MemoryNetwork
+func memoryCluster(n int) []*Cluster[string, string] {
+	network := NewMemoryNetwork()
+	nodes := make([]*Cluster[string, string], n)
+	for i := range nodes {
+		nodes[i] = newNode(fmt.Sprintf("node%d", i), network)
+		network.Handle(nodes[i].Self(), nodes[i].Handle)
+	}
+	for _, node := range nodes {
+		for _, peer := range nodes {
+			node.AddNode(peer.Self())
+		}
+	}
+	return nodes
+}
+
+func TestClusterForwarding(t *testing.T) {
+	ctx := synapse.WithNamespace(context.Background(), "tenant")
+	nodes := memoryCluster(3)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := nodes[i%3].Set(ctx, key, "value", synapse.WithTags("t")); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// Every key lives only on its owner and is readable from any node
+	total := 0
+	for _, node := range nodes {
+		n := node.Local().NamespaceLen("tenant")
+		if n == 0 {
+			t.Errorf("Node %s owns no keys", node.Self())
+		}
+		total += n
+	}
+	if total != 100 {
+		t.Fatalf("Expected 100 keys across nodes, got %d", total)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		v, ok, err := nodes[(i+1)%3].Get(ctx, key)
+		if err != nil || !ok || v != "value" {
+			t.Fatalf("Get(%s) = %q, %v, %v", key, v, ok, err)
+		}
+
+		owner, _ := nodes[0].Owner(key)
+		for _, node := range nodes {
+			entry, ok := node.Local().Peek(ctx, key)
+			if ok != (node.Self() == owner) {
+				t.Fatalf("Key %s found on %s, owner is %s", key, node.Self(), owner)
+			}
+			if ok && len(entry.Tags) != 1 {
+				t.Fatalf("Expected tags to be forwarded, got %v", entry.Tags)
+			}
+		}
+	}
+
+	// Namespaces are forwarded with the request
+	if _, ok, _ := nodes[0].Get(context.Background(), "key1"); ok {
+		t.Fatal("Expected key to be scoped to its namespace")
+	}
+
+	deleted, err := nodes[1].Delete(ctx, "key1")
+	if err != nil || !deleted {
+		t.Fatalf("Delete = %v, %v", deleted, err)
+	}
+	if _, ok, _ := nodes[2].Get(ctx, "key1"); ok {
+		t.Fatal("Expected key to be deleted")
+	}
+}
+
+func TestClusterGetSimilar(t *testing.T) {
+	ctx := context.Background()
+	nodes := memoryCluster(3)
+
+	keys := []string{"hello world", "hello there", "goodbye world", "something else"}
+	for _, key := range keys {
+		nodes[0].Set(ctx, key, "v:"+key, synapse.WithEntryMetadata("lang", "en"))
+	}
+
+	for _, node := range nodes {
+		v, k, score, ok, err := node.GetSimilar(ctx, "hello worle")
+		if err != nil || !ok {
+			t.Fatalf("GetSimilar failed on %s: %v, %v", node.Self(), ok, err)
+		}
+		if k != "hello world" || v != "v:hello world" || score < 0.9 {
+			t.Fatalf("Expected best match across nodes, got %q (%f)", k, score)
+		}
+	}
+
+	if _, _, _, ok, _ := nodes[1].GetSimilar(ctx, "hello worle", synapse.WithMetadataMatch("lang", "fr")); ok {
+		t.Fatal("Expected metadata match to be forwarded")
+	}
+
+	_, _, _, _, err := nodes[1].GetSimilar(ctx, "hello", synapse.WithMetadataFilter(func(map[string]any) bool { return true }))
+	if !errors.Is(err, ErrFilterUnsupported) {
+		t.Fatalf("Expected ErrFilterUnsupported, got %v", err)
+	}
+}
+
+func TestClusterPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	nodes := memoryCluster(2)
+	nodes[0].AddNode("unreachable")
+
+	nodes[0].Local().Set(ctx, "hello world", "v")
+
+	_, k, _, ok, err := nodes[0].GetSimilar(ctx, "hello worle")
+	if !ok || k != "hello world" {
+		t.Fatalf("Expected results from reachable nodes, got %q, %v", k, ok)
+	}
+	if !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("Expected error from unreachable node, got %v", err)
+	}
+}
+
+func TestClusterForwardsWriteOptions(t *testing.T) {
+	ctx := context.Background()
+	nodes := memoryCluster(2)
+
+	// Find a key owned by the other node
+	key := ""
+	for i := 0; key == ""; i++ {
+		if owner, _ := nodes[0].Owner(fmt.Sprint(i)); owner == nodes[1].Self() {
+			key = fmt.Sprint(i)
+		}
+	}
+
+	updated := time.Now().Add(-time.Minute)
+	if err := nodes[0].Set(ctx, key, "v", synapse.WithEntryTTL(time.Hour), synapse.WithUpdateTime(updated)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	entry, ok := nodes[1].Local().Peek(ctx, key)
+	if !ok {
+		t.Fatal("Expected the key on its owner")
+	}
+	if time.Until(entry.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("Expected the entry TTL to be forwarded, got expiry %v", entry.ExpiresAt)
+	}
+	if !entry.UpdatedAt.Equal(updated) {
+		t.Fatalf("Expected update time %v, got %v", updated, entry.UpdatedAt)
+	}
+
+	if err := nodes[0].Set(ctx, key, "v2", synapse.KeepTTL()); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if kept, _ := nodes[1].Local().Peek(ctx, key); !kept.ExpiresAt.Equal(entry.ExpiresAt) {
+		t.Fatalf("Expected KeepTTL to be forwarded, got expiry %v", kept.ExpiresAt)
+	}
+
+	err := nodes[0].Set(ctx, key, "old", synapse.IfNewer(), synapse.WithUpdateTime(updated))
+	if !errors.Is(err, synapse.ErrStale) {
+		t.Fatalf("Expected ErrStale for an older IfNewer write, got %v", err)
+	}
+	if v, _ := nodes[1].Local().Get(ctx, key); v != "v2" {
+		t.Fatalf("Expected the stale write to be ignored, got %q", v)
+	}
+}
+
+func TestClusterTCP(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		nodes     []*Cluster[string, string]
+		addrs     []string
+		transport = NewTCPTransport()
+	)
+	defer transport.Close()
+
+	// Node names are their listen addresses, which are only known once the
+	// servers are running, so the handler is bound after listening
+	for i := 0; i < 3; i++ {
+		var node *Cluster[string, string]
+		srv, err := ListenTCP("127.0.0.1:0", func(ctx context.Context, req Request) (Response, error) {
+			return node.Handle(ctx, req)
+		})
+		if err != nil {
+			t.Fatalf("ListenTCP failed: %v", err)
+		}
+		defer srv.Close()
+
+		node = newNode(srv.Addr().String(), transport)
+		nodes = append(nodes, node)
+		addrs = append(addrs, node.Self())
+	}
+	for _, node := range nodes {
+		for _, addr := range addrs {
+			node.AddNode(addr)
+		}
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := nodes[i%3].Set(ctx, key, key); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		v, ok, err := nodes[(i+2)%3].Get(ctx, key)
+		if err != nil || !ok || v != key {
+			t.Fatalf("Get(%s) = %q, %v, %v", key, v, ok, err)
+		}
+	}
+
+	_, k, _, ok, err := nodes[1].GetSimilar(ctx, "key12x")
+	if err != nil || !ok || k != "key12" {
+		t.Fatalf("GetSimilar = %q, %v, %v", k, ok, err)
+	}
+}
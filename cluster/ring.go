@@ -0,0 +1,121 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Ring places keys on nodes with consistent hashing. Each node is placed on
+// the ring at several virtual points so that keys spread evenly and only
+// about 1/n of the keys move when a node joins or leaves.
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int
+	points   []uint64          // Sorted hashes of the virtual nodes
+	owners   map[uint64]string // Virtual node hash to node name
+	nodes    map[string]struct{}
+}
+
+// NewRing creates an empty ring placing each node at replicas virtual points
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+		nodes:    make(map[string]struct{}),
+	}
+}
+
+// Add places a node on the ring
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+	r.nodes[node] = struct{}{}
+
+	for i := 0; i < r.replicas; i++ {
+		h := hashKey([]byte(node + "#" + strconv.Itoa(i)))
+		if _, taken := r.owners[h]; taken {
+			// Extremely unlikely collision; keep the first owner
+			continue
+		}
+		r.owners[h] = node
+		r.points = append(r.points, h)
+	}
+	slices.Sort(r.points)
+}
+
+// Remove takes a node off the ring
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+
+	kept := r.points[:0]
+	for _, h := range r.points {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	r.points = kept
+}
+
+// Get returns the node owning key, or false if the ring is empty
+func (r *Ring) Get(key []byte) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	h := hashKey(key)
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		// Wrap around to the first point
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+// Nodes returns the sorted names of the nodes on the ring
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// hashKey hashes a key onto the ring. FNV-1a is used rather than the seeded
+// hasher of local shards because every node must compute the same placement.
+// Its output is mixed with the murmur3 finalizer since FNV alone spreads
+// short, similar strings such as virtual node names poorly.
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingDistribution(t *testing.T) {
+	ring := NewRing(128)
+	for i := 0; i < 4; i++ {
+		ring.Add(fmt.Sprintf("node%d", i))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		node, ok := ring.Get([]byte(fmt.Sprintf("key%d", i)))
+		if !ok {
+			t.Fatal("Expected an owner")
+		}
+		counts[node]++
+	}
+
+	for node, n := range counts {
+		if n < 1500 || n > 3500 {
+			t.Errorf("Node %s owns %d of 10000 keys, expected roughly 2500", node, n)
+		}
+	}
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	ring := NewRing(128)
+	for i := 0; i < 4; i++ {
+		ring.Add(fmt.Sprintf("node%d", i))
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key], _ = ring.Get([]byte(key))
+	}
+
+	ring.Add("node4")
+
+	moved := 0
+	for key, owner := range before {
+		now, _ := ring.Get([]byte(key))
+		if now != owner {
+			if now != "node4" {
+				t.Fatalf("Key %s moved between existing nodes", key)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 3500 {
+		t.Fatalf("Expected about 2000 keys to move, got %d", moved)
+	}
+
+	ring.Remove("node4")
+	for key, owner := range before {
+		if now, _ := ring.Get([]byte(key)); now != owner {
+			t.Fatalf("Key %s did not return to %s after removal", key, owner)
+		}
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	ring := NewRing(16)
+	if _, ok := ring.Get([]byte("key")); ok {
+		t.Fatal("Expected no owner on an empty ring")
+	}
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/kolosys/synapse/internal/netserve"
+)
+
+// TCPServer serves a node's handler to other nodes over TCP using net/rpc
+type TCPServer struct {
+	ln  net.Listener
+	srv *netserve.Server
+}
+
+// rpcHandler exposes a Handler as a net/rpc service
+type rpcHandler struct {
+	h Handler
+}
+
+// Call is the RPC method invoked by TCPTransport
+func (r *rpcHandler) Call(req Request, resp *Response) error {
+	res, err := r.h(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	*resp = res
+	return nil
+}
+
+// ListenTCP starts serving h on addr
+func ListenTCP(addr string, h Handler) (*TCPServer, error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Node", &rpcHandler{h: h}); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TCPServer{
+		ln: ln,
+		srv: netserve.New(func(conn net.Conn) {
+			srv.ServeConn(conn)
+		}),
+	}
+	go s.srv.Serve(ln)
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *TCPServer) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops the server and closes its connections
+func (s *TCPServer) Close() error {
+	return s.srv.Close()
+}
+
+// TCPTransport is a Transport calling nodes served by TCPServer. Node names
+// are the addresses the nodes listen on. Connections are dialed lazily and
+// re-dialed after a failure on the next call.
+type TCPTransport struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+	closed  bool
+}
+
+// NewTCPTransport creates a transport without connections
+func NewTCPTransport() *TCPTransport {
+	return &TCPTransport{
+		clients: make(map[string]*rpc.Client),
+	}
+}
+
+// Call implements Transport
+func (t *TCPTransport) Call(ctx context.Context, node string, req Request) (Response, error) {
+	client, err := t.client(ctx, node)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp Response
+	call := client.Go("Node.Call", req, &resp, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
+
+	if call.Error != nil {
+		var serverErr rpc.ServerError
+		if !errors.As(call.Error, &serverErr) {
+			// The connection is broken; re-dial on the next call
+			t.drop(node, client)
+		}
+		return Response{}, call.Error
+	}
+	return resp, nil
+}
+
+// Close implements Transport
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+
+	var errs []error
+	for node, client := range t.clients {
+		errs = append(errs, client.Close())
+		delete(t.clients, node)
+	}
+	return errors.Join(errs...)
+}
+
+// client returns a connection to node, dialing it if needed. The dial is
+// made without holding the lock, so a slow node does not delay calls to the
+// others.
+func (t *TCPTransport) client(ctx context.Context, node string) (*rpc.Client, error) {
+	t.mu.Lock()
+	client, ok := t.clients[node]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", node)
+	if err != nil {
+		return nil, err
+	}
+	client = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		client.Close()
+		return nil, rpc.ErrShutdown
+	}
+	if existing, ok := t.clients[node]; ok {
+		// Another call connected first
+		client.Close()
+		return existing, nil
+	}
+	t.clients[node] = client
+	return client, nil
+}
+
+// drop forgets a broken connection
+func (t *TCPTransport) drop(node string, client *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[node] == client {
+		client.Close()
+		delete(t.clients, node)
+	}
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnknownNode is returned when calling a node the transport cannot reach
+var ErrUnknownNode = errors.New("cluster: unknown node")
+
+// Op identifies the operation of a Request
+type Op uint8
+
+const (
+	// OpGet reads a key
+	OpGet Op = iota
+	// OpSet writes a key
+	OpSet
+	// OpDelete removes a key
+	OpDelete
+	// OpSimilar searches the node's local cache for the most similar key
+	OpSimilar
+)
+
+// Request is a call forwarded to another node. Keys and values are encoded
+// with the cluster's codec.
+type Request struct {
+	Op        Op
+	Namespace string
+	Key       []byte
+	Value     []byte
+	Metadata  map[string]any
+	Tags      []string
+	// UpdatedAt is the write time in Unix nanoseconds, or zero for now
+	UpdatedAt int64
+	// TTL is the entry's time to live in nanoseconds, or zero for the
+	// cache's TTL
+	TTL int64
+	// KeepTTL keeps the expiry of the entry being overwritten
+	KeepTTL bool
+	// IfNewer only writes if UpdatedAt is newer than the stored entry's
+	IfNewer bool
+	// Match holds the metadata constraints of a similarity search
+	Match map[string]any
+}
+
+// Response is the result of a Request
+type Response struct {
+	Found bool
+	Key   []byte
+	Value []byte
+	Score float64
+	// Stale reports an IfNewer write that was not newer than the stored
+	// entry; it is returned as synapse.ErrStale
+	Stale bool
+}
+
+// Handler serves requests forwarded by other nodes
+type Handler func(ctx context.Context, req Request) (Response, error)
+
+// Transport forwards requests to other nodes
+type Transport interface {
+	// Call sends a request to node and waits for its response
+	Call(ctx context.Context, node string, req Request) (Response, error)
+
+	// Close releases the transport's connections
+	Close() error
+}
+
+// MemoryNetwork connects in-process nodes, e.g. for tests
+type MemoryNetwork struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewMemoryNetwork creates an empty in-process network
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler serving requests for node
+func (n *MemoryNetwork) Handle(node string, h Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[node] = h
+}
+
+// Unhandle removes a node from the network
+func (n *MemoryNetwork) Unhandle(node string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.handlers, node)
+}
+
+// Call implements Transport
+func (n *MemoryNetwork) Call(ctx context.Context, node string, req Request) (Response, error) {
+	n.mu.RLock()
+	h, ok := n.handlers[node]
+	n.mu.RUnlock()
+
+	if !ok {
+		return Response{}, ErrUnknownNode
+	}
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	return h(ctx, req)
+}
+
+// Close implements Transport
+func (n *MemoryNetwork) Close() error {
+	return nil
+}
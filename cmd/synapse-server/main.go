@@ -0,0 +1,160 @@
+// Command synapse-server serves a similarity cache over HTTP. See the server
+// package for the endpoints.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+	"github.com/kolosys/synapse/eviction"
+	"github.com/kolosys/synapse/metrics"
+	"github.com/kolosys/synapse/resp"
+	"github.com/kolosys/synapse/server"
+	"github.com/kolosys/synapse/wire"
+)
+
+func main() {
+	var (
+		addr        = flag.String("addr", ":8080", "address to listen on")
+		respAddr    = flag.String("resp-addr", "", "address to serve the Redis protocol on; empty disables it")
+		wireAddr    = flag.String("wire-addr", "", "address to serve the binary protocol on; empty disables it")
+		shards      = flag.Int("shards", 16, "number of shards")
+		maxSize     = flag.Int("max-size", 1000, "maximum number of entries")
+		threshold   = flag.Float64("threshold", 0.8, "minimum similarity score of a match")
+		ttl         = flag.Duration("ttl", 0, "default entry TTL; 0 disables expiration")
+		stats       = flag.Bool("stats", true, "collect statistics")
+		policy      = flag.String("eviction", "none", "eviction policy: none, lru or ttl")
+		similarity  = flag.String("similarity", "levenshtein", "similarity function: levenshtein, damerau or hamming")
+		quota       = flag.Int("namespace-quota", 0, "maximum number of entries per namespace; 0 means no limit")
+		maxValue    = flag.Int64("max-value-size", 1<<20, "maximum value size in bytes")
+		nsHeader    = flag.String("namespace-header", server.DefaultNamespaceHeader, "header carrying the namespace")
+		shutdownTTL = flag.Duration("shutdown-timeout", 10*time.Second, "time allowed for in-flight requests on shutdown")
+		metricsPath = flag.String("metrics-path", "/metrics", "path serving Prometheus metrics; empty disables it")
+		logLevel    = flag.String("log-level", "info", "cache log level: debug, info, warn or error")
+		slowSearch  = flag.Duration("slow-similarity", 0, "log similarity searches slower than this; 0 disables it")
+	)
+	flag.Parse()
+
+	if *quota > 0 && *quota < *shards {
+		log.Fatal("-namespace-quota must be at least -shards, as the quota is enforced per shard")
+	}
+
+	fn, err := similarityFunc(*similarity)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("invalid -log-level: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	opts := []synapse.Option{
+		synapse.WithLogger(logger),
+		synapse.WithSlowSimilarityThreshold(*slowSearch),
+		synapse.WithShards(*shards),
+		synapse.WithMaxSize(*maxSize),
+		synapse.WithThreshold(*threshold),
+		synapse.WithTTL(*ttl),
+		synapse.WithStats(*stats),
+		synapse.WithDefaultNamespaceQuota(*quota),
+	}
+	switch *policy {
+	case "none":
+	case "lru":
+		opts = append(opts, synapse.WithEviction(eviction.NewLRU(*maxSize)))
+	case "ttl":
+		if *ttl <= 0 {
+			log.Fatal("-eviction=ttl requires a positive -ttl")
+		}
+		opts = append(opts, synapse.WithEviction(eviction.NewTTL(*ttl)))
+	default:
+		log.Fatalf("unknown eviction policy %q", *policy)
+	}
+
+	cache := synapse.New[string, []byte](opts...)
+	cache.WithSimilarity(fn)
+
+	var handler http.Handler = server.New(cache,
+		server.WithMaxValueSize(*maxValue),
+		server.WithNamespaceHeader(*nsHeader),
+	)
+	if *metricsPath != "" {
+		exporter := metrics.NewHandler()
+		exporter.Register("default", cache)
+
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		mux.Handle("GET "+*metricsPath, exporter)
+		handler = mux
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *respAddr != "" {
+		respSrv := resp.New(cache, resp.WithMaxValueSize(int(*maxValue)))
+		go func() {
+			log.Printf("synapse-server serving RESP on %s", *respAddr)
+			if err := respSrv.ListenAndServe(*respAddr); err != nil && !errors.Is(err, resp.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		defer respSrv.Close()
+	}
+
+	if *wireAddr != "" {
+		wireSrv := wire.NewServer(cache)
+		go func() {
+			log.Printf("synapse-server serving the binary protocol on %s", *wireAddr)
+			if err := wireSrv.ListenAndServe(*wireAddr); err != nil && !errors.Is(err, wire.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		defer wireSrv.Close()
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTTL)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("synapse-server listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// similarityFunc returns the similarity function with the given name
+func similarityFunc(name string) (synapse.SimilarityFunc[string], error) {
+	switch name {
+	case "levenshtein":
+		return algorithms.Levenshtein, nil
+	case "damerau":
+		return algorithms.DamerauLevenshtein, nil
+	case "hamming":
+		return algorithms.Hamming, nil
+	default:
+		return nil, fmt.Errorf("unknown similarity function %q", name)
+	}
+}
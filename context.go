@@ -9,6 +9,7 @@ type contextKey int
 const (
 	namespaceKey contextKey = iota
 	metadataKey
+	originKey
 )
 
 // WithNamespace adds a namespace to the context
@@ -54,3 +55,18 @@ func getMetadata(ctx context.Context) map[string]any {
 	}
 	return nil
 }
+
+// WithOrigin marks writes made with the context as coming from origin.
+// The origin is reported on the resulting events, which lets components such
+// as replicators tell their own writes apart from application writes.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey, origin)
+}
+
+// GetOrigin retrieves the origin from the context
+func GetOrigin(ctx context.Context) string {
+	if origin, ok := ctx.Value(originKey).(string); ok {
+		return origin
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package decorators
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/synapsetest"
+)
+
+var (
+	_ synapse.Store[string, string] = (*synapse.Cache[string, string])(nil)
+	_ synapse.Store[string, string] = (*Metrics[string, string])(nil)
+	_ synapse.Store[string, string] = (*Logging[string, string])(nil)
+	_ synapse.Store[string, string] = (*ReadThrough[string, string])(nil)
+	_ synapse.Store[string, string] = (*WriteThrough[string, string])(nil)
+	_ synapse.Store[string, string] = (*Namespaced[string, string])(nil)
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	m := NewMetrics[string, string](synapsetest.NewFake[string, string]())
+
+	m.Set(ctx, "a", "1")
+	m.Get(ctx, "a")
+	m.Get(ctx, "b")
+	m.GetSimilar(ctx, "a")
+	m.Delete(ctx, "a")
+
+	s := m.Snapshot()
+	if s.Gets != 2 || s.Hits != 1 || s.Sets != 1 || s.Deletes != 1 || s.SimilarSearches != 1 || s.SimilarHits != 1 {
+		t.Fatalf("Unexpected metrics %+v", s)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	m.Set(cancelled, "a", "1")
+	if s := m.Snapshot(); s.SetErrors != 1 {
+		t.Fatalf("Expected a set error, got %+v", s)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	l := NewLogging[string, string](synapsetest.NewFake[string, string](), logger)
+
+	ctx := synapse.WithNamespace(context.Background(), "tenant")
+	l.Set(ctx, "secret-1", "1")
+	l.Get(ctx, "secret-1")
+	l.GetSimilar(ctx, "secret-1")
+
+	out := buf.String()
+	if !strings.Contains(out, `msg="synapse get"`) || !strings.Contains(out, "namespace=tenant") || !strings.Contains(out, "hit=true") {
+		t.Fatalf("Unexpected log output:\n%s", out)
+	}
+	if strings.Contains(out, "secret-1") {
+		t.Fatalf("Expected keys to be hashed, got:\n%s", out)
+	}
+	if hash := synapse.KeyHashAttr("secret-1").String(); !strings.Contains(out, hash) {
+		t.Fatalf("Expected %s in the log output:\n%s", hash, out)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	fake := synapsetest.NewFake[string, string]()
+
+	loads := 0
+	errBackend := errors.New("backend down")
+	var reported error
+	r := NewReadThrough[string, string](fake, func(ctx context.Context, key string) (string, bool, error) {
+		loads++
+		switch key {
+		case "broken":
+			return "", false, errBackend
+		case "missing":
+			return "", false, nil
+		}
+		return "loaded:" + key, true, nil
+	}, func(err error) { reported = err })
+
+	for i := 0; i < 2; i++ {
+		if v, ok := r.Get(ctx, "a"); !ok || v != "loaded:a" {
+			t.Fatalf("Get = %q, %v", v, ok)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("Expected the loaded value to be cached, got %d loads", loads)
+	}
+
+	if _, ok := r.Get(ctx, "missing"); ok {
+		t.Fatal("Expected a miss for a key the loader does not know")
+	}
+	if _, ok := r.Get(ctx, "broken"); ok || !errors.Is(reported, errBackend) {
+		t.Fatalf("Expected loader error to be reported, got %v", reported)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	fake := synapsetest.NewFake[string, string]()
+
+	written := make(map[string]string)
+	w := NewWriteThrough[string, string](fake, func(ctx context.Context, key, value string) error {
+		if key == "readonly" {
+			return errors.New("read-only key")
+		}
+		written[key] = value
+		return nil
+	})
+
+	if err := w.Set(ctx, "a", "1"); err != nil || written["a"] != "1" {
+		t.Fatalf("Expected write to backing store, got %v", err)
+	}
+	if v, ok := fake.Get(ctx, "a"); !ok || v != "1" {
+		t.Fatal("Expected value to be cached")
+	}
+
+	if err := w.Set(ctx, "readonly", "1"); err == nil {
+		t.Fatal("Expected write error")
+	}
+	if _, ok := fake.Get(ctx, "readonly"); ok {
+		t.Fatal("Expected failed write not to be cached")
+	}
+}
+
+func TestNamespaced(t *testing.T) {
+	cache := synapse.New[string, string]()
+	a := NewNamespaced[string, string](cache, "tenant-a/")
+	b := NewNamespaced[string, string](cache, "tenant-b/")
+
+	ctx := synapse.WithNamespace(context.Background(), "docs")
+	a.Set(ctx, "key", "a")
+	b.Set(ctx, "key", "b")
+
+	if v, _ := a.Get(ctx, "key"); v != "a" {
+		t.Fatalf("Expected tenant-a value, got %q", v)
+	}
+	if v, _ := cache.Get(synapse.WithNamespace(context.Background(), "tenant-b/docs"), "key"); v != "b" {
+		t.Fatalf("Expected prefixed namespace, got %q", v)
+	}
+	if _, ok := cache.Get(ctx, "key"); ok {
+		t.Fatal("Expected no entry in the unprefixed namespace")
+	}
+}
+
+func TestStacking(t *testing.T) {
+	cache := synapse.New[string, string]()
+	var store synapse.Store[string, string] = NewMetrics(NewNamespaced[string, string](cache, "app/"))
+
+	store.Set(context.Background(), "key", "value")
+	if v, ok := store.Get(context.Background(), "key"); !ok || v != "value" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	if cache.NamespaceLen("app/") != 1 {
+		t.Fatal("Expected entry in the prefixed namespace")
+	}
+}
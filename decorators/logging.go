@@ -0,0 +1,90 @@
+package decorators
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+// Logging logs every call made to a store at debug level, and failed writes
+// at error level. Keys are logged as key_hash, like the cache's own records,
+// never in the clear.
+type Logging[K comparable, V any] struct {
+	store  synapse.Store[K, V]
+	logger *slog.Logger
+}
+
+// NewLogging wraps store to log its calls to logger, or to slog.Default if
+// logger is nil
+func NewLogging[K comparable, V any](store synapse.Store[K, V], logger *slog.Logger) *Logging[K, V] {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Logging[K, V]{store: store, logger: logger}
+}
+
+// Get implements synapse.Store
+func (l *Logging[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	start := time.Now()
+	v, ok := l.store.Get(ctx, key)
+	l.logger.DebugContext(ctx, "synapse get",
+		"namespace", synapse.GetNamespace(ctx),
+		synapse.KeyHashAttr(key),
+		"hit", ok,
+		"duration", time.Since(start),
+	)
+	return v, ok
+}
+
+// Set implements synapse.Store
+func (l *Logging[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	start := time.Now()
+	err := l.store.Set(ctx, key, value, opts...)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "synapse set failed",
+			"namespace", synapse.GetNamespace(ctx),
+			synapse.KeyHashAttr(key),
+			"error", err,
+		)
+		return err
+	}
+	l.logger.DebugContext(ctx, "synapse set",
+		"namespace", synapse.GetNamespace(ctx),
+		synapse.KeyHashAttr(key),
+		"duration", time.Since(start),
+	)
+	return nil
+}
+
+// GetSimilar implements synapse.Store
+func (l *Logging[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	start := time.Now()
+	v, k, score, ok := l.store.GetSimilar(ctx, key, opts...)
+	l.logger.DebugContext(ctx, "synapse get similar",
+		"namespace", synapse.GetNamespace(ctx),
+		synapse.KeyHashAttr(key),
+		"hit", ok,
+		"match_hash", synapse.KeyHashAttr(k).Value,
+		"score", score,
+		"duration", time.Since(start),
+	)
+	return v, k, score, ok
+}
+
+// Delete implements synapse.Store
+func (l *Logging[K, V]) Delete(ctx context.Context, key K) bool {
+	ok := l.store.Delete(ctx, key)
+	l.logger.DebugContext(ctx, "synapse delete",
+		"namespace", synapse.GetNamespace(ctx),
+		synapse.KeyHashAttr(key),
+		"deleted", ok,
+	)
+	return ok
+}
+
+// Len implements synapse.Store
+func (l *Logging[K, V]) Len() int {
+	return l.store.Len()
+}
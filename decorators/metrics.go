@@ -0,0 +1,115 @@
+// Package decorators wraps a synapse.Store with additional behavior, such as
+// metrics, logging, loading from and writing to a backing store, or namespace
+// prefixing. Wrappers implement synapse.Store themselves and can be stacked.
+package decorators
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+// MetricsSnapshot holds the counters recorded by Metrics
+type MetricsSnapshot struct {
+	Gets            uint64
+	Hits            uint64
+	Sets            uint64
+	SetErrors       uint64
+	Deletes         uint64
+	SimilarSearches uint64
+	SimilarHits     uint64
+	// GetTime, SetTime and SimilarTime are the total time spent in each
+	// kind of call
+	GetTime     time.Duration
+	SetTime     time.Duration
+	SimilarTime time.Duration
+}
+
+// Metrics counts the calls made to a store and the time spent in them
+type Metrics[K comparable, V any] struct {
+	store synapse.Store[K, V]
+
+	gets            atomic.Uint64
+	hits            atomic.Uint64
+	sets            atomic.Uint64
+	setErrors       atomic.Uint64
+	deletes         atomic.Uint64
+	similarSearches atomic.Uint64
+	similarHits     atomic.Uint64
+	getTime         atomic.Int64
+	setTime         atomic.Int64
+	similarTime     atomic.Int64
+}
+
+// NewMetrics wraps store to record metrics
+func NewMetrics[K comparable, V any](store synapse.Store[K, V]) *Metrics[K, V] {
+	return &Metrics[K, V]{store: store}
+}
+
+// Get implements synapse.Store
+func (m *Metrics[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	start := time.Now()
+	v, ok := m.store.Get(ctx, key)
+	m.getTime.Add(int64(time.Since(start)))
+
+	m.gets.Add(1)
+	if ok {
+		m.hits.Add(1)
+	}
+	return v, ok
+}
+
+// Set implements synapse.Store
+func (m *Metrics[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	start := time.Now()
+	err := m.store.Set(ctx, key, value, opts...)
+	m.setTime.Add(int64(time.Since(start)))
+
+	m.sets.Add(1)
+	if err != nil {
+		m.setErrors.Add(1)
+	}
+	return err
+}
+
+// GetSimilar implements synapse.Store
+func (m *Metrics[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	start := time.Now()
+	v, k, score, ok := m.store.GetSimilar(ctx, key, opts...)
+	m.similarTime.Add(int64(time.Since(start)))
+
+	m.similarSearches.Add(1)
+	if ok {
+		m.similarHits.Add(1)
+	}
+	return v, k, score, ok
+}
+
+// Delete implements synapse.Store
+func (m *Metrics[K, V]) Delete(ctx context.Context, key K) bool {
+	m.deletes.Add(1)
+	return m.store.Delete(ctx, key)
+}
+
+// Len implements synapse.Store
+func (m *Metrics[K, V]) Len() int {
+	return m.store.Len()
+}
+
+// Snapshot returns the current counters
+func (m *Metrics[K, V]) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Gets:            m.gets.Load(),
+		Hits:            m.hits.Load(),
+		Sets:            m.sets.Load(),
+		SetErrors:       m.setErrors.Load(),
+		Deletes:         m.deletes.Load(),
+		SimilarSearches: m.similarSearches.Load(),
+		SimilarHits:     m.similarHits.Load(),
+		GetTime:         time.Duration(m.getTime.Load()),
+		SetTime:         time.Duration(m.setTime.Load()),
+		SimilarTime:     time.Duration(m.similarTime.Load()),
+	}
+}
@@ -0,0 +1,51 @@
+package decorators
+
+import (
+	"context"
+
+	"github.com/kolosys/synapse"
+)
+
+// Namespaced prefixes the namespace of every call, isolating the callers of
+// the wrapper from other users of the store, e.g. one wrapper per tenant
+type Namespaced[K comparable, V any] struct {
+	store  synapse.Store[K, V]
+	prefix string
+}
+
+// NewNamespaced wraps store so that calls made in namespace ns use namespace
+// prefix+ns instead
+func NewNamespaced[K comparable, V any](store synapse.Store[K, V], prefix string) *Namespaced[K, V] {
+	return &Namespaced[K, V]{store: store, prefix: prefix}
+}
+
+// scope returns ctx with its namespace prefixed
+func (n *Namespaced[K, V]) scope(ctx context.Context) context.Context {
+	return synapse.WithNamespace(ctx, n.prefix+synapse.GetNamespace(ctx))
+}
+
+// Get implements synapse.Store
+func (n *Namespaced[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	return n.store.Get(n.scope(ctx), key)
+}
+
+// Set implements synapse.Store
+func (n *Namespaced[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	return n.store.Set(n.scope(ctx), key, value, opts...)
+}
+
+// GetSimilar implements synapse.Store
+func (n *Namespaced[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	return n.store.GetSimilar(n.scope(ctx), key, opts...)
+}
+
+// Delete implements synapse.Store
+func (n *Namespaced[K, V]) Delete(ctx context.Context, key K) bool {
+	return n.store.Delete(n.scope(ctx), key)
+}
+
+// Len implements synapse.Store. It counts the entries of the whole store, as
+// the wrapper cannot tell which namespaces belong to it.
+func (n *Namespaced[K, V]) Len() int {
+	return n.store.Len()
+}
@@ -0,0 +1,113 @@
+package decorators
+
+import (
+	"context"
+
+	"github.com/kolosys/synapse"
+)
+
+// LoadFunc loads the value of a key missing from the cache. It is the same
+// type as synapse.LoadFunc, so loaders can be shared with GetOrLoad.
+type LoadFunc[K comparable, V any] = synapse.LoadFunc[K, V]
+
+// WriteFunc writes a value to the system of record
+type WriteFunc[K comparable, V any] func(ctx context.Context, key K, value V) error
+
+// ReadThrough loads missing keys with a LoadFunc and caches them
+type ReadThrough[K comparable, V any] struct {
+	store   synapse.Store[K, V]
+	load    LoadFunc[K, V]
+	onError func(error)
+}
+
+// NewReadThrough wraps store to load missing keys with load. Load errors are
+// treated as misses and passed to onError, which may be nil.
+func NewReadThrough[K comparable, V any](store synapse.Store[K, V], load LoadFunc[K, V], onError func(error)) *ReadThrough[K, V] {
+	return &ReadThrough[K, V]{store: store, load: load, onError: onError}
+}
+
+// Get implements synapse.Store. A missing key is loaded and stored in the
+// cache before it is returned.
+func (r *ReadThrough[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	if v, ok := r.store.Get(ctx, key); ok {
+		return v, true
+	}
+
+	var zero V
+	v, ok, err := r.load(ctx, key)
+	if err != nil {
+		if r.onError != nil {
+			r.onError(err)
+		}
+		return zero, false
+	}
+	if !ok {
+		return zero, false
+	}
+
+	if err := r.store.Set(ctx, key, v); err != nil && r.onError != nil {
+		r.onError(err)
+	}
+	return v, true
+}
+
+// Set implements synapse.Store
+func (r *ReadThrough[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	return r.store.Set(ctx, key, value, opts...)
+}
+
+// GetSimilar implements synapse.Store. Similarity searches only consider
+// cached entries.
+func (r *ReadThrough[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	return r.store.GetSimilar(ctx, key, opts...)
+}
+
+// Delete implements synapse.Store
+func (r *ReadThrough[K, V]) Delete(ctx context.Context, key K) bool {
+	return r.store.Delete(ctx, key)
+}
+
+// Len implements synapse.Store
+func (r *ReadThrough[K, V]) Len() int {
+	return r.store.Len()
+}
+
+// WriteThrough writes values to the system of record before caching them
+type WriteThrough[K comparable, V any] struct {
+	store synapse.Store[K, V]
+	write WriteFunc[K, V]
+}
+
+// NewWriteThrough wraps store to write every value with write first. The
+// value is only cached if write succeeds.
+func NewWriteThrough[K comparable, V any](store synapse.Store[K, V], write WriteFunc[K, V]) *WriteThrough[K, V] {
+	return &WriteThrough[K, V]{store: store, write: write}
+}
+
+// Get implements synapse.Store
+func (w *WriteThrough[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	return w.store.Get(ctx, key)
+}
+
+// Set implements synapse.Store
+func (w *WriteThrough[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	if err := w.write(ctx, key, value); err != nil {
+		return err
+	}
+	return w.store.Set(ctx, key, value, opts...)
+}
+
+// GetSimilar implements synapse.Store
+func (w *WriteThrough[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	return w.store.GetSimilar(ctx, key, opts...)
+}
+
+// Delete implements synapse.Store. Only the cached entry is removed.
+func (w *WriteThrough[K, V]) Delete(ctx context.Context, key K) bool {
+	return w.store.Delete(ctx, key)
+}
+
+// Len implements synapse.Store
+func (w *WriteThrough[K, V]) Len() int {
+	return w.store.Len()
+}
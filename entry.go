@@ -1,6 +1,8 @@
 package synapse
 
 import (
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -9,11 +11,13 @@ type Entry[K comparable, V any] struct {
 	Key         K
 	Value       V
 	CreatedAt   time.Time
+	UpdatedAt   time.Time
 	AccessedAt  time.Time
 	AccessCount uint64
 	ExpiresAt   time.Time
 	Metadata    map[string]any
 	Namespace   string
+	Tags        []string
 }
 
 // newEntry creates a new cache entry
@@ -23,6 +27,7 @@ func newEntry[K comparable, V any](key K, value V, ttl time.Duration, namespace
 		Key:         key,
 		Value:       value,
 		CreatedAt:   now,
+		UpdatedAt:   now,
 		AccessedAt:  now,
 		AccessCount: 0,
 		Namespace:   namespace,
@@ -49,3 +54,11 @@ func (e *Entry[K, V]) Touch() {
 	e.AccessedAt = time.Now()
 	e.AccessCount++
 }
+
+// clone returns a copy of the entry that does not share its metadata map
+func (e *Entry[K, V]) clone() Entry[K, V] {
+	c := *e
+	c.Metadata = maps.Clone(e.Metadata)
+	c.Tags = slices.Clone(e.Tags)
+	return c
+}
@@ -0,0 +1,230 @@
+package synapse
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of change reported by an Event
+type EventType int
+
+const (
+	// EventSet is emitted when a new entry is stored
+	EventSet EventType = iota
+	// EventUpdate is emitted when an existing entry is overwritten
+	EventUpdate
+	// EventDelete is emitted when an entry is removed by Delete, Clear,
+	// PurgeNamespace, DeleteFunc or InvalidateTag
+	EventDelete
+	// EventEvict is emitted when an entry is evicted to make room
+	EventEvict
+	// EventExpire is emitted when an expired entry is removed
+	EventExpire
+	// EventSimilarHit is emitted when GetSimilar finds a match
+	EventSimilarHit
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventEvict:
+		return "evict"
+	case EventExpire:
+		return "expire"
+	case EventSimilarHit:
+		return "similar_hit"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to the cache
+type Event[K comparable, V any] struct {
+	Type EventType
+	// Entry is a copy of the affected entry. Its metadata and tags are shared
+	// with other copies and must not be modified.
+	Entry Entry[K, V]
+	// Reason is set for EventDelete, EventEvict and EventExpire
+	Reason EvictionReason
+	// Query is the searched key for EventSimilarHit
+	Query K
+	// Score is the similarity score for EventSimilarHit
+	Score float64
+	// Time is when the change happened. For EventSet and EventUpdate it is
+	// the entry's UpdatedAt.
+	Time time.Time
+	// Origin is the origin of the write that caused the change, as set with
+	// WithOrigin
+	Origin string
+}
+
+// EventFilter selects the events delivered to a subscriber
+type EventFilter[K comparable, V any] func(Event[K, V]) bool
+
+// SubscribeOptions contains options for Subscribe
+type SubscribeOptions struct {
+	// BufferSize is the capacity of the subscriber's channel
+	BufferSize int
+	// Block makes publishers wait for buffer space instead of dropping events
+	Block bool
+	// OnDrop is called for every event dropped because the buffer was full
+	OnDrop func()
+}
+
+// SubscribeOption is a function that modifies SubscribeOptions
+type SubscribeOption func(*SubscribeOptions)
+
+// WithBufferSize sets the capacity of the subscriber's channel
+func WithBufferSize(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		if n >= 0 {
+			o.BufferSize = n
+		}
+	}
+}
+
+// WithBlocking makes the cache wait for the subscriber to receive each event
+// instead of dropping events when its buffer is full. Writes are delayed
+// until the event is delivered, so a slow subscriber slows down the cache.
+func WithBlocking() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Block = true
+	}
+}
+
+// WithDropHandler sets a function called for every event dropped because the
+// subscriber's buffer was full. It runs on the writing goroutine and must not
+// block.
+func WithDropHandler(fn func()) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.OnDrop = fn
+	}
+}
+
+// Subscribe returns a channel of cache events accepted by filter, or of all
+// events if filter is nil. By default events are dropped when the channel's
+// buffer is full; see WithBlocking. The channel is closed once ctx is done.
+//
+// Events are delivered after the shard lock has been released. Events from a
+// single shard are delivered in the order they happened, while events from
+// different shards may interleave.
+func (c *Cache[K, V]) Subscribe(ctx context.Context, filter EventFilter[K, V], opts ...SubscribeOption) <-chan Event[K, V] {
+	options := &SubscribeOptions{BufferSize: 64}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sub := &subscription[K, V]{
+		ch:     make(chan Event[K, V], options.BufferSize),
+		filter: filter,
+		block:  options.Block,
+		onDrop: options.OnDrop,
+		done:   ctx.Done(),
+	}
+	c.events.add(sub)
+
+	go func() {
+		<-ctx.Done()
+		c.events.remove(sub)
+	}()
+
+	return sub.ch
+}
+
+// eventBus fans out events to subscribers
+type eventBus[K comparable, V any] struct {
+	mu     sync.RWMutex
+	subs   map[*subscription[K, V]]struct{}
+	active atomic.Int32
+}
+
+// subscription is a single subscriber of an eventBus
+type subscription[K comparable, V any] struct {
+	ch     chan Event[K, V]
+	filter EventFilter[K, V]
+	block  bool
+	onDrop func()
+	done   <-chan struct{}
+}
+
+// newEventBus creates an event bus without subscribers
+func newEventBus[K comparable, V any]() *eventBus[K, V] {
+	return &eventBus[K, V]{
+		subs: make(map[*subscription[K, V]]struct{}),
+	}
+}
+
+// enabled reports whether any subscriber is registered
+func (b *eventBus[K, V]) enabled() bool {
+	return b.active.Load() > 0
+}
+
+// add registers a subscriber
+func (b *eventBus[K, V]) add(sub *subscription[K, V]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+	b.active.Add(1)
+}
+
+// remove unregisters a subscriber and closes its channel
+func (b *eventBus[K, V]) remove(sub *subscription[K, V]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+	b.active.Add(-1)
+	close(sub.ch)
+}
+
+// publish delivers an event to every subscriber whose filter accepts it
+func (b *eventBus[K, V]) publish(ev Event[K, V]) {
+	if !b.enabled() {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(ev) {
+			continue
+		}
+
+		if sub.block {
+			select {
+			case sub.ch <- ev:
+			case <-sub.done:
+			}
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+		default:
+			// Drop the event rather than stall the writer
+			if sub.onDrop != nil {
+				sub.onDrop()
+			}
+		}
+	}
+}
+
+// removalEvent returns the event type reported for a removal reason
+func removalEvent(reason EvictionReason) EventType {
+	switch reason {
+	case EvictionReasonCapacity:
+		return EventEvict
+	case EvictionReasonExpired:
+		return EventExpire
+	default:
+		return EventDelete
+	}
+}
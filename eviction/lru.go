@@ -6,23 +6,30 @@ import (
 	"time"
 )
 
-// LRU implements a Least Recently Used eviction policy
+// LRU implements a Least Recently Used eviction policy. Besides the order of
+// all keys, it keeps the order of each group of keys, so that a cache shard
+// finds its own least recently used key without walking the other shards'.
 type LRU struct {
 	mu      sync.RWMutex
 	list    *list.List
-	items   map[any]*list.Element
+	groups  map[int]*list.List
+	items   map[any]*lruEntry
 	maxSize int
 }
 
 type lruEntry struct {
-	key any
+	key   any
+	group int
+	elem  *list.Element // in list
+	gelem *list.Element // in the list of the group
 }
 
 // NewLRU creates a new LRU eviction policy
 func NewLRU(maxSize int) *LRU {
 	return &LRU{
 		list:    list.New(),
-		items:   make(map[any]*list.Element),
+		groups:  make(map[int]*list.List),
+		items:   make(map[any]*lruEntry),
 		maxSize: maxSize,
 	}
 }
@@ -32,8 +39,8 @@ func (l *LRU) OnAccess(key any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if elem, ok := l.items[key]; ok {
-		l.list.MoveToFront(elem)
+	if entry, ok := l.items[key]; ok {
+		l.moveToFront(entry)
 	}
 }
 
@@ -42,14 +49,20 @@ func (l *LRU) OnAdd(key any, accessCount uint64, createdAt, accessedAt time.Time
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if elem, ok := l.items[key]; ok {
-		l.list.MoveToFront(elem)
+	if entry, ok := l.items[key]; ok {
+		l.moveToFront(entry)
 		return
 	}
 
-	entry := &lruEntry{key: key}
-	elem := l.list.PushFront(entry)
-	l.items[key] = elem
+	entry := &lruEntry{key: key, group: groupOf(key)}
+	group := l.groups[entry.group]
+	if group == nil {
+		group = list.New()
+		l.groups[entry.group] = group
+	}
+	entry.elem = l.list.PushFront(entry)
+	entry.gelem = group.PushFront(entry)
+	l.items[key] = entry
 }
 
 // OnRemove implements EvictionPolicy
@@ -57,12 +70,19 @@ func (l *LRU) OnRemove(key any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if elem, ok := l.items[key]; ok {
-		l.list.Remove(elem)
+	if entry, ok := l.items[key]; ok {
+		l.list.Remove(entry.elem)
+		l.groups[entry.group].Remove(entry.gelem)
 		delete(l.items, key)
 	}
 }
 
+// moveToFront marks an entry as the most recently used
+func (l *LRU) moveToFront(entry *lruEntry) {
+	l.list.MoveToFront(entry.elem)
+	l.groups[entry.group].MoveToFront(entry.gelem)
+}
+
 // SelectVictim implements EvictionPolicy
 func (l *LRU) SelectVictim() (any, bool) {
 	l.mu.RLock()
@@ -81,6 +101,42 @@ func (l *LRU) SelectVictim() (any, bool) {
 	return entry.key, true
 }
 
+// SelectVictimFunc implements ScopedPolicy
+// It returns the least recently used key accepted by accept
+func (l *LRU) SelectVictimFunc(accept func(key any) bool) (any, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for elem := l.list.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*lruEntry)
+		if accept(entry.key) {
+			return entry.key, true
+		}
+	}
+
+	return nil, false
+}
+
+// SelectVictimIn implements GroupedPolicy
+// It returns the least recently used key of group accepted by accept
+func (l *LRU) SelectVictimIn(group int, accept func(key any) bool) (any, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	keys := l.groups[group]
+	if keys == nil {
+		return nil, false
+	}
+	for elem := keys.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*lruEntry)
+		if accept(entry.key) {
+			return entry.key, true
+		}
+	}
+
+	return nil, false
+}
+
 // Len implements EvictionPolicy
 func (l *LRU) Len() int {
 	l.mu.RLock()
@@ -0,0 +1,91 @@
+package eviction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLRUSelectVictimFunc(t *testing.T) {
+	lru := NewLRU(10)
+	now := time.Now()
+
+	lru.OnAdd("a", 0, now, now)
+	lru.OnAdd("b", 0, now, now)
+	lru.OnAdd("c", 0, now, now)
+
+	victim, ok := lru.SelectVictimFunc(func(key any) bool {
+		return key != "a"
+	})
+	if !ok || victim != "b" {
+		t.Errorf("Expected victim b, got %v", victim)
+	}
+
+	_, ok = lru.SelectVictimFunc(func(key any) bool {
+		return false
+	})
+	if ok {
+		t.Error("Expected no victim when nothing is accepted")
+	}
+}
+
+// groupedKey is a key in an eviction group
+type groupedKey struct {
+	name  string
+	group int
+}
+
+func (k groupedKey) EvictionGroup() int { return k.group }
+
+func TestLRUSelectVictimIn(t *testing.T) {
+	lru := NewLRU(10)
+	now := time.Now()
+
+	// The least recently used keys all belong to group 1
+	for i := range 100 {
+		lru.OnAdd(groupedKey{fmt.Sprint("b", i), 1}, 0, now, now)
+	}
+	lru.OnAdd(groupedKey{"a1", 0}, 0, now, now)
+	lru.OnAdd(groupedKey{"a2", 0}, 0, now, now)
+	lru.OnAccess(groupedKey{"a1", 0})
+
+	visited := 0
+	victim, ok := lru.SelectVictimIn(0, func(key any) bool {
+		visited++
+		return true
+	})
+	if !ok || victim != (groupedKey{"a2", 0}) {
+		t.Fatalf("Expected victim a2, got %v", victim)
+	}
+	if visited != 1 {
+		t.Fatalf("Expected only the keys of group 0 to be visited, got %d", visited)
+	}
+
+	lru.OnRemove(groupedKey{"a2", 0})
+	if victim, _ := lru.SelectVictimIn(0, func(any) bool { return true }); victim != (groupedKey{"a1", 0}) {
+		t.Fatalf("Expected victim a1 after removing a2, got %v", victim)
+	}
+	if victim, _ := lru.SelectVictim(); victim != (groupedKey{"b0", 1}) {
+		t.Fatalf("Expected the overall victim b0, got %v", victim)
+	}
+	if _, ok := lru.SelectVictimIn(2, func(any) bool { return true }); ok {
+		t.Fatal("Expected no victim in an empty group")
+	}
+}
+
+func TestCombinedPolicyUnscopedFallback(t *testing.T) {
+	// A policy without SelectVictimFunc
+	first := struct{ EvictionPolicy }{NewLRU(10)}
+	combined := NewCombinedPolicy([]EvictionPolicy{first}, []float64{1})
+	now := time.Now()
+
+	combined.OnAdd("a", 0, now, now)
+	combined.OnAdd("b", 0, now, now)
+
+	if victim, ok := combined.SelectVictimFunc(func(any) bool { return true }); !ok || victim != "a" {
+		t.Fatalf("Expected the unscoped victim a, got %v", victim)
+	}
+	if _, ok := combined.SelectVictimFunc(func(key any) bool { return key != "a" }); ok {
+		t.Fatal("Expected no victim when the unscoped victim is not accepted")
+	}
+}
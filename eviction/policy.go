@@ -22,6 +22,38 @@ type EvictionPolicy interface {
 	Len() int
 }
 
+// ScopedPolicy is implemented by eviction policies that can choose a victim
+// from a subset of the tracked keys, such as the keys of a single namespace
+type ScopedPolicy interface {
+	// SelectVictimFunc returns the key of the entry to evict among the keys
+	// for which accept returns true
+	SelectVictimFunc(accept func(key any) bool) (any, bool)
+}
+
+// GroupedKey is implemented by keys that belong to a group, such as the
+// cache shard holding them
+type GroupedKey interface {
+	EvictionGroup() int
+}
+
+// GroupedPolicy is implemented by eviction policies that keep the keys of
+// each group apart, so that choosing a victim for one group does not visit
+// the keys of the others. Keys that do not implement GroupedKey are in
+// group 0.
+type GroupedPolicy interface {
+	// SelectVictimIn returns the key of the entry to evict among the keys
+	// of group for which accept returns true
+	SelectVictimIn(group int, accept func(key any) bool) (any, bool)
+}
+
+// groupOf returns the group of a key
+func groupOf(key any) int {
+	if k, ok := key.(GroupedKey); ok {
+		return k.EvictionGroup()
+	}
+	return 0
+}
+
 // CombinedPolicy combines multiple eviction policies with weighted scoring
 type CombinedPolicy struct {
 	policies []EvictionPolicy
@@ -80,6 +112,34 @@ func (c *CombinedPolicy) SelectVictim() (any, bool) {
 	return c.policies[0].SelectVictim()
 }
 
+// SelectVictimFunc implements ScopedPolicy
+// It uses the first policy's victim selection if that policy is scoped, and
+// otherwise its unscoped victim if accept accepts it
+func (c *CombinedPolicy) SelectVictimFunc(accept func(key any) bool) (any, bool) {
+	if len(c.policies) == 0 {
+		return nil, false
+	}
+	if scoped, ok := c.policies[0].(ScopedPolicy); ok {
+		return scoped.SelectVictimFunc(accept)
+	}
+	if victim, ok := c.policies[0].SelectVictim(); ok && accept(victim) {
+		return victim, true
+	}
+	return nil, false
+}
+
+// SelectVictimIn implements GroupedPolicy
+// It uses the first policy's victim selection if that policy is grouped, and
+// otherwise falls back to SelectVictimFunc
+func (c *CombinedPolicy) SelectVictimIn(group int, accept func(key any) bool) (any, bool) {
+	if len(c.policies) > 0 {
+		if grouped, ok := c.policies[0].(GroupedPolicy); ok {
+			return grouped.SelectVictimIn(group, accept)
+		}
+	}
+	return c.SelectVictimFunc(accept)
+}
+
 // Len implements EvictionPolicy
 func (c *CombinedPolicy) Len() int {
 	if len(c.policies) == 0 {
@@ -100,6 +100,31 @@ func (t *TTL) SelectVictim() (any, bool) {
 	return nil, false
 }
 
+// SelectVictimFunc implements ScopedPolicy
+// It returns an expired key accepted by accept or, if none has expired, the
+// accepted key closest to expiring, so that a full cache can always make room
+func (t *TTL) SelectVictimFunc(accept func(key any) bool) (any, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	var victim any
+	var soonest time.Time
+	for key, expiry := range t.items {
+		if !accept(key) {
+			continue
+		}
+		if now.After(expiry) {
+			return key, true
+		}
+		if victim == nil || expiry.Before(soonest) {
+			victim, soonest = key, expiry
+		}
+	}
+
+	return victim, victim != nil
+}
+
 // Len implements EvictionPolicy
 func (t *TTL) Len() int {
 	t.mu.RLock()
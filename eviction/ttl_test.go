@@ -48,3 +48,22 @@ func TestTTLRemove(t *testing.T) {
 		t.Errorf("Expected length 0, got %d", ttl.Len())
 	}
 }
+
+func TestTTLSelectVictimFuncUnexpired(t *testing.T) {
+	ttl := NewTTL(time.Hour)
+	defer ttl.Close()
+
+	now := time.Now()
+	ttl.OnAdd("old", 0, now.Add(-time.Minute), now)
+	ttl.OnAdd("new", 0, now, now)
+
+	// Nothing has expired, so the key closest to expiring is chosen
+	victim, ok := ttl.SelectVictimFunc(func(any) bool { return true })
+	if !ok || victim != "old" {
+		t.Fatalf("Expected victim old, got %v", victim)
+	}
+	victim, ok = ttl.SelectVictimFunc(func(key any) bool { return key == "new" })
+	if !ok || victim != "new" {
+		t.Fatalf("Expected victim new, got %v", victim)
+	}
+}
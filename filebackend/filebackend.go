@@ -0,0 +1,155 @@
+// Package filebackend is a synapse.Backend storing each entry in its own file,
+// meant as a reference implementation and for tests that run offline
+package filebackend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/codec"
+)
+
+// defaultNamespaceDir is the directory of the default namespace ""
+const defaultNamespaceDir = "_default"
+
+// Options contains configuration options for a Backend
+type Options struct {
+	// Codec encodes keys and values
+	Codec codec.Codec
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithCodec sets the codec used to encode keys and values
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Codec = c
+		}
+	}
+}
+
+// Backend stores entries below a directory, one subdirectory per namespace
+// and one file per key. Files are named after a hash of the encoded key and
+// are replaced atomically on write.
+type Backend[K comparable, V any] struct {
+	dir     string
+	options *Options
+}
+
+// New creates a backend storing entries below dir, creating it if needed
+func New[K comparable, V any](dir string, opts ...Option) (*Backend[K, V], error) {
+	options := &Options{
+		Codec: codec.Gob{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Backend[K, V]{dir: dir, options: options}, nil
+}
+
+// Load implements synapse.Backend
+func (b *Backend[K, V]) Load(ctx context.Context, key K) (V, bool, error) {
+	var zero V
+
+	path, err := b.path(ctx, key)
+	if err != nil {
+		return zero, false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+
+	var v V
+	if err := b.options.Codec.Unmarshal(data, &v); err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// Store implements synapse.Backend
+func (b *Backend[K, V]) Store(ctx context.Context, key K, value V) error {
+	path, err := b.path(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	data, err := b.options.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so readers never see a
+	// partially written value
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete implements synapse.Backend
+func (b *Backend[K, V]) Delete(ctx context.Context, key K) error {
+	path, err := b.path(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path returns the file of a key in the context's namespace
+func (b *Backend[K, V]) path(ctx context.Context, key K) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	data, err := b.options.Codec.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	namespace := synapse.GetNamespace(ctx)
+	dir := defaultNamespaceDir
+	if namespace != "" {
+		// Escaping keeps namespaces from reaching outside the directory
+		dir = "ns-" + url.PathEscape(namespace)
+	}
+	return filepath.Join(b.dir, dir, hex.EncodeToString(sum[:])), nil
+}
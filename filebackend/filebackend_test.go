@@ -0,0 +1,65 @@
+package filebackend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+func TestBackendRoundTrip(t *testing.T) {
+	b, err := New[string, string](t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tenant := synapse.WithNamespace(ctx, "../tenant")
+
+	if _, ok, err := b.Load(ctx, "key"); ok || err != nil {
+		t.Fatalf("Expected missing key, got %v, %v", ok, err)
+	}
+
+	b.Store(ctx, "key", "default")
+	b.Store(tenant, "key", "tenant")
+
+	if v, ok, _ := b.Load(ctx, "key"); !ok || v != "default" {
+		t.Fatalf("Load = %q, %v", v, ok)
+	}
+	if v, ok, _ := b.Load(tenant, "key"); !ok || v != "tenant" {
+		t.Fatalf("Expected namespaces to be stored apart, got %q", v)
+	}
+
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Expected deleting a missing key to succeed, got %v", err)
+	}
+	if _, ok, _ := b.Load(ctx, "key"); ok {
+		t.Fatal("Expected key to be deleted")
+	}
+}
+
+func TestBackendWithCache(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	b, _ := New[string, int](dir)
+	cache := synapse.New[string, int](
+		synapse.WithBackend[string, int](b),
+		synapse.WithReadThrough(),
+		synapse.WithWriteBehind(10*time.Millisecond),
+	)
+	cache.Set(ctx, "answer", 42)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new cache over the same directory loads the value
+	b, _ = New[string, int](dir)
+	cache = synapse.New[string, int](synapse.WithBackend[string, int](b), synapse.WithReadThrough())
+	if v, ok := cache.Get(ctx, "answer"); !ok || v != 42 {
+		t.Fatalf("Get = %d, %v", v, ok)
+	}
+}
@@ -0,0 +1,36 @@
+package synapse
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// Hasher maps a key to the hash that picks its shard. Equal keys must hash
+// equally; hashes need not be stable across processes.
+type Hasher[K comparable] func(key K) uint64
+
+// newHasher returns the hasher set with WithHasher, or the built-in hasher
+// seeded with a fresh seed
+func newHasher[K comparable](options *Options) Hasher[K] {
+	if options.Hasher != nil {
+		hasher, ok := options.Hasher.(Hasher[K])
+		if !ok {
+			var k K
+			panic(fmt.Sprintf("synapse: hasher %T does not hash %T keys", options.Hasher, k))
+		}
+		return hasher
+	}
+
+	seed := maphash.MakeSeed()
+	var zero K
+	if _, ok := any(zero).(string); ok {
+		return func(key K) uint64 {
+			return maphash.String(seed, any(key).(string))
+		}
+	}
+	// Integers, byte arrays and other keys are hashed by their value as map
+	// keys are, so struct fields are never confused with each other
+	return func(key K) uint64 {
+		return maphash.Comparable(seed, key)
+	}
+}
@@ -0,0 +1,265 @@
+package synapse
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a snapshot of a distribution of observed values
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets, ascending
+	Bounds []float64
+	// Counts holds the observations per bucket, with one more entry than
+	// Bounds for observations above the last bound
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// Histograms contains the latency and score distributions of a cache or
+// shard. They are kept apart from Stats, which only holds counters that are
+// cheap to snapshot.
+type Histograms struct {
+	// GetLatency is the distribution of exact lookup durations in seconds
+	GetLatency Histogram
+	// SimilarLatency is the distribution of GetSimilar durations in seconds;
+	// for a single shard, of the time spent searching that shard
+	SimilarLatency Histogram
+	// SimilarScores is the distribution of the scores of similarity hits
+	SimilarScores Histogram
+	// NearMissScores is the distribution of the best scores of similarity
+	// searches that found no match, i.e. scores just below the threshold
+	NearMissScores Histogram
+}
+
+// Sub returns the observations made since prev, an earlier snapshot of the
+// same cache, so that percentiles can be computed over an interval
+func (h Histograms) Sub(prev Histograms) Histograms {
+	return Histograms{
+		GetLatency:     h.GetLatency.sub(prev.GetLatency),
+		SimilarLatency: h.SimilarLatency.sub(prev.SimilarLatency),
+		SimilarScores:  h.SimilarScores.sub(prev.SimilarScores),
+		NearMissScores: h.NearMissScores.sub(prev.NearMissScores),
+	}
+}
+
+// Mean returns the average of the observed values
+func (h Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// Quantile estimates the value below which a fraction q of the observations
+// fall, e.g. 0.99 for the 99th percentile, interpolating linearly within the
+// bucket holding it. Observations above the last bound are reported as the
+// last bound.
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	q = min(max(q, 0), 1)
+
+	rank := q * float64(h.Count)
+	var cumulative uint64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		if float64(cumulative+n) >= rank {
+			if i == len(h.Bounds) {
+				return h.Bounds[i-1]
+			}
+			lower := 0.0
+			if i > 0 {
+				lower = h.Bounds[i-1]
+			}
+			return lower + (h.Bounds[i]-lower)*(rank-float64(cumulative))/float64(n)
+		}
+		cumulative += n
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Rebucket returns the histogram with its observations counted against
+// coarser bounds, as exporters with a fixed bucket layout need. Each bucket
+// is counted under the first bound not below its own upper bound, so counts
+// are exact for bounds that coincide with bucket bounds and otherwise lean
+// towards the larger value.
+func (h Histogram) Rebucket(bounds []float64) Histogram {
+	out := Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+		Count:  h.Count,
+		Sum:    h.Sum,
+	}
+	j := 0
+	for i, n := range h.Counts {
+		if i == len(h.Bounds) {
+			out.Counts[len(bounds)] += n
+			break
+		}
+		// Tolerate rounding in bounds computed from integer units
+		for j < len(bounds) && bounds[j] < h.Bounds[i]*(1-1e-9) {
+			j++
+		}
+		out.Counts[j] += n
+	}
+	return out
+}
+
+// add accumulates the observations of other into h
+func (h *Histogram) add(other Histogram) {
+	if h.Counts == nil {
+		if other.Counts == nil {
+			return
+		}
+		h.Bounds = other.Bounds
+		h.Counts = make([]uint64, len(other.Counts))
+	}
+	for i, n := range other.Counts {
+		h.Counts[i] += n
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// sub returns the observations of h made since the earlier snapshot prev
+func (h Histogram) sub(prev Histogram) Histogram {
+	if prev.Counts == nil {
+		return h
+	}
+	out := Histogram{
+		Bounds: h.Bounds,
+		Counts: make([]uint64, len(h.Counts)),
+		Count:  h.Count - prev.Count,
+		Sum:    h.Sum - prev.Sum,
+	}
+	for i := range h.Counts {
+		out.Counts[i] = h.Counts[i] - prev.Counts[i]
+	}
+	return out
+}
+
+// Latency histograms use HDR-style log-linear buckets over nanoseconds: every
+// power of two is split into latencySubBuckets linear buckets, so a bucket is
+// never wider than 1/latencySubBuckets of the values it holds
+const (
+	latencySubBits    = 3
+	latencySubBuckets = 1 << latencySubBits
+	// latencyMaxExp caps tracked latencies at 2^latencyMaxExp ns, about 69s;
+	// longer ones share the overflow bucket
+	latencyMaxExp = 36
+)
+
+// latencyBounds are the exclusive upper bounds of the latency buckets, in
+// seconds
+var latencyBounds = func() []float64 {
+	bounds := make([]float64, 0, latencySubBuckets*(latencyMaxExp-latencySubBits+1))
+	// Values below latencySubBuckets ns each have their own bucket
+	for ns := 1; ns <= latencySubBuckets; ns++ {
+		bounds = append(bounds, float64(ns)/1e9)
+	}
+	for exp := latencySubBits; exp < latencyMaxExp; exp++ {
+		shift := exp - latencySubBits
+		for sub := 1; sub <= latencySubBuckets; sub++ {
+			bounds = append(bounds, float64(uint64(latencySubBuckets+sub)<<shift)/1e9)
+		}
+	}
+	return bounds
+}()
+
+// latencyIndex returns the bucket of a latency in seconds
+func latencyIndex(seconds float64) int {
+	if seconds <= 0 {
+		return 0
+	}
+	ns := uint64(math.Round(seconds * 1e9))
+	if ns < latencySubBuckets {
+		return int(ns)
+	}
+	exp := bits.Len64(ns) - 1
+	if exp >= latencyMaxExp {
+		return len(latencyBounds)
+	}
+	shift := exp - latencySubBits
+	sub := int(ns>>shift) - latencySubBuckets
+	return latencySubBuckets + (exp-latencySubBits)*latencySubBuckets + sub
+}
+
+// scoreBounds are the inclusive upper bounds of the similarity score
+// buckets, in steps of 0.01
+var scoreBounds = func() []float64 {
+	bounds := make([]float64, 101)
+	for i := range bounds {
+		bounds[i] = float64(i) / 100
+	}
+	return bounds
+}()
+
+// scoreIndex returns the bucket of a similarity score
+func scoreIndex(score float64) int {
+	i, _ := slices.BinarySearch(scoreBounds, score)
+	return i
+}
+
+// histogram collects observations into fixed buckets without locking
+type histogram struct {
+	bounds []float64
+	index  func(float64) int
+	counts []atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+// newLatencyHistogram creates a histogram of durations
+func newLatencyHistogram() *histogram {
+	return &histogram{
+		bounds: latencyBounds,
+		index:  latencyIndex,
+		counts: make([]atomic.Uint64, len(latencyBounds)+1),
+	}
+}
+
+// newScoreHistogram creates a histogram of similarity scores
+func newScoreHistogram() *histogram {
+	return &histogram{
+		bounds: scoreBounds,
+		index:  scoreIndex,
+		counts: make([]atomic.Uint64, len(scoreBounds)+1),
+	}
+}
+
+// observe records a value
+func (h *histogram) observe(v float64) {
+	h.counts[h.index(v)].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// observeSince records the time elapsed since start in seconds
+func (h *histogram) observeSince(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+// snapshot returns a snapshot of the histogram. The count is derived from
+// the buckets so that the two always agree.
+func (h *histogram) snapshot() Histogram {
+	snap := Histogram{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    math.Float64frombits(h.sum.Load()),
+	}
+	for i := range h.counts {
+		snap.Counts[i] = h.counts[i].Load()
+		snap.Count += snap.Counts[i]
+	}
+	return snap
+}
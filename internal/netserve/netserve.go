@@ -0,0 +1,124 @@
+// Package netserve runs the accept loops shared by the synapse network
+// servers. It tracks listeners and connections so that Close can stop them
+// and wait for every connection handler to return.
+package netserve
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Serve after Close
+var ErrClosed = errors.New("netserve: server closed")
+
+// maxAcceptDelay caps the back-off after failed accepts
+const maxAcceptDelay = time.Second
+
+// Server serves the connections accepted on its listeners, each in its own
+// goroutine
+type Server struct {
+	handle func(net.Conn)
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// New creates a server calling handle for every accepted connection. The
+// connection is closed once handle returns.
+func New(handle func(net.Conn)) *Server {
+	return &Server{
+		handle:    handle,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on ln until Close is called, in which case it
+// returns ErrClosed, or until ln is closed by someone else. Other accept
+// errors, such as running out of file descriptors, are retried with a
+// growing delay.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.mu.Lock()
+				delete(s.listeners, ln)
+				s.mu.Unlock()
+				return err
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes all connections and waits for Serve and
+// the connection handlers to return
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var errs []error
+	for ln := range s.listeners {
+		errs = append(errs, ln.Close())
+		delete(s.listeners, ln)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// serveConn runs the handler of a connection and forgets the connection once
+// it returns
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	s.handle(conn)
+}
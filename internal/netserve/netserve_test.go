@@ -0,0 +1,70 @@
+package netserve
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	handled := make(chan struct{})
+	srv := New(func(conn net.Conn) {
+		close(handled)
+		io.Copy(io.Discard, conn) // until Close closes the connection
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-handled
+
+	// Close waits for the handler, which only returns once its connection
+	// is closed
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from Serve, got %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the client connection to be closed")
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Second Close failed: %v", err)
+	}
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(ln2); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from Serve after Close, got %v", err)
+	}
+}
+
+func TestServerListenerError(t *testing.T) {
+	srv := New(func(net.Conn) {})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A listener closed from outside ends Serve with its error
+	ln.Close()
+	if err := srv.Serve(ln); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expected net.ErrClosed, got %v", err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package synapse
+
+import (
+	"context"
+)
+
+// EvictionReason describes why an entry was removed from the cache
+type EvictionReason int
+
+const (
+	// EvictionReasonCapacity means the entry was evicted to make room
+	EvictionReasonCapacity EvictionReason = iota
+	// EvictionReasonDeleted means the entry was removed by Delete or DeleteMany
+	EvictionReasonDeleted
+	// EvictionReasonCleared means the entry was removed by Clear,
+	// PurgeNamespace, DeleteFunc or InvalidateTag
+	EvictionReasonCleared
+	// EvictionReasonExpired means the entry was removed after its TTL elapsed
+	EvictionReasonExpired
+)
+
+// String returns the name of the eviction reason
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionReasonCapacity:
+		return "capacity"
+	case EvictionReasonDeleted:
+		return "deleted"
+	case EvictionReasonCleared:
+		return "cleared"
+	case EvictionReasonExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictionCallback is called after an entry has been removed from the cache.
+// It runs after the shard lock has been released, on the goroutine that
+// removed the entry or on one still delivering earlier events of the same
+// shard. Callbacks of a shard run one at a time, in the order the entries
+// were removed, and may call back into the cache.
+type EvictionCallback[K comparable, V any] func(key K, value V, reason EvictionReason)
+
+// Clear removes all entries and negatively cached keys from every namespace
+// and returns the number of entries removed. Shards are cleared one at a
+// time; if ctx is cancelled between shards the remaining shards are left
+// untouched. Like InvalidateTag, it only drops cached copies and leaves the
+// backend untouched.
+func (c *Cache[K, V]) Clear(ctx context.Context) int {
+	return c.removeWhere(ctx, func(string) bool {
+		return true
+	}, nil)
+}
+
+// PurgeNamespace removes all entries and negatively cached keys belonging to
+// namespace and returns the number of entries removed. The backend is left
+// untouched.
+func (c *Cache[K, V]) PurgeNamespace(ctx context.Context, namespace string) int {
+	return c.removeWhere(ctx, func(ns string) bool {
+		return ns == namespace
+	}, nil)
+}
+
+// DeleteFunc removes every entry in the context's namespace for which pred
+// returns true and returns the number of entries removed. pred is called with
+// the shard lock held, so it must not call back into the cache or modify the
+// entry's metadata. Removed keys are deleted from the backend as they are
+// with Delete.
+func (c *Cache[K, V]) DeleteFunc(ctx context.Context, pred func(Entry[K, V]) bool) int {
+	namespace := GetNamespace(ctx)
+
+	var removed []K
+	n := c.removeWhere(ctx, func(ns string) bool {
+		return ns == namespace
+	}, func(e *Entry[K, V]) bool {
+		if !pred(*e) {
+			return false
+		}
+		if c.backing != nil {
+			removed = append(removed, e.Key)
+		}
+		return true
+	})
+
+	for _, key := range removed {
+		if err := c.backing.delete(ctx, key); err != nil {
+			c.backing.report(err)
+		}
+	}
+	return n
+}
+
+// InvalidateTag removes every entry in the context's namespace that was
+// stored with tag and returns the number of entries removed. Each shard keeps
+// a reverse tag index, so only the tagged entries are visited. Only cached
+// copies are dropped; the backend is left untouched, so read-through loads
+// the entries again.
+func (c *Cache[K, V]) InvalidateTag(ctx context.Context, tag string) int {
+	namespace := GetNamespace(ctx)
+
+	total := 0
+	for _, shard := range c.shards {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return total
+		default:
+		}
+
+		total += shard.invalidateTag(namespace, tag)
+	}
+	return total
+}
+
+// removeWhere removes matching entries from the accepted namespaces shard by
+// shard, stopping if ctx is cancelled. A nil pred also removes negatively
+// cached keys.
+func (c *Cache[K, V]) removeWhere(ctx context.Context, match func(string) bool, pred func(*Entry[K, V]) bool) int {
+	total := 0
+	for _, shard := range c.shards {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return total
+		default:
+		}
+
+		total += shard.removeWhere(match, pred)
+	}
+	return total
+}
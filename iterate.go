@@ -0,0 +1,63 @@
+package synapse
+
+import (
+	"context"
+	"iter"
+)
+
+// Range calls fn for every live entry visible to the namespace in ctx,
+// stopping early if fn returns false or the context is cancelled.
+//
+// Range is not a point-in-time snapshot of the whole cache. Each shard is
+// copied under its read lock in turn and fn is invoked on the copy after the
+// lock is released, so fn may safely call back into the cache. An entry is
+// visited at most once; writes to a shard that has already been copied are
+// not observed, while writes to shards not yet visited may be. Entries are
+// passed by value and mutating them has no effect on the cache.
+func (c *Cache[K, V]) Range(ctx context.Context, fn func(Entry[K, V]) bool) {
+	namespace := GetNamespace(ctx)
+
+	for _, shard := range c.shards {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		for _, entry := range shard.entries(namespace) {
+			if !fn(entry) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns the keys of all live entries visible to the namespace in ctx.
+// It has the same consistency guarantees as Range.
+func (c *Cache[K, V]) Keys(ctx context.Context) []K {
+	var keys []K
+	c.Range(ctx, func(e Entry[K, V]) bool {
+		keys = append(keys, e.Key)
+		return true
+	})
+	return keys
+}
+
+// All returns an iterator over the key-value pairs visible to the namespace
+// in ctx. It has the same consistency guarantees as Range.
+func (c *Cache[K, V]) All(ctx context.Context) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		c.Range(ctx, func(e Entry[K, V]) bool {
+			return yield(e.Key, e.Value)
+		})
+	}
+}
+
+// Entries returns an iterator over copies of the entries visible to the
+// namespace in ctx. It has the same consistency guarantees as Range.
+func (c *Cache[K, V]) Entries(ctx context.Context) iter.Seq[Entry[K, V]] {
+	return func(yield func(Entry[K, V]) bool) {
+		c.Range(ctx, yield)
+	}
+}
@@ -0,0 +1,81 @@
+package synapse
+
+import "iter"
+
+// keyList holds keys in insertion order with constant time removal. It backs
+// similarity search iteration and oldest-first eviction.
+type keyList[K comparable] struct {
+	head, tail *keyNode[K]
+	nodes      map[K]*keyNode[K]
+}
+
+// keyNode is an element of a keyList
+type keyNode[K comparable] struct {
+	key        K
+	prev, next *keyNode[K]
+}
+
+// newKeyList creates an empty key list
+func newKeyList[K comparable]() *keyList[K] {
+	return &keyList[K]{nodes: make(map[K]*keyNode[K])}
+}
+
+// len returns the number of keys in the list
+func (l *keyList[K]) len() int {
+	return len(l.nodes)
+}
+
+// front returns the oldest key in the list
+func (l *keyList[K]) front() (K, bool) {
+	if l.head == nil {
+		var zero K
+		return zero, false
+	}
+	return l.head.key, true
+}
+
+// pushBack appends a key that is not in the list yet
+func (l *keyList[K]) pushBack(key K) {
+	n := &keyNode[K]{key: key, prev: l.tail}
+	if l.tail != nil {
+		l.tail.next = n
+	} else {
+		l.head = n
+	}
+	l.tail = n
+	l.nodes[key] = n
+}
+
+// remove drops a key from the list, if present
+func (l *keyList[K]) remove(key K) {
+	n, ok := l.nodes[key]
+	if !ok {
+		return
+	}
+	delete(l.nodes, key)
+
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+}
+
+// all returns an iterator over the keys, oldest first. The key being visited
+// may be removed during iteration.
+func (l *keyList[K]) all() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for n := l.head; n != nil; {
+			next := n.next
+			if !yield(n.key) {
+				return
+			}
+			n = next
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package synapse
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LookupStatus reports how a lookup was answered
+type LookupStatus int
+
+const (
+	// LookupMiss means the key is not cached and was not loaded
+	LookupMiss LookupStatus = iota
+	// LookupHit means the value was found in the cache
+	LookupHit
+	// LookupNegative means the key is negatively cached as known absent
+	LookupNegative
+	// LookupLoaded means the value was loaded on a miss and is now cached
+	LookupLoaded
+)
+
+// String returns the name of the lookup status
+func (s LookupStatus) String() string {
+	switch s {
+	case LookupMiss:
+		return "miss"
+	case LookupHit:
+		return "hit"
+	case LookupNegative:
+		return "negative"
+	case LookupLoaded:
+		return "loaded"
+	default:
+		return "unknown"
+	}
+}
+
+// LoadFunc loads the value for a key missing from the cache, returning false
+// if the key does not exist
+type LoadFunc[K comparable, V any] func(ctx context.Context, key K) (V, bool, error)
+
+// GetOrLoad returns the cached value for key, or calls load on a miss and
+// caches the value it returns. A key that load reports missing is negatively
+// cached for the TTL set with WithNegativeTTL, so later calls return
+// LookupNegative without calling load. If load fails, nothing is cached and
+// the error is returned. GetOrLoad does not use the cache's backend, and
+// concurrent misses of the same key each call load.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, load LoadFunc[K, V]) (V, LookupStatus, error) {
+	shard := c.getShard(key)
+	entry, status := shard.lookup(ctx, key)
+	if status != LookupMiss {
+		return entry.Value, status, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return entry.Value, LookupMiss, err
+	}
+
+	v, ok, err := load(ctx, key)
+	if err != nil {
+		if logger := c.options.Logger; logger != nil {
+			logger.LogAttrs(ctx, slog.LevelWarn, "load failed",
+				slog.Int("shard", c.shardIndex(key)),
+				slog.String("namespace", GetNamespace(ctx)),
+				KeyHashAttr(key),
+				slog.Any("error", err),
+			)
+		}
+		var zero V
+		return zero, LookupMiss, err
+	}
+	if !ok {
+		shard.setAbsent(ctx, key)
+		return v, LookupMiss, nil
+	}
+	if err := shard.set(ctx, key, v, NewSetOptions(ctx)); err != nil {
+		return v, LookupLoaded, err
+	}
+	return v, LookupLoaded, nil
+}
@@ -0,0 +1,65 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+)
+
+// KeyHashAttr returns the key_hash log attribute identifying a key by its
+// hash, so that keys holding personal data do not end up in logs. Wrappers
+// logging keys should use it so their records correlate with the cache's.
+func KeyHashAttr[K comparable](key K) slog.Attr {
+	return slog.String("key_hash", fmt.Sprintf("%016x", hashKey(key)))
+}
+
+// hashKey hashes a key for logging. Unlike the shard hasher it is the same
+// in every process, so that records about a key can be correlated across
+// restarts and nodes.
+func hashKey[K comparable](key K) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v", key)
+	return h.Sum64()
+}
+
+// logRemoval logs an eviction or expiration delivered after the shard lock
+// has been released
+func (s *Shard[K, V]) logRemoval(ev Event[K, V]) {
+	var msg string
+	switch ev.Reason {
+	case EvictionReasonCapacity:
+		msg = "entry evicted"
+	case EvictionReasonExpired:
+		msg = "entry expired"
+	default:
+		return
+	}
+
+	ctx := context.Background()
+	if !s.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	s.logger.LogAttrs(ctx, slog.LevelDebug, msg,
+		slog.Int("shard", s.index),
+		slog.String("namespace", ev.Entry.Namespace),
+		KeyHashAttr(ev.Entry.Key),
+	)
+}
+
+// logBackendError logs a failed backend call. The message of a BackendError
+// contains the key, so only its cause is logged, next to the key hash.
+func logBackendError(logger *slog.Logger, err error) {
+	var be *BackendError
+	if !errors.As(err, &be) {
+		logger.LogAttrs(context.Background(), slog.LevelError, "backend error", slog.Any("error", err))
+		return
+	}
+	logger.LogAttrs(context.Background(), slog.LevelError, "backend error",
+		slog.String("op", be.Op),
+		slog.String("namespace", be.Namespace),
+		KeyHashAttr(be.Key),
+		slog.Any("error", be.Err),
+	)
+}
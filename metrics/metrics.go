@@ -0,0 +1,265 @@
+// Package metrics exports synapse cache statistics over HTTP in the
+// Prometheus text exposition format, without depending on a Prometheus
+// client library.
+//
+//	handler := metrics.NewHandler()
+//	handler.Register("users", cache)
+//	http.Handle("/metrics", handler)
+//
+// Every metric is exported per cache, named synapse_cache_*, and per shard,
+// named synapse_shard_* with an additional shard label. Counters and
+// histograms are zero unless the cache was created with WithStats(true).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kolosys/synapse"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Source is a cache whose statistics can be exported. Every *synapse.Cache
+// implements it.
+type Source interface {
+	Stats() synapse.Stats
+	Histograms() synapse.Histograms
+	Shards() []synapse.ShardInfo
+	Len() int
+	Cap() int
+}
+
+// Handler is an http.Handler serving the statistics of registered caches
+type Handler struct {
+	mu     sync.RWMutex
+	caches map[string]Source
+}
+
+// NewHandler creates a handler without any caches
+func NewHandler() *Handler {
+	return &Handler{caches: make(map[string]Source)}
+}
+
+// Register exports the statistics of cache under the cache label name,
+// replacing any cache registered under the same name
+func (h *Handler) Register(name string, cache Source) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.caches[name] = cache
+}
+
+// Unregister stops exporting the cache registered under name
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.caches, name)
+}
+
+// ServeHTTP writes the statistics of every registered cache
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	h.WriteTo(w)
+}
+
+// WriteTo writes the statistics of every registered cache to w
+func (h *Handler) WriteTo(w io.Writer) (int64, error) {
+	snapshots := h.snapshot()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range counters {
+		writeFamily(cw, m.name, m.help, "counter", snapshots, func(name, labels string, s series) {
+			cw.sample(name, labels, strconv.FormatUint(m.value(s.stats), 10))
+		})
+	}
+	for _, m := range gauges {
+		writeFamily(cw, m.name, m.help, "gauge", snapshots, func(name, labels string, s series) {
+			cw.sample(name, labels, strconv.Itoa(m.value(s.len, s.cap)))
+		})
+	}
+	for _, m := range histograms {
+		writeFamily(cw, m.name, m.help, "histogram", snapshots, func(name, labels string, s series) {
+			cw.histogram(name, labels, m.value(s.histograms))
+		})
+	}
+
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// series holds the values exported for one cache or shard
+type series struct {
+	stats      synapse.Stats
+	histograms synapse.Histograms
+	len        int
+	cap        int
+}
+
+// cacheSnapshot holds the statistics of one cache taken for a single scrape
+type cacheSnapshot struct {
+	series
+	name   string
+	shards []synapse.ShardInfo
+}
+
+// snapshot takes the statistics of every registered cache, ordered by name
+func (h *Handler) snapshot() []cacheSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	snapshots := make([]cacheSnapshot, 0, len(h.caches))
+	for name, cache := range h.caches {
+		snapshots = append(snapshots, cacheSnapshot{
+			series: series{
+				stats:      cache.Stats(),
+				histograms: cache.Histograms(),
+				len:        cache.Len(),
+				cap:        cache.Cap(),
+			},
+			name:   name,
+			shards: cache.Shards(),
+		})
+	}
+	slices.SortFunc(snapshots, func(a, b cacheSnapshot) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return snapshots
+}
+
+// counters are the counters exported per cache and per shard
+var counters = []struct {
+	name  string
+	help  string
+	value func(synapse.Stats) uint64
+}{
+	{"hits_total", "Exact lookups that found a value.", func(s synapse.Stats) uint64 { return s.Hits }},
+	{"misses_total", "Exact lookups that found no value.", func(s synapse.Stats) uint64 { return s.Misses }},
+	{"negative_hits_total", "Exact lookups answered by a negatively cached key.", func(s synapse.Stats) uint64 { return s.NegativeHits }},
+	{"sets_total", "Values stored.", func(s synapse.Stats) uint64 { return s.Sets }},
+	{"deletes_total", "Entries deleted.", func(s synapse.Stats) uint64 { return s.Deletes }},
+	{"similar_searches_total", "Similarity searches.", func(s synapse.Stats) uint64 { return s.SimilarSearches }},
+	{"similar_hits_total", "Similarity searches that found a match.", func(s synapse.Stats) uint64 { return s.SimilarHits }},
+	{"evictions_total", "Entries evicted to make room.", func(s synapse.Stats) uint64 { return s.Evictions }},
+	{"expired_total", "Entries removed after their TTL elapsed.", func(s synapse.Stats) uint64 { return s.Expired }},
+}
+
+// gauges are the gauges exported per cache and per shard
+var gauges = []struct {
+	name  string
+	help  string
+	value func(size, capacity int) int
+}{
+	{"entries", "Entries currently cached.", func(size, _ int) int { return size }},
+	{"capacity", "Maximum number of entries.", func(_, capacity int) int { return capacity }},
+}
+
+// LatencyBuckets are the bucket bounds of exported latency histograms, in
+// seconds. The cache keeps finer buckets, which are counted against these.
+var LatencyBuckets = []float64{
+	1e-6, 2.5e-6, 5e-6, 1e-5, 2.5e-5, 5e-5, 1e-4, 2.5e-4, 5e-4,
+	1e-3, 2.5e-3, 5e-3, 1e-2, 2.5e-2, 5e-2, 0.1, 0.25, 0.5, 1,
+}
+
+// ScoreBuckets are the bucket bounds of exported similarity score
+// histograms
+var ScoreBuckets = []float64{
+	0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5,
+	0.55, 0.6, 0.65, 0.7, 0.75, 0.8, 0.85, 0.9, 0.95, 1,
+}
+
+// histograms are the histograms exported per cache and per shard
+var histograms = []struct {
+	name  string
+	help  string
+	value func(synapse.Histograms) synapse.Histogram
+}{
+	{"get_duration_seconds", "Duration of exact lookups.", func(h synapse.Histograms) synapse.Histogram {
+		return h.GetLatency.Rebucket(LatencyBuckets)
+	}},
+	{"similar_duration_seconds", "Duration of similarity searches.", func(h synapse.Histograms) synapse.Histogram {
+		return h.SimilarLatency.Rebucket(LatencyBuckets)
+	}},
+	{"similar_score", "Similarity scores of similarity hits.", func(h synapse.Histograms) synapse.Histogram {
+		return h.SimilarScores.Rebucket(ScoreBuckets)
+	}},
+	{"similar_near_miss_score", "Best scores of similarity searches that found no match.", func(h synapse.Histograms) synapse.Histogram {
+		return h.NearMissScores.Rebucket(ScoreBuckets)
+	}},
+}
+
+// writeFamily writes the cache and shard families of a metric, calling
+// write with the metric name, labels and values of every cache and shard
+func writeFamily(w *countingWriter, name, help, typ string, snapshots []cacheSnapshot, write func(name, labels string, s series)) {
+	cacheName := "synapse_cache_" + name
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", cacheName, help, cacheName, typ)
+	for _, snap := range snapshots {
+		write(cacheName, label("cache", snap.name), snap.series)
+	}
+
+	shardName := "synapse_shard_" + name
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", shardName, help, shardName, typ)
+	for _, snap := range snapshots {
+		for _, shard := range snap.shards {
+			labels := label("cache", snap.name) + "," + label("shard", strconv.Itoa(shard.Index))
+			write(shardName, labels, series{
+				stats:      shard.Stats,
+				histograms: shard.Histograms,
+				len:        shard.Len,
+				cap:        shard.Capacity,
+			})
+		}
+	}
+}
+
+// labelEscaper escapes label values as required by the exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats a label pair
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// countingWriter writes to a buffered writer, remembering the number of
+// bytes written and the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// printf writes a formatted string unless an earlier write failed
+func (w *countingWriter) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// sample writes a single sample
+func (w *countingWriter) sample(name, labels, value string) {
+	w.printf("%s{%s} %s\n", name, labels, value)
+}
+
+// histogram writes the cumulative buckets, sum and count of a histogram
+func (w *countingWriter) histogram(name, labels string, h synapse.Histogram) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		le := label("le", strconv.FormatFloat(bound, 'g', -1, 64))
+		w.sample(name+"_bucket", labels+","+le, strconv.FormatUint(cumulative, 10))
+	}
+	w.sample(name+"_bucket", labels+`,le="+Inf"`, strconv.FormatUint(h.Count, 10))
+	w.sample(name+"_sum", labels, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	w.sample(name+"_count", labels, strconv.FormatUint(h.Count, 10))
+}
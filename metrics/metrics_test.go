@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+)
+
+// samplePattern matches a sample line of the text exposition format
+var samplePattern = regexp.MustCompile(`^[a-z_]+\{[a-z]+="[^"]*"(,[a-z]+="[^"]*")*\} [0-9.e+-]+$`)
+
+func scrape(t *testing.T, h *Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("Expected content type %q, got %q", ContentType, ct)
+	}
+	return rec.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	cache := synapse.New[string, string](
+		synapse.WithShards(4),
+		synapse.WithMaxSize(100),
+		synapse.WithStats(true),
+		synapse.WithThreshold(0.5),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+
+	ctx := context.Background()
+	cache.Set(ctx, "hello", "world")
+	cache.Get(ctx, "hello")
+	cache.Get(ctx, "missing")
+	cache.GetSimilar(ctx, "hallo")
+
+	h := NewHandler()
+	h.Register("users", cache)
+	body := scrape(t, h)
+
+	for _, want := range []string{
+		`# TYPE synapse_cache_hits_total counter`,
+		`synapse_cache_hits_total{cache="users"} 1`,
+		`synapse_cache_misses_total{cache="users"} 1`,
+		`synapse_cache_similar_hits_total{cache="users"} 1`,
+		`synapse_cache_entries{cache="users"} 1`,
+		`synapse_cache_capacity{cache="users"} 100`,
+		`synapse_shard_capacity{cache="users",shard="3"} 25`,
+		`# TYPE synapse_cache_get_duration_seconds histogram`,
+		`synapse_cache_get_duration_seconds_bucket{cache="users",le="+Inf"} 2`,
+		`synapse_cache_get_duration_seconds_count{cache="users"} 2`,
+		`synapse_cache_similar_duration_seconds_count{cache="users"} 1`,
+		`synapse_cache_similar_score_bucket{cache="users",le="0.75"} 0`,
+		`synapse_cache_similar_score_bucket{cache="users",le="0.8"} 1`,
+		`synapse_cache_similar_score_count{cache="users"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, body)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "# ") && !samplePattern.MatchString(line) {
+			t.Errorf("Malformed sample line %q", line)
+		}
+	}
+}
+
+func TestHandlerShards(t *testing.T) {
+	cache := synapse.New[string, string](synapse.WithShards(2), synapse.WithStats(true))
+	ctx := context.Background()
+	cache.Set(ctx, "key", "value")
+	cache.Get(ctx, "key")
+
+	h := NewHandler()
+	h.Register(`a"b`, cache)
+	body := scrape(t, h)
+
+	// The hit is attributed to exactly one shard
+	hits := 0
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "synapse_shard_hits_total{") && strings.HasSuffix(line, " 1") {
+			hits++
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("Expected one shard with a hit, got %d:\n%s", hits, body)
+	}
+	if !strings.Contains(body, `synapse_cache_hits_total{cache="a\"b"} 1`) {
+		t.Fatalf("Expected escaped cache label:\n%s", body)
+	}
+
+	h.Unregister(`a"b`)
+	if strings.Contains(scrape(t, h), "cache=") {
+		t.Fatal("Expected no samples after Unregister")
+	}
+}
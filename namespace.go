@@ -0,0 +1,50 @@
+package synapse
+
+import (
+	"slices"
+)
+
+// Namespaces returns the sorted names of all namespaces holding at least one
+// entry. Entries stored without a namespace belong to the default namespace "".
+func (c *Cache[K, V]) Namespaces() []string {
+	var names []string
+	for _, shard := range c.shards {
+		names = append(names, shard.namespaces()...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// NamespaceLen returns the number of entries stored in the namespace,
+// including expired entries that have not been swept yet
+func (c *Cache[K, V]) NamespaceLen(namespace string) int {
+	total := 0
+	for _, shard := range c.shards {
+		total += shard.namespaceLen(namespace)
+	}
+	return total
+}
+
+// NamespaceLiveLen returns the number of unexpired entries in the namespace.
+// Unlike NamespaceLen it visits every entry of the namespace.
+func (c *Cache[K, V]) NamespaceLiveLen(namespace string) int {
+	total := 0
+	for _, shard := range c.shards {
+		total += shard.namespaceLiveLen(namespace)
+	}
+	return total
+}
+
+// NamespaceStats returns statistics aggregated over the namespace.
+// Returns zero values if stats are not enabled.
+func (c *Cache[K, V]) NamespaceStats(namespace string) Stats {
+	if !c.options.EnableStats {
+		return Stats{}
+	}
+
+	var stats Stats
+	for _, shard := range c.shards {
+		stats.add(shard.namespaceStats(namespace))
+	}
+	return stats
+}
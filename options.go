@@ -1,6 +1,11 @@
 package synapse
 
 import (
+	"context"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
 	"time"
 )
 
@@ -12,6 +17,56 @@ type Options struct {
 	EvictionPolicy      EvictionPolicy
 	TTL                 time.Duration
 	EnableStats         bool
+	// NamespaceQuotas caps the number of entries per namespace
+	NamespaceQuotas map[string]int
+	// DefaultNamespaceQuota caps namespaces without an explicit quota; 0 means no cap
+	DefaultNamespaceQuota int
+	// Backend is the Backend[K, V] behind the cache, set with WithBackend
+	Backend any
+	// ReadThrough loads keys missing from the cache from the backend
+	ReadThrough bool
+	// WriteMode selects how writes reach the backend
+	WriteMode WriteMode
+	// WriteBehindInterval is how often queued writes are flushed
+	WriteBehindInterval time.Duration
+	// BackendRetries is the number of attempts made for each backend call
+	BackendRetries int
+	// BackendRetryBackoff is the delay before the first retry; it doubles
+	// with every further attempt
+	BackendRetryBackoff time.Duration
+	// OnBackendError is called with backend errors that cannot be returned
+	OnBackendError func(error)
+	// RefreshAhead is the fraction of an entry's lifetime after which Get
+	// reloads it from the backend in the background; 0 disables it
+	RefreshAhead float64
+	// StaleWhileRevalidate is how long past its expiry an entry is still
+	// served by Get while it is reloaded from the backend
+	StaleWhileRevalidate time.Duration
+	// NegativeTTL is how long a key found missing by a load is remembered
+	// as absent; 0 disables negative caching
+	NegativeTTL time.Duration
+	// TargetPrecision is the fraction of similarity hits reported good by
+	// Feedback that threshold tuning aims for; 0 disables tuning
+	TargetPrecision float64
+	// TuneNamespaces tunes a separate threshold for every namespace
+	TuneNamespaces bool
+	// TuningWindow is the number of Feedback reports per tuning decision
+	TuningWindow int
+	// TuningStep is the largest change of the threshold per decision
+	TuningStep float64
+	// MinThreshold and MaxThreshold bound the tuned threshold
+	MinThreshold float64
+	MaxThreshold float64
+	// Tracer starts a span for every Get, Set, GetSimilar and Delete
+	Tracer Tracer
+	// Logger receives diagnostics; nil disables logging
+	Logger *slog.Logger
+	// SlowSimilarity is the duration above which similarity searches are
+	// logged; 0 disables it
+	SlowSimilarity time.Duration
+	// Hasher is the Hasher[K] picking the shard of a key, set with
+	// WithHasher; nil uses the built-in hasher
+	Hasher any
 }
 
 // Option is a function that modifies Options
@@ -25,6 +80,12 @@ func defaultOptions() *Options {
 		SimilarityThreshold: 0.8,
 		TTL:                 0, // No expiration by default
 		EnableStats:         false,
+		BackendRetries:      3,
+		BackendRetryBackoff: 50 * time.Millisecond,
+		TuningWindow:        50,
+		TuningStep:          0.02,
+		MinThreshold:        0.5,
+		MaxThreshold:        1,
 	}
 }
 
@@ -55,7 +116,9 @@ func WithThreshold(t float64) Option {
 	}
 }
 
-// WithEviction sets the eviction policy
+// WithEviction sets the eviction policy. The policy is shared by all shards
+// and is given keys of type EvictionKey[K], which pair a key with its
+// namespace, rather than K itself.
 func WithEviction(policy EvictionPolicy) Option {
 	return func(o *Options) {
 		o.EvictionPolicy = policy
@@ -75,3 +138,428 @@ func WithStats(enable bool) Option {
 		o.EnableStats = enable
 	}
 }
+
+// WithNamespaceQuota caps the number of entries stored in a namespace.
+// When the namespace is full, its own entries are evicted to make room,
+// leaving other namespaces untouched.
+//
+// Like the maximum size, the quota is enforced per shard: each shard holds at
+// most maxEntries/NumShards entries of the namespace, rounded down. The limit
+// is therefore approximate; a namespace whose keys hash unevenly starts
+// evicting before it holds maxEntries entries. New panics if maxEntries is
+// smaller than the number of shards.
+func WithNamespaceQuota(namespace string, maxEntries int) Option {
+	return func(o *Options) {
+		if maxEntries > 0 {
+			if o.NamespaceQuotas == nil {
+				o.NamespaceQuotas = make(map[string]int)
+			}
+			o.NamespaceQuotas[namespace] = maxEntries
+		}
+	}
+}
+
+// WithDefaultNamespaceQuota caps the number of entries stored in each
+// namespace that has no quota set by WithNamespaceQuota. It is enforced per
+// shard in the same way.
+func WithDefaultNamespaceQuota(maxEntries int) Option {
+	return func(o *Options) {
+		if maxEntries > 0 {
+			o.DefaultNamespaceQuota = maxEntries
+		}
+	}
+}
+
+// WithBackend puts a backend behind the cache. Combine it with
+// WithReadThrough, WithWriteThrough or WithWriteBehind to choose how the
+// cache uses it. The backend's key and value types must match the cache's.
+func WithBackend[K comparable, V any](backend Backend[K, V]) Option {
+	return func(o *Options) {
+		o.Backend = backend
+	}
+}
+
+// WithHasher sets the function hashing keys to shards, replacing the
+// built-in hasher, which uses hash/maphash with a random seed per cache. The
+// built-in placement therefore differs between caches and runs, and so does
+// which keys a full shard evicts. Use WithHasher to hash only the identifying
+// part of a key or to spread keys deterministically, e.g. in tests. The key
+// type must match the cache's.
+func WithHasher[K comparable](hasher Hasher[K]) Option {
+	return func(o *Options) {
+		if hasher != nil {
+			o.Hasher = hasher
+		}
+	}
+}
+
+// WithReadThrough makes Get load keys missing from the cache from the backend
+// and cache them
+func WithReadThrough() Option {
+	return func(o *Options) {
+		o.ReadThrough = true
+	}
+}
+
+// WithWriteThrough makes Set and Delete write to the backend before
+// returning. A value is only cached once the backend has stored it.
+func WithWriteThrough() Option {
+	return func(o *Options) {
+		o.WriteMode = WriteThrough
+	}
+}
+
+// WithWriteBehind makes Set and Delete queue their writes, which are sent to
+// the backend in batches every batchInterval. Several writes to the same key
+// within an interval are coalesced into the last one. Call Close to flush the
+// queue before exiting.
+func WithWriteBehind(batchInterval time.Duration) Option {
+	return func(o *Options) {
+		if batchInterval > 0 {
+			o.WriteMode = WriteBehind
+			o.WriteBehindInterval = batchInterval
+		}
+	}
+}
+
+// WithBackendRetry sets the number of attempts made for each backend call
+// and the delay before the first retry, which doubles with every attempt
+func WithBackendRetry(attempts int, backoff time.Duration) Option {
+	return func(o *Options) {
+		if attempts > 0 {
+			o.BackendRetries = attempts
+		}
+		if backoff >= 0 {
+			o.BackendRetryBackoff = backoff
+		}
+	}
+}
+
+// WithBackendErrorHandler sets a function called with backend errors that
+// cannot be returned to a caller, such as failed loads and write-behind
+// flushes
+func WithBackendErrorHandler(fn func(error)) Option {
+	return func(o *Options) {
+		o.OnBackendError = fn
+	}
+}
+
+// WithRefreshAhead makes Get reload an entry from the backend in the
+// background once fraction of its lifetime has passed, e.g. 0.8 to refresh
+// during the last fifth of the TTL. The current value is returned meanwhile.
+// It requires a backend set with WithBackend and entries with a TTL.
+func WithRefreshAhead(fraction float64) Option {
+	return func(o *Options) {
+		if fraction > 0 && fraction < 1 {
+			o.RefreshAhead = fraction
+		}
+	}
+}
+
+// WithStaleWhileRevalidate makes Get serve expired entries for up to grace
+// past their expiry while reloading them from the backend in the background.
+// It requires a backend set with WithBackend.
+func WithStaleWhileRevalidate(grace time.Duration) Option {
+	return func(o *Options) {
+		if grace > 0 {
+			o.StaleWhileRevalidate = grace
+		}
+	}
+}
+
+// WithNegativeTTL remembers keys that a load found missing for ttl, so that
+// repeated lookups of absent keys do not reach the backend or loader. It
+// applies to read-through misses and GetOrLoad.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		if ttl > 0 {
+			o.NegativeTTL = ttl
+		}
+	}
+}
+
+// WithThresholdTuning adjusts the similarity threshold from the reports
+// passed to Feedback, aiming for the given fraction of good similarity hits.
+// The threshold set with WithThreshold is the starting point.
+func WithThresholdTuning(targetPrecision float64) Option {
+	return func(o *Options) {
+		if targetPrecision > 0 && targetPrecision <= 1 {
+			o.TargetPrecision = targetPrecision
+		}
+	}
+}
+
+// WithNamespaceThresholds tunes a separate threshold for every namespace
+// instead of one for the whole cache
+func WithNamespaceThresholds() Option {
+	return func(o *Options) {
+		o.TuneNamespaces = true
+	}
+}
+
+// WithTuningWindow sets the number of Feedback reports collected before each
+// tuning decision
+func WithTuningWindow(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.TuningWindow = n
+		}
+	}
+}
+
+// WithTuningStep sets the largest change of the threshold per tuning decision
+func WithTuningStep(step float64) Option {
+	return func(o *Options) {
+		if step > 0 && step <= 1 {
+			o.TuningStep = step
+		}
+	}
+}
+
+// WithThresholdBounds keeps the tuned threshold within [lo, hi]
+func WithThresholdBounds(lo, hi float64) Option {
+	return func(o *Options) {
+		if lo >= 0 && lo <= hi && hi <= 1 {
+			o.MinThreshold = lo
+			o.MaxThreshold = hi
+		}
+	}
+}
+
+// WithTracer emits a span through tracer for every Get, Set, GetSimilar and
+// Delete
+func WithTracer(tracer Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = tracer
+	}
+}
+
+// WithLogger logs evictions and expirations at debug level, backend and
+// loader errors, and slow similarity searches set with
+// WithSlowSimilarityThreshold. Records carry the shard, namespace and a hash
+// of the key rather than the key itself.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithSlowSimilarityThreshold logs similarity searches taking longer than d
+// at warning level. It requires a logger set with WithLogger.
+func WithSlowSimilarityThreshold(d time.Duration) Option {
+	return func(o *Options) {
+		if d > 0 {
+			o.SlowSimilarity = d
+		}
+	}
+}
+
+// SetOptions contains per-entry options for Set
+type SetOptions struct {
+	// Metadata is stored on the entry and returned by GetEntry
+	Metadata map[string]any
+	// Tags label the entry for invalidation with InvalidateTag
+	Tags []string
+	// UpdatedAt overrides the entry's update time; the zero value means now
+	UpdatedAt time.Time
+	// TTL overrides the cache's TTL for the entry; 0 keeps the cache's TTL
+	TTL time.Duration
+	// IfNewer skips the write unless it is newer than the stored entry
+	IfNewer bool
+	// KeepTTL keeps the expiry of the entry being overwritten
+	KeepTTL bool
+}
+
+// SetOption is a function that modifies SetOptions
+type SetOption func(*SetOptions)
+
+// NewSetOptions resolves the options of a Set call, starting from the
+// metadata carried by ctx. It lets wrappers that forward writes elsewhere
+// inspect the effective options.
+func NewSetOptions(ctx context.Context, opts ...SetOption) *SetOptions {
+	o := &SetOptions{}
+	if md := getMetadata(ctx); len(md) > 0 {
+		o.Metadata = maps.Clone(md)
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// metadata returns a metadata map for a new or updated entry. The map is
+// never modified once it has been stored, so entry copies may share it.
+func (o *SetOptions) metadata() map[string]any {
+	if o == nil || o.Metadata == nil {
+		return make(map[string]any)
+	}
+	return maps.Clone(o.Metadata)
+}
+
+// WithEntryMetadata attaches a metadata value to the entry being stored,
+// overriding any value for the same key carried by the context
+func WithEntryMetadata(key string, value any) SetOption {
+	return func(o *SetOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]any)
+		}
+		o.Metadata[key] = value
+	}
+}
+
+// tags returns a deduplicated copy of the tags for a new or updated entry
+func (o *SetOptions) tags() []string {
+	if o == nil || len(o.Tags) == 0 {
+		return nil
+	}
+	tags := slices.Clone(o.Tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// WithTags attaches tags to the entry being stored. All entries carrying a
+// tag can be removed at once with InvalidateTag.
+func WithTags(tags ...string) SetOption {
+	return func(o *SetOptions) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+// WithUpdateTime records t instead of the current time as the moment the
+// entry was written. It is meant for replaying writes that happened
+// elsewhere, such as replicated mutations.
+func WithUpdateTime(t time.Time) SetOption {
+	return func(o *SetOptions) {
+		o.UpdatedAt = t
+	}
+}
+
+// IfNewer makes Set store the value only if its update time, as set with
+// WithUpdateTime, is after that of the stored entry. Otherwise Set returns
+// ErrStale. The comparison is made under the shard lock, so a concurrent
+// newer write is never overwritten. With WithWriteThrough, the backend is
+// written before the comparison.
+func IfNewer() SetOption {
+	return func(o *SetOptions) {
+		o.IfNewer = true
+	}
+}
+
+// KeepTTL makes Set keep the expiry of the entry it overwrites instead of
+// applying the write's or the cache's TTL. New entries get the usual TTL.
+func KeepTTL() SetOption {
+	return func(o *SetOptions) {
+		o.KeepTTL = true
+	}
+}
+
+// updateTime returns the update time of the write
+func (o *SetOptions) updateTime() time.Time {
+	if o.UpdatedAt.IsZero() {
+		return time.Now()
+	}
+	return o.UpdatedAt
+}
+
+// WithEntryTTL makes the entry expire ttl after it is written, overriding the
+// cache's TTL
+func WithEntryTTL(ttl time.Duration) SetOption {
+	return func(o *SetOptions) {
+		if ttl > 0 {
+			o.TTL = ttl
+		}
+	}
+}
+
+// SimilarOptions contains options for similarity searches
+type SimilarOptions struct {
+	// Match requires each metadata key to be present with an equal value
+	Match map[string]any
+	// Filter rejects candidates whose metadata it returns false for
+	Filter func(metadata map[string]any) bool
+}
+
+// SimilarOption is a function that modifies SimilarOptions
+type SimilarOption func(*SimilarOptions)
+
+// NewSimilarOptions resolves the options of a similarity search
+func NewSimilarOptions(opts ...SimilarOption) *SimilarOptions {
+	o := &SimilarOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// accepts reports whether a candidate with the given metadata passes the
+// filters
+func (o *SimilarOptions) accepts(metadata map[string]any) bool {
+	if o == nil {
+		return true
+	}
+	for k, want := range o.Match {
+		got, ok := metadata[k]
+		if !ok || !metadataEqual(got, want) {
+			return false
+		}
+	}
+	if o.Filter != nil && !o.Filter(metadata) {
+		return false
+	}
+	return true
+}
+
+// metadataEqual compares two metadata values without panicking on values
+// that are not comparable. Only values of basic kinds are compared with ==,
+// as structs and arrays are comparable yet == panics when their interface
+// fields hold slices or maps.
+func metadataEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if basicKind(reflect.TypeOf(a).Kind()) && basicKind(reflect.TypeOf(b).Kind()) {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// basicKind reports whether values of kind k are compared with == without
+// any risk of panicking
+func basicKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// WithMetadataMatch restricts a similarity search to entries whose metadata
+// holds value under key, e.g. WithMetadataMatch("model", "gpt-x")
+func WithMetadataMatch(key string, value any) SimilarOption {
+	return func(o *SimilarOptions) {
+		if o.Match == nil {
+			o.Match = make(map[string]any)
+		}
+		o.Match[key] = value
+	}
+}
+
+// WithMetadataFilter restricts a similarity search to entries for which fn
+// returns true. Multiple filters must all accept an entry. fn is called with
+// the shard lock held and must not modify the metadata or call back into the
+// cache.
+func WithMetadataFilter(fn func(metadata map[string]any) bool) SimilarOption {
+	return func(o *SimilarOptions) {
+		if prev := o.Filter; prev != nil {
+			o.Filter = func(metadata map[string]any) bool {
+				return prev(metadata) && fn(metadata)
+			}
+			return
+		}
+		o.Filter = fn
+	}
+}
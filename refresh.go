@@ -0,0 +1,74 @@
+package synapse
+
+import (
+	"context"
+	"time"
+)
+
+// maybeRefresh starts a background reload of an entry returned by Get if it
+// is stale or past the refresh-ahead point of its lifetime
+func (c *Cache[K, V]) maybeRefresh(ctx context.Context, shard *Shard[K, V], entry Entry[K, V]) {
+	if entry.ExpiresAt.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	lifetime := entry.ExpiresAt.Sub(entry.UpdatedAt)
+
+	due := now.After(entry.ExpiresAt)
+	if !due && c.options.RefreshAhead > 0 {
+		due = now.Sub(entry.UpdatedAt) >= time.Duration(float64(lifetime)*c.options.RefreshAhead)
+	}
+	if !due {
+		return
+	}
+
+	c.refresh(GetNamespace(ctx), shard, entry, lifetime)
+}
+
+// refresh reloads an entry from the backend in the background, unless a
+// reload of the key is already running or the cache is closing. The new value
+// keeps the entry's metadata, tags and lifetime. If the backend no longer has
+// the key, the entry is removed and the key negatively cached. Nothing is
+// changed if the entry was overwritten or removed during the reload.
+func (c *Cache[K, V]) refresh(namespace string, shard *Shard[K, V], entry Entry[K, V], lifetime time.Duration) {
+	b := c.backing
+	key := nsKey[K]{Namespace: namespace, Key: entry.Key}
+
+	b.refreshMu.Lock()
+	if _, running := b.refreshing[key]; running || b.closed {
+		b.refreshMu.Unlock()
+		return
+	}
+	b.refreshing[key] = struct{}{}
+	b.refreshWG.Add(1)
+	b.refreshMu.Unlock()
+
+	go func() {
+		defer b.refreshWG.Done()
+		defer func() {
+			b.refreshMu.Lock()
+			delete(b.refreshing, key)
+			b.refreshMu.Unlock()
+		}()
+
+		ctx := WithNamespace(context.Background(), namespace)
+		v, ok, err := b.fetch(ctx, entry.Key)
+		if err != nil {
+			// Keep the current value until it expires
+			b.report(err)
+			return
+		}
+		if !ok {
+			if shard.deleteUnchanged(ctx, entry.Key, entry.UpdatedAt) {
+				shard.setAbsent(ctx, entry.Key)
+			}
+			return
+		}
+
+		opts := &SetOptions{Metadata: entry.Metadata, Tags: entry.Tags, TTL: lifetime}
+		if _, err := shard.replace(ctx, entry.Key, entry.UpdatedAt, v, opts); err != nil {
+			b.report(err)
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package replication
+
+import (
+	"github.com/kolosys/synapse/codec"
+)
+
+// Op identifies the kind of replicated mutation
+type Op uint8
+
+const (
+	// OpSet stores a value on peers
+	OpSet Op = iota + 1
+	// OpDelete removes a key on peers
+	OpDelete
+)
+
+// String returns the name of the operation
+func (o Op) String() string {
+	switch o {
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is a mutation shipped between peers. Keys and values are encoded
+// with the replicator's Codec so that transports only deal with bytes.
+type Message struct {
+	// Origin is the node ID of the replicator that produced the message
+	Origin    string
+	Op        Op
+	Namespace string
+	Key       []byte
+	Value     []byte
+	// Metadata is carried as-is; custom value types must be registered with
+	// gob.Register when using the TCP transport
+	Metadata map[string]any
+	Tags     []string
+	// Timestamp is the write time in Unix nanoseconds, used for
+	// last-writer-wins conflict resolution
+	Timestamp int64
+}
+
+// Codec encodes cache keys and values for transport
+type Codec = codec.Codec
+
+// GobCodec is a Codec based on encoding/gob
+type GobCodec = codec.Gob
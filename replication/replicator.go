@@ -0,0 +1,332 @@
+// Package replication keeps synapse caches on several nodes roughly in sync
+// by shipping Set and Delete mutations to peers over a pluggable Transport.
+//
+// Conflicts are resolved with last-writer-wins on the entry's UpdatedAt
+// timestamp. Evictions, expirations and bulk removals by Clear,
+// PurgeNamespace, InvalidateTag or DeleteFunc are local decisions and are
+// not replicated.
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+// ErrEventsDropped is reported through the error handler when local writes
+// were not shipped because the replicator fell behind. Peers miss those
+// writes until the keys are written again.
+var ErrEventsDropped = errors.New("replication: events dropped")
+
+// eventBuffer is the number of local writes queued for shipping before
+// further writes are dropped
+const eventBuffer = 4096
+
+// Options contains configuration options for a Replicator
+type Options struct {
+	// NodeID identifies this replicator to its peers; it must be unique
+	NodeID string
+	// Codec encodes keys and values
+	Codec Codec
+	// TombstoneTTL is how long deletes are remembered to reject older writes
+	// arriving late
+	TombstoneTTL time.Duration
+	// OnError is called with errors that occur while replicating
+	OnError func(error)
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithNodeID sets the node ID of the replicator
+func WithNodeID(id string) Option {
+	return func(o *Options) {
+		if id != "" {
+			o.NodeID = id
+		}
+	}
+}
+
+// WithCodec sets the codec used to encode keys and values
+func WithCodec(codec Codec) Option {
+	return func(o *Options) {
+		if codec != nil {
+			o.Codec = codec
+		}
+	}
+}
+
+// WithTombstoneTTL sets how long deletes are remembered
+func WithTombstoneTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		if ttl > 0 {
+			o.TombstoneTTL = ttl
+		}
+	}
+}
+
+// WithErrorHandler sets a function called with replication errors
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *Options) {
+		o.OnError = fn
+	}
+}
+
+// Replicator ships local mutations of a cache to peers and applies the
+// mutations received from them
+type Replicator[K comparable, V any] struct {
+	cache     *synapse.Cache[K, V]
+	transport Transport
+	options   *Options
+
+	mu         sync.Mutex
+	tombstones map[tombstoneKey[K]]time.Time
+
+	dropped atomic.Uint64 // local writes not shipped
+}
+
+// tombstoneKey identifies a deleted key
+type tombstoneKey[K comparable] struct {
+	namespace string
+	key       K
+}
+
+// New creates a replicator for cache communicating over transport
+func New[K comparable, V any](cache *synapse.Cache[K, V], transport Transport, opts ...Option) *Replicator[K, V] {
+	options := &Options{
+		Codec:        GobCodec{},
+		TombstoneTTL: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.NodeID == "" {
+		options.NodeID = randomID()
+	}
+
+	return &Replicator[K, V]{
+		cache:      cache,
+		transport:  transport,
+		options:    options,
+		tombstones: make(map[tombstoneKey[K]]time.Time),
+	}
+}
+
+// NodeID returns the node ID of the replicator
+func (r *Replicator[K, V]) NodeID() string {
+	return r.options.NodeID
+}
+
+// Dropped returns the number of local writes that were not shipped because
+// the replicator fell behind
+func (r *Replicator[K, V]) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Run replicates until ctx is done or the transport is closed. Local writes
+// are shipped to peers and writes received from peers are applied to the
+// cache. Local writes never wait for peers: if shipping falls behind, writes
+// are dropped and reported as ErrEventsDropped.
+func (r *Replicator[K, V]) Run(ctx context.Context) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Only ship application writes and our own, never writes applied on
+	// behalf of a peer
+	events := r.cache.Subscribe(ctx, func(ev synapse.Event[K, V]) bool {
+		if ev.Origin != "" && ev.Origin != r.options.NodeID {
+			return false
+		}
+		switch ev.Type {
+		case synapse.EventSet, synapse.EventUpdate:
+			return true
+		case synapse.EventDelete:
+			// Clear, PurgeNamespace, InvalidateTag and DeleteFunc are local
+			return ev.Reason == synapse.EvictionReasonDeleted
+		}
+		return false
+	}, synapse.WithBufferSize(eventBuffer), synapse.WithDropHandler(func() { r.dropped.Add(1) }))
+	var reported uint64
+
+	// Apply incoming messages on their own goroutine so that a slow peer
+	// cannot stall the inbox while we are broadcasting
+	applied := make(chan struct{})
+	go func() {
+		defer close(applied)
+		defer cancel()
+		for {
+			select {
+			case msg, ok := <-r.transport.Messages():
+				if !ok {
+					return
+				}
+				r.apply(ctx, msg)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	prune := time.NewTicker(r.options.TombstoneTTL)
+	defer prune.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				<-applied
+				return parent.Err()
+			}
+			r.ship(ctx, ev)
+			reported = r.reportDropped(reported)
+		case <-prune.C:
+			r.pruneTombstones()
+			reported = r.reportDropped(reported)
+		case <-applied:
+			// The transport was closed or ctx is done
+			return parent.Err()
+		}
+	}
+}
+
+// reportDropped reports the writes dropped since reported and returns the
+// new number of reported drops
+func (r *Replicator[K, V]) reportDropped(reported uint64) uint64 {
+	dropped := r.dropped.Load()
+	if dropped > reported {
+		r.reportError(fmt.Errorf("%w: %d", ErrEventsDropped, dropped-reported))
+	}
+	return dropped
+}
+
+// ship broadcasts a local event to peers
+func (r *Replicator[K, V]) ship(ctx context.Context, ev synapse.Event[K, V]) {
+	key, err := r.options.Codec.Marshal(ev.Entry.Key)
+	if err != nil {
+		r.reportError(err)
+		return
+	}
+
+	msg := Message{
+		Origin:    r.options.NodeID,
+		Namespace: ev.Entry.Namespace,
+		Key:       key,
+		Timestamp: ev.Time.UnixNano(),
+	}
+
+	if ev.Type == synapse.EventDelete {
+		msg.Op = OpDelete
+		r.addTombstone(ev.Entry.Namespace, ev.Entry.Key, ev.Time)
+	} else {
+		msg.Op = OpSet
+		msg.Metadata = ev.Entry.Metadata
+		msg.Tags = ev.Entry.Tags
+		if msg.Value, err = r.options.Codec.Marshal(ev.Entry.Value); err != nil {
+			r.reportError(err)
+			return
+		}
+	}
+
+	if err := r.transport.Broadcast(ctx, msg); err != nil && ctx.Err() == nil {
+		r.reportError(err)
+	}
+}
+
+// apply applies a message received from a peer, unless the local state is
+// at least as recent
+func (r *Replicator[K, V]) apply(ctx context.Context, msg Message) {
+	if msg.Origin == r.options.NodeID {
+		return
+	}
+
+	var key K
+	if err := r.options.Codec.Unmarshal(msg.Key, &key); err != nil {
+		r.reportError(err)
+		return
+	}
+
+	ctx = synapse.WithNamespace(ctx, msg.Namespace)
+	ctx = synapse.WithOrigin(ctx, msg.Origin)
+	ts := time.Unix(0, msg.Timestamp)
+
+	// Last writer wins; the comparison with the local entry is made by the
+	// cache under its lock, so a concurrent local write is never overwritten
+	if deletedAt, ok := r.tombstone(msg.Namespace, key); ok && !ts.After(deletedAt) {
+		return
+	}
+
+	switch msg.Op {
+	case OpSet:
+		var value V
+		if err := r.options.Codec.Unmarshal(msg.Value, &value); err != nil {
+			r.reportError(err)
+			return
+		}
+
+		opts := []synapse.SetOption{synapse.WithUpdateTime(ts), synapse.WithTags(msg.Tags...), synapse.IfNewer()}
+		for k, v := range msg.Metadata {
+			opts = append(opts, synapse.WithEntryMetadata(k, v))
+		}
+		if err := r.cache.Set(ctx, key, value, opts...); err != nil && !errors.Is(err, synapse.ErrStale) {
+			r.reportError(err)
+		}
+	case OpDelete:
+		r.addTombstone(msg.Namespace, key, ts)
+		r.cache.DeleteIfOlder(ctx, key, ts)
+	}
+}
+
+// addTombstone remembers that a key was deleted at ts
+func (r *Replicator[K, V]) addTombstone(namespace string, key K, ts time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := tombstoneKey[K]{namespace, key}
+	if prev, ok := r.tombstones[k]; !ok || ts.After(prev) {
+		r.tombstones[k] = ts
+	}
+}
+
+// tombstone returns when a key was last deleted
+func (r *Replicator[K, V]) tombstone(namespace string, key K) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ts, ok := r.tombstones[tombstoneKey[K]{namespace, key}]
+	return ts, ok
+}
+
+// pruneTombstones forgets deletes older than the tombstone TTL
+func (r *Replicator[K, V]) pruneTombstones() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.options.TombstoneTTL)
+	for k, ts := range r.tombstones {
+		if ts.Before(cutoff) {
+			delete(r.tombstones, k)
+		}
+	}
+}
+
+// reportError passes an error to the configured error handler
+func (r *Replicator[K, V]) reportError(err error) {
+	if r.options.OnError != nil {
+		r.options.OnError(err)
+	}
+}
+
+// randomID returns a random node ID
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
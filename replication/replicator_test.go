@@ -0,0 +1,312 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+// waitFor polls cond until it returns true or the timeout elapses
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for replication")
+}
+
+// startNode creates a cache replicating over transport
+func startNode(t *testing.T, ctx context.Context, id string, transport Transport) *synapse.Cache[string, string] {
+	t.Helper()
+	cache := synapse.New[string, string]()
+	r := New(cache, transport, WithNodeID(id), WithErrorHandler(func(err error) {
+		t.Errorf("%s: replication error: %v", id, err)
+	}))
+	go r.Run(ctx)
+	return cache
+}
+
+func TestReplicationMemory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	network := NewMemoryNetwork()
+	a := startNode(t, ctx, "a", network.Join())
+	b := startNode(t, ctx, "b", network.Join())
+	c := startNode(t, ctx, "c", network.Join())
+
+	// Give the replicators time to subscribe
+	time.Sleep(20 * time.Millisecond)
+
+	nsCtx := synapse.WithNamespace(ctx, "tenant")
+	a.Set(nsCtx, "key", "value", synapse.WithEntryMetadata("model", "x"), synapse.WithTags("t1"))
+
+	for _, cache := range []*synapse.Cache[string, string]{b, c} {
+		waitFor(t, func() bool {
+			entry, ok := cache.Peek(nsCtx, "key")
+			return ok && entry.Value == "value" && entry.Metadata["model"] == "x"
+		})
+	}
+
+	// Writes applied on behalf of a peer are not echoed back
+	b.Set(nsCtx, "key", "updated")
+	waitFor(t, func() bool {
+		v, ok := a.Get(nsCtx, "key")
+		return ok && v == "updated"
+	})
+
+	c.Delete(nsCtx, "key")
+	waitFor(t, func() bool {
+		_, okA := a.Peek(nsCtx, "key")
+		_, okB := b.Peek(nsCtx, "key")
+		return !okA && !okB
+	})
+
+	// Tags travel with the entry
+	a.Set(nsCtx, "tagged", "v", synapse.WithTags("group"))
+	waitFor(t, func() bool {
+		_, ok := c.Peek(nsCtx, "tagged")
+		return ok
+	})
+	if n := c.InvalidateTag(nsCtx, "group"); n != 1 {
+		t.Fatalf("Expected replicated tag to invalidate 1 entry, got %d", n)
+	}
+}
+
+func TestReplicationSkipsLocalRemovals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	network := NewMemoryNetwork()
+	a := startNode(t, ctx, "a", network.Join())
+	b := startNode(t, ctx, "b", network.Join())
+	time.Sleep(20 * time.Millisecond)
+
+	for _, key := range []string{"tagged", "other"} {
+		a.Set(ctx, key, "v", synapse.WithTags(key))
+	}
+	waitFor(t, func() bool { return b.Len() == 2 })
+
+	a.InvalidateTag(ctx, "tagged")
+	a.Clear(ctx)
+
+	// A later explicit delete is replicated, so by the time it arrives the
+	// local removals would have arrived too
+	a.Set(ctx, "marker", "v")
+	waitFor(t, func() bool { _, ok := b.Peek(ctx, "marker"); return ok })
+	a.Delete(ctx, "marker")
+	waitFor(t, func() bool { _, ok := b.Peek(ctx, "marker"); return !ok })
+
+	for _, key := range []string{"tagged", "other"} {
+		if _, ok := b.Peek(ctx, key); !ok {
+			t.Fatalf("Expected %s to survive local removals on the peer", key)
+		}
+	}
+}
+
+func TestReplicationLastWriterWins(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	network := NewMemoryNetwork()
+	peer := network.Join()
+	cache := startNode(t, ctx, "local", network.Join())
+	time.Sleep(20 * time.Millisecond)
+
+	cache.Set(ctx, "key", "local")
+	entry, _ := cache.Peek(ctx, "key")
+
+	codec := GobCodec{}
+	key, _ := codec.Marshal("key")
+	stale, _ := codec.Marshal("stale")
+	fresh, _ := codec.Marshal("fresh")
+
+	// An older write from a peer is rejected
+	peer.Broadcast(ctx, Message{Origin: "peer", Op: OpSet, Key: key, Value: stale, Timestamp: entry.UpdatedAt.Add(-time.Second).UnixNano()})
+	// A newer write from a peer wins
+	peer.Broadcast(ctx, Message{Origin: "peer", Op: OpSet, Key: key, Value: fresh, Timestamp: entry.UpdatedAt.Add(time.Second).UnixNano()})
+
+	waitFor(t, func() bool {
+		v, _ := cache.Get(ctx, "key")
+		return v == "fresh"
+	})
+
+	// A delete leaves a tombstone that rejects older writes
+	del := entry.UpdatedAt.Add(2 * time.Second)
+	peer.Broadcast(ctx, Message{Origin: "peer", Op: OpDelete, Key: key, Timestamp: del.UnixNano()})
+	peer.Broadcast(ctx, Message{Origin: "peer", Op: OpSet, Key: key, Value: stale, Timestamp: del.Add(-time.Millisecond).UnixNano()})
+	sync, _ := codec.Marshal("sync")
+	peer.Broadcast(ctx, Message{Origin: "peer", Op: OpSet, Key: sync, Value: fresh, Timestamp: del.UnixNano()})
+
+	// Messages are applied in order, so once the last one is visible the
+	// stale write has been processed
+	waitFor(t, func() bool {
+		_, ok := cache.Peek(ctx, "sync")
+		return ok
+	})
+	if _, ok := cache.Get(ctx, "key"); ok {
+		t.Fatal("Expected stale write after delete to be rejected")
+	}
+}
+
+func TestReplicationTCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ta, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer ta.Close()
+	tb, err := ListenTCP("127.0.0.1:0", ta.Addr().String())
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer tb.Close()
+	ta.AddPeer(tb.Addr().String())
+
+	a := startNode(t, ctx, "a", ta)
+	b := startNode(t, ctx, "b", tb)
+	time.Sleep(20 * time.Millisecond)
+
+	a.Set(ctx, "from-a", "1")
+	b.Set(ctx, "from-b", "2")
+
+	waitFor(t, func() bool {
+		_, okA := a.Peek(ctx, "from-b")
+		_, okB := b.Peek(ctx, "from-a")
+		return okA && okB
+	})
+
+	b.Delete(ctx, "from-a")
+	waitFor(t, func() bool {
+		_, ok := a.Peek(ctx, "from-a")
+		return !ok
+	})
+}
+
+func TestReplicatorStopsWhenTransportCloses(t *testing.T) {
+	network := NewMemoryNetwork()
+	transport := network.Join()
+	r := New(synapse.New[string, string](), transport)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background())
+	}()
+
+	transport.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected nil error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the transport was closed")
+	}
+}
+
+// stuckTransport is a Transport whose broadcasts wait until released
+type stuckTransport struct {
+	release chan struct{}
+	inbox   chan Message
+}
+
+func (t *stuckTransport) Broadcast(ctx context.Context, msg Message) error {
+	select {
+	case <-t.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (t *stuckTransport) Messages() <-chan Message { return t.inbox }
+
+func (t *stuckTransport) Close() error { return nil }
+
+func TestReplicationDoesNotBlockWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &stuckTransport{release: make(chan struct{}), inbox: make(chan Message)}
+	cache := synapse.New[int, int](synapse.WithMaxSize(100000))
+	reported := make(chan error, 16)
+	r := New(cache, transport, WithErrorHandler(func(err error) {
+		select {
+		case reported <- err:
+		default:
+		}
+	}))
+	go r.Run(ctx)
+	time.Sleep(20 * time.Millisecond)
+
+	// Local writes go on while the transport is stuck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 2 * eventBuffer {
+			cache.Set(ctx, i, i)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Local writes blocked on replication")
+	}
+	if r.Dropped() == 0 {
+		t.Fatal("Expected dropped events")
+	}
+
+	close(transport.release)
+	select {
+	case err := <-reported:
+		if !errors.Is(err, ErrEventsDropped) {
+			t.Fatalf("Expected ErrEventsDropped, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Dropped events were not reported")
+	}
+}
+
+func TestTCPTransportSlowPeer(t *testing.T) {
+	// The peer accepts connections but never reads from them
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	transport, err := ListenTCP("127.0.0.1:0", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer transport.Close()
+	transport.WriteTimeout = 100 * time.Millisecond
+
+	// Large enough to fill the socket buffers
+	msg := Message{Origin: "a", Op: OpSet, Value: make([]byte, 64<<20)}
+	start := time.Now()
+	if err := transport.Broadcast(context.Background(), msg); err == nil {
+		t.Fatal("Expected the write to the slow peer to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Broadcast took %v despite the write timeout", elapsed)
+	}
+}
@@ -0,0 +1,216 @@
+package replication
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kolosys/synapse/internal/netserve"
+)
+
+// Default timeouts of a TCPTransport
+const (
+	DefaultDialTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// redialDelay is how long a peer that could not be dialed is skipped
+const redialDelay = time.Second
+
+// TCPTransport is a Transport that ships gob-encoded messages to peers over
+// plain TCP connections. Connections to peers are dialed lazily and
+// re-dialed after a failure; a peer that cannot be dialed is skipped for a
+// second before it is tried again.
+type TCPTransport struct {
+	// DialTimeout and WriteTimeout bound connecting to and writing to a
+	// peer, so that an unreachable peer cannot stall broadcasts. Set them
+	// before the first Broadcast.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	ln     net.Listener
+	srv    *netserve.Server
+	inbox  chan Message
+	closed chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	peers map[string]*tcpPeer
+}
+
+// tcpPeer is an outgoing connection to a peer. Its lock serializes the
+// messages sent to it, so a slow peer only delays its own messages.
+type tcpPeer struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn // nil until dialed
+	enc     *gob.Encoder
+	retryAt time.Time // when to dial again after a failed dial
+	dialErr error     // error of the last failed dial
+}
+
+// ListenTCP starts a transport listening on addr that broadcasts to peers
+func ListenTCP(addr string, peers ...string) (*TCPTransport, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TCPTransport{
+		DialTimeout:  DefaultDialTimeout,
+		WriteTimeout: DefaultWriteTimeout,
+		ln:           ln,
+		inbox:        make(chan Message, 1024),
+		closed:       make(chan struct{}),
+		peers:        make(map[string]*tcpPeer),
+	}
+	for _, peer := range peers {
+		t.peers[peer] = &tcpPeer{addr: peer}
+	}
+
+	t.srv = netserve.New(t.readLoop)
+	go t.srv.Serve(ln)
+
+	return t, nil
+}
+
+// Addr returns the address the transport is listening on
+func (t *TCPTransport) Addr() net.Addr {
+	return t.ln.Addr()
+}
+
+// AddPeer adds a peer address to broadcast to
+func (t *TCPTransport) AddPeer(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.peers[addr]; !ok {
+		t.peers[addr] = &tcpPeer{addr: addr}
+	}
+}
+
+// Broadcast implements Transport. It sends to every peer concurrently and
+// returns the errors of the peers that could not be reached.
+func (t *TCPTransport) Broadcast(ctx context.Context, msg Message) error {
+	select {
+	case <-t.closed:
+		return ErrClosed
+	default:
+	}
+
+	t.mu.Lock()
+	peers := make([]*tcpPeer, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, peer)
+	}
+	t.mu.Unlock()
+
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = t.send(ctx, peer, &msg)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// send writes a message to a peer, dialing it if needed
+func (t *TCPTransport) send(ctx context.Context, peer *tcpPeer, msg *Message) error {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if peer.conn == nil {
+		if time.Now().Before(peer.retryAt) {
+			return peer.dialErr
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+		var d net.Dialer
+		conn, err := d.DialContext(dialCtx, "tcp", peer.addr)
+		if err != nil {
+			peer.retryAt = time.Now().Add(redialDelay)
+			peer.dialErr = err
+			return err
+		}
+
+		select {
+		case <-t.closed:
+			// Close has already gone through the peers
+			conn.Close()
+			return ErrClosed
+		default:
+		}
+		peer.conn, peer.enc = conn, gob.NewEncoder(conn)
+	}
+
+	deadline := time.Now().Add(t.WriteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	peer.conn.SetWriteDeadline(deadline)
+	if err := peer.enc.Encode(msg); err != nil {
+		// Drop the connection and re-dial on the next broadcast
+		peer.conn.Close()
+		peer.conn, peer.enc = nil, nil
+		return err
+	}
+	return nil
+}
+
+// Messages implements Transport
+func (t *TCPTransport) Messages() <-chan Message {
+	return t.inbox
+}
+
+// Close implements Transport
+func (t *TCPTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		close(t.closed)
+		err = t.srv.Close()
+
+		t.mu.Lock()
+		peers := make([]*tcpPeer, 0, len(t.peers))
+		for _, peer := range t.peers {
+			peers = append(peers, peer)
+		}
+		t.mu.Unlock()
+
+		// Sends in flight are bounded by the timeouts
+		for _, peer := range peers {
+			peer.mu.Lock()
+			if peer.conn != nil {
+				peer.conn.Close()
+				peer.conn, peer.enc = nil, nil
+			}
+			peer.mu.Unlock()
+		}
+
+		close(t.inbox)
+	})
+	return err
+}
+
+// readLoop decodes messages from a peer connection into the inbox
+func (t *TCPTransport) readLoop(conn net.Conn) {
+	dec := gob.NewDecoder(conn)
+	for {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		select {
+		case t.inbox <- msg:
+		case <-t.closed:
+			return
+		}
+	}
+}
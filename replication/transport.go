@@ -0,0 +1,104 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned when using a transport that has been closed
+var ErrClosed = errors.New("replication: transport closed")
+
+// Transport ships messages between peers
+type Transport interface {
+	// Broadcast sends a message to every peer
+	Broadcast(ctx context.Context, msg Message) error
+
+	// Messages returns the messages received from peers. The channel is
+	// closed when the transport is closed.
+	Messages() <-chan Message
+
+	// Close stops the transport
+	Close() error
+}
+
+// MemoryNetwork connects in-process transports, e.g. for tests or several
+// caches living in one process
+type MemoryNetwork struct {
+	mu      sync.RWMutex
+	members map[*MemoryTransport]struct{}
+}
+
+// NewMemoryNetwork creates an empty in-process network
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		members: make(map[*MemoryTransport]struct{}),
+	}
+}
+
+// Join adds a new transport to the network
+func (n *MemoryNetwork) Join() *MemoryTransport {
+	t := &MemoryTransport{
+		network: n,
+		inbox:   make(chan Message, 1024),
+		closed:  make(chan struct{}),
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.members[t] = struct{}{}
+
+	return t
+}
+
+// MemoryTransport is a Transport delivering messages over a MemoryNetwork
+type MemoryTransport struct {
+	network *MemoryNetwork
+	inbox   chan Message
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// Broadcast implements Transport
+func (t *MemoryTransport) Broadcast(ctx context.Context, msg Message) error {
+	select {
+	case <-t.closed:
+		return ErrClosed
+	default:
+	}
+
+	t.network.mu.RLock()
+	defer t.network.mu.RUnlock()
+
+	for peer := range t.network.members {
+		if peer == t {
+			continue
+		}
+		select {
+		case peer.inbox <- msg:
+		case <-peer.closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Messages implements Transport
+func (t *MemoryTransport) Messages() <-chan Message {
+	return t.inbox
+}
+
+// Close implements Transport
+func (t *MemoryTransport) Close() error {
+	t.once.Do(func() {
+		// Release broadcasters blocked on our inbox before taking the lock
+		close(t.closed)
+
+		t.network.mu.Lock()
+		defer t.network.mu.Unlock()
+		delete(t.network.members, t)
+		close(t.inbox)
+	})
+	return nil
+}
@@ -0,0 +1,173 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// errProtocol is returned for malformed requests
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command sent either as a RESP array of bulk strings or
+// as an inline command separated by spaces
+func readCommand(r *bufio.Reader, maxBulk int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		// Inline command, as typed into telnet
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > 1024*1024 {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([][]byte, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated", errProtocol)
+		}
+		args[i] = buf[:size]
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF or LF, without the terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: line too long", errProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	// ReadSlice's buffer is reused by the next read
+	return bytes.Clone(line), nil
+}
+
+// writer encodes replies in the protocol version negotiated by the client
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+// simple writes a simple string reply
+func (w *writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// error writes an error reply. msg should start with an error code such as
+// ERR or WRONGTYPE.
+func (w *writer) error(msg string) {
+	w.WriteByte('-')
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+// int writes an integer reply
+func (w *writer) int(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+// bulk writes a bulk string reply
+func (w *writer) bulk(b []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+// bulkString writes a bulk string reply
+func (w *writer) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+// null writes a null reply
+func (w *writer) null() {
+	if w.proto >= 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+// array writes the header of an array reply with n elements
+func (w *writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
+
+// mapHeader writes the header of a map reply with n pairs. RESP2 has no maps,
+// so a flat array of keys and values is written instead.
+func (w *writer) mapHeader(n int) {
+	if w.proto >= 3 {
+		w.WriteByte('%')
+		w.WriteString(strconv.Itoa(n))
+		w.WriteString("\r\n")
+		return
+	}
+	w.array(2 * n)
+}
+
+// double writes a floating point reply. RESP2 has no doubles, so a bulk
+// string is written instead.
+func (w *writer) double(f float64) {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if w.proto >= 3 {
+		w.WriteByte(',')
+		w.WriteString(s)
+		w.WriteString("\r\n")
+		return
+	}
+	w.bulkString(s)
+}
+
+// verbatim writes a text reply such as INFO's
+func (w *writer) verbatim(s string) {
+	if w.proto >= 3 {
+		w.WriteByte('=')
+		w.WriteString(strconv.Itoa(len(s) + 4))
+		w.WriteString("\r\ntxt:")
+		w.WriteString(s)
+		w.WriteString("\r\n")
+		return
+	}
+	w.bulkString(s)
+}
@@ -0,0 +1,477 @@
+// Package resp serves a synapse cache over the Redis serialization protocol,
+// so that Redis clients and tools can use it. Both RESP2 and RESP3 (after
+// HELLO 3) are supported.
+//
+// The supported commands are PING, ECHO, HELLO, SELECT, GET, SET (with EX,
+// PX or KEEPTTL), DEL, EXISTS, EXPIRE, PEXPIRE, PERSIST, TTL, PTTL, DBSIZE,
+// FLUSHDB, INFO and QUIT, plus two similarity commands:
+//
+//	SIM.GET key          the most similar entry as [key, value, score]
+//	SIM.TOPK key k       up to k entries as [[key, value, score], ...]
+//
+// SELECT switches the connection to the namespace named by its argument,
+// with database 0 being the default namespace.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/internal/netserve"
+)
+
+// Options contains configuration options for a Server
+type Options struct {
+	// MaxValueSize limits the size of a single argument in bytes
+	MaxValueSize int
+	// NamespaceSeparator, if set, maps keys of the form "namespace<sep>key"
+	// to the given namespace instead of the one chosen with SELECT
+	NamespaceSeparator string
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithMaxValueSize limits the size of a single argument in bytes
+func WithMaxValueSize(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.MaxValueSize = n
+		}
+	}
+}
+
+// WithNamespaceSeparator maps keys of the form "namespace<sep>key" to the
+// given namespace, e.g. "tenant:key" with sep ":"
+func WithNamespaceSeparator(sep string) Option {
+	return func(o *Options) {
+		o.NamespaceSeparator = sep
+	}
+}
+
+// Server serves a cache to RESP clients
+type Server struct {
+	cache   *synapse.Cache[string, []byte]
+	options *Options
+	started time.Time
+	srv     *netserve.Server
+}
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("resp: server closed")
+
+// New creates a server for cache
+func New(cache *synapse.Cache[string, []byte], opts ...Option) *Server {
+	options := &Options{
+		MaxValueSize: 512 << 20,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s := &Server{
+		cache:   cache,
+		options: options,
+		started: time.Now(),
+	}
+	s.srv = netserve.New(s.serveConn)
+	return s
+}
+
+// ListenAndServe listens on addr and serves clients until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves clients connecting to ln until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.srv.Serve(ln); err != netserve.ErrClosed {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// Close stops the listeners and closes all client connections
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// session is the state of a client connection
+type session struct {
+	namespace string
+	w         *writer
+	quit      bool
+}
+
+// serveConn runs the commands of a client until it disconnects. Replies are
+// flushed once no more pipelined commands are buffered.
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, 64<<10)
+	sess := &session{w: &writer{Writer: bufio.NewWriterSize(conn, 64<<10), proto: 2}}
+
+	for !sess.quit {
+		args, err := readCommand(r, s.options.MaxValueSize)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				sess.w.error("ERR " + err.Error())
+				sess.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.exec(sess, args)
+
+		if r.Buffered() == 0 {
+			if err := sess.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	sess.w.Flush()
+}
+
+// exec runs a single command
+func (s *Server) exec(sess *session, args [][]byte) {
+	w := sess.w
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.simple("PONG")
+		case 1:
+			w.bulk(args[0])
+		default:
+			wrongArity(w, name)
+		}
+
+	case "ECHO":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		w.bulk(args[0])
+
+	case "QUIT":
+		w.simple("OK")
+		sess.quit = true
+
+	case "HELLO":
+		s.hello(sess, args)
+
+	case "SELECT":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		sess.namespace = string(args[0])
+		if sess.namespace == "0" {
+			sess.namespace = ""
+		}
+		w.simple("OK")
+
+	case "COMMAND", "CLIENT":
+		// Sent by redis-cli and client libraries on connect
+		if name == "COMMAND" {
+			w.array(0)
+			return
+		}
+		w.simple("OK")
+
+	case "GET":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		ctx, key := s.key(sess, args[0])
+		if v, ok := s.cache.Get(ctx, key); ok {
+			w.bulk(v)
+			return
+		}
+		w.null()
+
+	case "SET":
+		s.set(sess, args)
+
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			wrongArity(w, name)
+			return
+		}
+		var n int64
+		for _, arg := range args {
+			ctx, key := s.key(sess, arg)
+			var ok bool
+			if name == "DEL" {
+				ok = s.cache.Delete(ctx, key)
+			} else {
+				_, ok = s.cache.Peek(ctx, key)
+			}
+			if ok {
+				n++
+			}
+		}
+		w.int(n)
+
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 2 {
+			wrongArity(w, name)
+			return
+		}
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			w.error("ERR value is not an integer or out of range")
+			return
+		}
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		ctx, key := s.key(sess, args[0])
+		if n <= 0 {
+			// As in Redis, a non-positive TTL deletes the key
+			w.int(boolInt(s.cache.Delete(ctx, key)))
+			return
+		}
+		if n > math.MaxInt64/int64(unit) {
+			w.error("ERR invalid expire time in '" + strings.ToLower(name) + "' command")
+			return
+		}
+		w.int(boolInt(s.cache.Expire(ctx, key, time.Duration(n)*unit)))
+
+	case "PERSIST":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		ctx, key := s.key(sess, args[0])
+		entry, ok := s.cache.Peek(ctx, key)
+		if !ok || entry.ExpiresAt.IsZero() {
+			w.int(0)
+			return
+		}
+		w.int(boolInt(s.cache.Expire(ctx, key, 0)))
+
+	case "TTL", "PTTL":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		ctx, key := s.key(sess, args[0])
+		entry, ok := s.cache.Peek(ctx, key)
+		switch {
+		case !ok:
+			w.int(-2)
+		case entry.ExpiresAt.IsZero():
+			w.int(-1)
+		case name == "TTL":
+			w.int(int64((time.Until(entry.ExpiresAt) + 500*time.Millisecond) / time.Second))
+		default:
+			w.int(time.Until(entry.ExpiresAt).Milliseconds())
+		}
+
+	case "DBSIZE":
+		w.int(int64(s.cache.NamespaceLiveLen(sess.namespace)))
+
+	case "FLUSHDB":
+		s.cache.PurgeNamespace(context.Background(), sess.namespace)
+		w.simple("OK")
+
+	case "INFO":
+		w.verbatim(s.info())
+
+	case "SIM.GET":
+		if len(args) != 1 {
+			wrongArity(w, name)
+			return
+		}
+		ctx, key := s.key(sess, args[0])
+		entry, score, ok := s.cache.GetSimilarEntry(ctx, key)
+		if !ok {
+			w.null()
+			return
+		}
+		s.writeMatch(w, args[0], entry, score)
+
+	case "SIM.TOPK":
+		if len(args) != 2 {
+			wrongArity(w, name)
+			return
+		}
+		k, err := strconv.Atoi(string(args[1]))
+		if err != nil || k <= 0 {
+			w.error("ERR k must be a positive integer")
+			return
+		}
+		ctx, key := s.key(sess, args[0])
+		matches := s.cache.TopSimilar(ctx, key, k)
+		w.array(len(matches))
+		for _, m := range matches {
+			s.writeMatch(w, args[0], m.Entry, m.Score)
+		}
+
+	default:
+		w.error(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+	}
+}
+
+// hello negotiates the protocol version and describes the server
+func (s *Server) hello(sess *session, args [][]byte) {
+	w := sess.w
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(string(args[0]))
+		if err != nil || proto < 2 || proto > 3 {
+			w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		w.proto = proto
+	}
+
+	w.mapHeader(3)
+	w.bulkString("server")
+	w.bulkString("synapse")
+	w.bulkString("proto")
+	w.int(int64(w.proto))
+	w.bulkString("mode")
+	w.bulkString("standalone")
+}
+
+// set stores a value, with an optional EX or PX expiry
+func (s *Server) set(sess *session, args [][]byte) {
+	w := sess.w
+	if len(args) < 2 {
+		wrongArity(w, "SET")
+		return
+	}
+
+	// As in Redis, a plain SET discards the key's previous TTL. EX, PX and
+	// KEEPTTL are mutually exclusive.
+	var opts []synapse.SetOption
+	for i := 2; i < len(args); i++ {
+		if len(opts) > 0 {
+			w.error("ERR syntax error")
+			return
+		}
+		unit := time.Second
+		switch strings.ToUpper(string(args[i])) {
+		case "EX":
+		case "PX":
+			unit = time.Millisecond
+		case "KEEPTTL":
+			opts = append(opts, synapse.KeepTTL())
+			continue
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+		if i+1 >= len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+			w.error("ERR invalid expire time in 'set' command")
+			return
+		}
+		opts = append(opts, synapse.WithEntryTTL(time.Duration(n)*unit))
+		i++
+	}
+
+	ctx, key := s.key(sess, args[0])
+	if err := s.cache.Set(ctx, key, args[1], opts...); err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	w.simple("OK")
+}
+
+// key resolves the namespace and cache key of a command argument
+func (s *Server) key(sess *session, arg []byte) (context.Context, string) {
+	namespace, key := sess.namespace, string(arg)
+	if sep := s.options.NamespaceSeparator; sep != "" {
+		if ns, rest, ok := strings.Cut(key, sep); ok {
+			namespace, key = ns, rest
+		}
+	}
+	return synapse.WithNamespace(context.Background(), namespace), key
+}
+
+// writeMatch writes a similarity match as [key, value, score]. The key keeps
+// the namespace prefix of the query, if any.
+func (s *Server) writeMatch(w *writer, query []byte, entry synapse.Entry[string, []byte], score float64) {
+	key := entry.Key
+	if sep := s.options.NamespaceSeparator; sep != "" {
+		if ns, _, ok := strings.Cut(string(query), sep); ok {
+			key = ns + sep + key
+		}
+	}
+
+	w.array(3)
+	w.bulkString(key)
+	w.bulk(entry.Value)
+	w.double(score)
+}
+
+// info renders the INFO text
+func (s *Server) info() string {
+	var b strings.Builder
+	stats := s.cache.Stats()
+
+	fmt.Fprintf(&b, "# Server\r\n")
+	fmt.Fprintf(&b, "synapse_version:1\r\n")
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.started).Seconds()))
+	fmt.Fprintf(&b, "\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", stats.Hits)
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", stats.Misses)
+	fmt.Fprintf(&b, "sets:%d\r\n", stats.Sets)
+	fmt.Fprintf(&b, "deletes:%d\r\n", stats.Deletes)
+	fmt.Fprintf(&b, "similar_searches:%d\r\n", stats.SimilarSearches)
+	fmt.Fprintf(&b, "similar_hits:%d\r\n", stats.SimilarHits)
+	fmt.Fprintf(&b, "evicted_keys:%d\r\n", stats.Evictions)
+	fmt.Fprintf(&b, "expired_keys:%d\r\n", stats.Expired)
+	fmt.Fprintf(&b, "negative_hits:%d\r\n", stats.NegativeHits)
+	fmt.Fprintf(&b, "\r\n# Keyspace\r\n")
+	for _, namespace := range s.cache.Namespaces() {
+		keys := s.cache.NamespaceLiveLen(namespace)
+		if keys == 0 {
+			continue
+		}
+		name := namespace
+		if name == "" {
+			name = "0"
+		}
+		fmt.Fprintf(&b, "db%s:keys=%d\r\n", name, keys)
+	}
+	return b.String()
+}
+
+// wrongArity writes the error for a command called with the wrong number of
+// arguments
+func wrongArity(w *writer, name string) {
+	w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// boolInt converts a boolean reply to an integer
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
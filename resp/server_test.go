@@ -0,0 +1,239 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+)
+
+// respError is an error reply
+type respError string
+
+// client is a minimal RESP client for tests
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer serves a fresh cache on a loopback port and connects to it
+func startServer(t *testing.T, opts ...Option) *client {
+	t.Helper()
+
+	cache := synapse.New[string, []byte](synapse.WithStats(true), synapse.WithThreshold(0.6))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	srv := New(cache, opts...)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes a command without waiting for the reply
+func (c *client) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends a command and reads its reply
+func (c *client) do(args ...string) any {
+	c.send(args...)
+	return c.read()
+}
+
+// read parses a single reply
+func (c *client) read() any {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	body := line[1:]
+
+	switch line[0] {
+	case '+':
+		return body
+	case '-':
+		return respError(body)
+	case ':':
+		n, _ := strconv.ParseInt(body, 10, 64)
+		return n
+	case ',':
+		f, _ := strconv.ParseFloat(body, 64)
+		return f
+	case '_':
+		return nil
+	case '$', '=':
+		n, _ := strconv.Atoi(body)
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(body)
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	default:
+		c.t.Fatalf("Unexpected reply %q", line)
+		return nil
+	}
+}
+
+// expect sends a command and checks its reply
+func (c *client) expect(want any, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); fmt.Sprint(got) != fmt.Sprint(want) {
+		c.t.Fatalf("%v: expected %v, got %v", args, want, got)
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("PONG", "PING")
+	c.expect("OK", "SET", "key", "value")
+	c.expect("value", "GET", "key")
+	c.expect(nil, "GET", "missing")
+	c.expect(int64(1), "EXISTS", "key", "missing")
+	c.expect(int64(-1), "TTL", "key")
+	c.expect(int64(1), "EXPIRE", "key", "100")
+	c.expect(int64(100), "TTL", "key")
+	c.expect(int64(1), "PERSIST", "key")
+	c.expect(int64(-1), "TTL", "key")
+	c.expect(int64(-2), "TTL", "missing")
+	c.expect(int64(1), "DBSIZE")
+	c.expect(int64(1), "DEL", "key", "missing")
+	c.expect(int64(0), "DBSIZE")
+
+	c.expect("OK", "SET", "short", "v", "PX", "20")
+	time.Sleep(40 * time.Millisecond)
+	// Expired entries are not counted, even before they are swept
+	c.expect(int64(0), "DBSIZE")
+	if info, _ := c.do("INFO").(string); strings.Contains(info, "db0:") {
+		t.Fatalf("Expected no keyspace line for expired keys, got %q", info)
+	}
+	c.expect(nil, "GET", "short")
+
+	// A plain SET discards the TTL, KEEPTTL keeps it
+	c.expect("OK", "SET", "ttl", "v", "EX", "100")
+	c.expect("OK", "SET", "ttl", "v2")
+	c.expect(int64(-1), "TTL", "ttl")
+	c.expect("OK", "SET", "ttl", "v3", "EX", "100")
+	c.expect("OK", "SET", "ttl", "v4", "KEEPTTL")
+	c.expect(int64(100), "TTL", "ttl")
+	c.expect(respError("ERR syntax error"), "SET", "ttl", "v", "EX", "1", "KEEPTTL")
+	c.expect(int64(1), "DEL", "ttl")
+
+	c.expect(respError("ERR syntax error"), "SET", "key", "value", "NX")
+	c.expect(respError("ERR wrong number of arguments for 'get' command"), "GET")
+	c.expect(respError("ERR unknown command 'nope'"), "NOPE")
+
+	info, _ := c.do("INFO").(string)
+	if !strings.Contains(info, "keyspace_hits:1") {
+		t.Fatalf("Unexpected INFO reply %q", info)
+	}
+}
+
+func TestServerSelect(t *testing.T) {
+	c := startServer(t)
+
+	c.expect("OK", "SET", "key", "default")
+	c.expect("OK", "SELECT", "tenant")
+	c.expect(nil, "GET", "key")
+	c.expect("OK", "SET", "key", "tenant")
+	c.expect(int64(1), "DBSIZE")
+	c.expect("OK", "SELECT", "0")
+	c.expect("default", "GET", "key")
+}
+
+func TestServerNamespaceSeparator(t *testing.T) {
+	c := startServer(t, WithNamespaceSeparator(":"))
+
+	c.expect("OK", "SET", "tenant:key", "v")
+	c.expect(nil, "GET", "key")
+	c.expect("OK", "SELECT", "tenant")
+	c.expect("v", "GET", "key")
+	c.expect("[tenant:key v 1]", "SIM.GET", "tenant:key")
+}
+
+func TestServerSimilarity(t *testing.T) {
+	c := startServer(t)
+
+	for _, key := range []string{"hello", "hallo", "help", "world"} {
+		c.expect("OK", "SET", key, "v:"+key)
+	}
+
+	c.expect("[hello v:hello 0.8]", "SIM.GET", "helo")
+	c.expect(nil, "SIM.GET", "zzzzzzzz")
+	c.expect("[[hello v:hello 1] [hallo v:hallo 0.8]]", "SIM.TOPK", "hello", "2")
+	c.expect(respError("ERR k must be a positive integer"), "SIM.TOPK", "hello", "x")
+
+	// RESP3 returns scores as doubles and nulls as _
+	c.expect("[server synapse proto 3 mode standalone]", "HELLO", "3")
+	reply := c.do("SIM.GET", "helo").([]any)
+	if _, ok := reply[2].(float64); !ok {
+		t.Fatalf("Expected a double score, got %T", reply[2])
+	}
+	c.expect(nil, "GET", "missing")
+}
+
+func TestServerPipelining(t *testing.T) {
+	c := startServer(t)
+
+	for i := 0; i < 100; i++ {
+		c.send("SET", fmt.Sprintf("key%d", i), strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		c.send("GET", fmt.Sprintf("key%d", i))
+	}
+	for i := 0; i < 100; i++ {
+		if reply := c.read(); reply != "OK" {
+			t.Fatalf("SET %d: %v", i, reply)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if reply := c.read(); reply != strconv.Itoa(i) {
+			t.Fatalf("GET %d: %v", i, reply)
+		}
+	}
+
+	// Inline commands are accepted too
+	c.conn.Write([]byte("PING\r\n"))
+	if reply := c.read(); reply != "PONG" {
+		t.Fatalf("Inline PING: %v", reply)
+	}
+}
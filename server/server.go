@@ -0,0 +1,370 @@
+// Package server exposes a synapse cache over HTTP with JSON responses, so
+// that services written in other languages can share it.
+//
+// Values are stored as raw bytes. The namespace of a request is taken from
+// the X-Synapse-Namespace header.
+//
+//	GET    /v1/keys/{key}                      value as the response body
+//	PUT    /v1/keys/{key}?ttl=30s&tag=a        request body as the value
+//	DELETE /v1/keys/{key}
+//	GET    /v1/similar?key=...                 best match as JSON
+//	GET    /v1/similar/top?key=...&k=5         best k matches as JSON
+//	GET    /v1/stats                           cache or namespace stats
+//	GET    /v1/namespaces                      namespaces and their sizes
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kolosys/synapse"
+)
+
+// DefaultNamespaceHeader is the header carrying the namespace of a request
+const DefaultNamespaceHeader = "X-Synapse-Namespace"
+
+// Options contains configuration options for a Server
+type Options struct {
+	// NamespaceHeader is the header carrying the namespace of a request
+	NamespaceHeader string
+	// MaxValueSize limits the size of stored values in bytes
+	MaxValueSize int64
+	// DefaultTopK is the number of matches returned by top-k searches that
+	// do not specify k
+	DefaultTopK int
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithNamespaceHeader sets the header carrying the namespace of a request
+func WithNamespaceHeader(header string) Option {
+	return func(o *Options) {
+		if header != "" {
+			o.NamespaceHeader = header
+		}
+	}
+}
+
+// WithMaxValueSize limits the size of stored values in bytes
+func WithMaxValueSize(n int64) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.MaxValueSize = n
+		}
+	}
+}
+
+// WithDefaultTopK sets the number of matches returned by top-k searches that
+// do not specify k
+func WithDefaultTopK(k int) Option {
+	return func(o *Options) {
+		if k > 0 {
+			o.DefaultTopK = k
+		}
+	}
+}
+
+// Server is an http.Handler serving a cache
+type Server struct {
+	cache   *synapse.Cache[string, []byte]
+	mux     *http.ServeMux
+	options *Options
+}
+
+// New creates a server for cache
+func New(cache *synapse.Cache[string, []byte], opts ...Option) *Server {
+	options := &Options{
+		NamespaceHeader: DefaultNamespaceHeader,
+		MaxValueSize:    1 << 20,
+		DefaultTopK:     10,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s := &Server{
+		cache:   cache,
+		mux:     http.NewServeMux(),
+		options: options,
+	}
+
+	s.mux.HandleFunc("GET /v1/keys/{key}", s.handleGet)
+	s.mux.HandleFunc("PUT /v1/keys/{key}", s.handleSet)
+	s.mux.HandleFunc("DELETE /v1/keys/{key}", s.handleDelete)
+	s.mux.HandleFunc("GET /v1/similar", s.handleSimilar)
+	s.mux.HandleFunc("GET /v1/similar/top", s.handleTopSimilar)
+	s.mux.HandleFunc("GET /v1/stats", s.handleStats)
+	s.mux.HandleFunc("GET /v1/namespaces", s.handleNamespaces)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Match is a similarity search result
+type Match struct {
+	Key      string         `json:"key"`
+	Value    []byte         `json:"value"`
+	Score    float64        `json:"score"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// StatsResponse is the body returned by the stats endpoint
+type StatsResponse struct {
+	Namespace       string  `json:"namespace,omitempty"`
+	Entries         int     `json:"entries"`
+	Hits            uint64  `json:"hits"`
+	Misses          uint64  `json:"misses"`
+	Sets            uint64  `json:"sets"`
+	Deletes         uint64  `json:"deletes"`
+	SimilarSearches uint64  `json:"similar_searches"`
+	SimilarHits     uint64  `json:"similar_hits"`
+	Evictions       uint64  `json:"evictions"`
+	Expired         uint64  `json:"expired"`
+	NegativeHits    uint64  `json:"negative_hits"`
+	HitRatio        float64 `json:"hit_ratio"`
+	SimilarHitRatio float64 `json:"similar_hit_ratio"`
+	ElapsedSeconds  float64 `json:"elapsed_seconds"`
+	// Latency percentiles in seconds, omitted for namespace stats
+	GetP50     float64 `json:"get_p50_seconds,omitempty"`
+	GetP99     float64 `json:"get_p99_seconds,omitempty"`
+	SimilarP50 float64 `json:"similar_p50_seconds,omitempty"`
+	SimilarP99 float64 `json:"similar_p99_seconds,omitempty"`
+}
+
+// Namespace describes a namespace in the namespaces response
+type Namespace struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+}
+
+// errorResponse is the body of error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// handleGet returns the stored value as the response body
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	ctx := s.context(r)
+
+	value, ok := s.cache.Get(ctx, r.PathValue("key"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// handleSet stores the request body. The optional ttl parameter is a
+// duration such as 30s or a number of seconds; tag may be repeated.
+func (s *Server) handleSet(w http.ResponseWriter, r *http.Request) {
+	ctx := s.context(r)
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.options.MaxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	opts := []synapse.SetOption{synapse.WithTags(query["tag"]...)}
+	if v := query.Get("ttl"); v != "" {
+		ttl, err := parseTTL(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts = append(opts, synapse.WithEntryTTL(ttl))
+	}
+
+	if err := s.cache.Set(ctx, r.PathValue("key"), value, opts...); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete removes a key
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := s.context(r)
+
+	if !s.cache.Delete(ctx, r.PathValue("key")) {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSimilar returns the best match for the key parameter
+func (s *Server) handleSimilar(w http.ResponseWriter, r *http.Request) {
+	ctx := s.context(r)
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing key parameter")
+		return
+	}
+
+	entry, score, ok := s.cache.GetSimilarEntry(ctx, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no similar key")
+		return
+	}
+	writeJSON(w, http.StatusOK, newMatch(entry, score))
+}
+
+// handleTopSimilar returns the best k matches for the key parameter
+func (s *Server) handleTopSimilar(w http.ResponseWriter, r *http.Request) {
+	ctx := s.context(r)
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing key parameter")
+		return
+	}
+
+	k := s.options.DefaultTopK
+	if v := query.Get("k"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "k must be a positive integer")
+			return
+		}
+		k = n
+	}
+
+	found := s.cache.TopSimilar(ctx, key, k)
+	matches := make([]Match, len(found))
+	for i, m := range found {
+		matches[i] = newMatch(m.Entry, m.Score)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Matches []Match `json:"matches"`
+	}{matches})
+}
+
+// handleStats returns the statistics of the request's namespace, or of the
+// whole cache if the request has no namespace header
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	namespace, scoped := s.namespace(r)
+
+	var resp StatsResponse
+	var stats synapse.Stats
+	var histograms synapse.Histograms
+	if scoped {
+		stats = s.cache.NamespaceStats(namespace)
+		resp.Namespace = namespace
+		resp.Entries = s.cache.NamespaceLen(namespace)
+	} else {
+		stats = s.cache.Stats()
+		histograms = s.cache.Histograms()
+		resp.Entries = s.cache.Len()
+	}
+
+	resp.Hits = stats.Hits
+	resp.Misses = stats.Misses
+	resp.Sets = stats.Sets
+	resp.Deletes = stats.Deletes
+	resp.SimilarSearches = stats.SimilarSearches
+	resp.SimilarHits = stats.SimilarHits
+	resp.Evictions = stats.Evictions
+	resp.Expired = stats.Expired
+	resp.NegativeHits = stats.NegativeHits
+	resp.HitRatio = stats.HitRatio()
+	resp.SimilarHitRatio = stats.SimilarHitRatio()
+	resp.ElapsedSeconds = stats.Elapsed.Seconds()
+	resp.GetP50 = histograms.GetLatency.Quantile(0.5)
+	resp.GetP99 = histograms.GetLatency.Quantile(0.99)
+	resp.SimilarP50 = histograms.SimilarLatency.Quantile(0.5)
+	resp.SimilarP99 = histograms.SimilarLatency.Quantile(0.99)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleNamespaces lists the namespaces holding entries
+func (s *Server) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	names := s.cache.Namespaces()
+	namespaces := make([]Namespace, len(names))
+	for i, name := range names {
+		namespaces[i] = Namespace{Name: name, Entries: s.cache.NamespaceLen(name)}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Namespaces []Namespace `json:"namespaces"`
+	}{namespaces})
+}
+
+// namespace returns the namespace header of a request and whether it was set
+func (s *Server) namespace(r *http.Request) (string, bool) {
+	values, ok := r.Header[http.CanonicalHeaderKey(s.options.NamespaceHeader)]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// context returns the request's context scoped to its namespace
+func (s *Server) context(r *http.Request) context.Context {
+	namespace, _ := s.namespace(r)
+	return synapse.WithNamespace(r.Context(), namespace)
+}
+
+// newMatch converts a cache entry to a Match
+func newMatch(entry synapse.Entry[string, []byte], score float64) Match {
+	m := Match{Key: entry.Key, Value: entry.Value, Score: score}
+	if len(entry.Metadata) > 0 {
+		m.Metadata = entry.Metadata
+	}
+	return m
+}
+
+// parseTTL parses a duration such as 1m30s or a number of seconds
+func parseTTL(v string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if math.IsNaN(secs) || secs <= 0 {
+			return 0, errors.New("ttl must be positive")
+		}
+		// Larger values, including Inf, overflow a time.Duration
+		if secs > math.MaxInt64/float64(time.Second) {
+			return 0, errors.New("ttl is too large: " + v)
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.New("invalid ttl: " + v)
+	}
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+)
+
+// newTestServer starts a server around a fresh cache
+func newTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	cache := synapse.New[string, []byte](synapse.WithStats(true), synapse.WithThreshold(0.6))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ts := httptest.NewServer(New(cache, opts...))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with an optional namespace and returns the response body
+func do(t *testing.T, method, url, namespace, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace != "" {
+		req.Header.Set(DefaultNamespaceHeader, namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestServerKeys(t *testing.T) {
+	ts := newTestServer(t)
+
+	if code, _ := do(t, "PUT", ts.URL+"/v1/keys/hello", "a", "world"); code != http.StatusNoContent {
+		t.Fatalf("PUT returned %d", code)
+	}
+	if code, body := do(t, "GET", ts.URL+"/v1/keys/hello", "a", ""); code != http.StatusOK || body != "world" {
+		t.Fatalf("GET returned %d %q", code, body)
+	}
+
+	// Namespaces come from the header
+	if code, _ := do(t, "GET", ts.URL+"/v1/keys/hello", "b", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 in other namespace, got %d", code)
+	}
+
+	if code, _ := do(t, "DELETE", ts.URL+"/v1/keys/hello", "a", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE returned %d", code)
+	}
+	if code, _ := do(t, "DELETE", ts.URL+"/v1/keys/hello", "a", ""); code != http.StatusNotFound {
+		t.Fatalf("Second DELETE returned %d", code)
+	}
+}
+
+func TestServerTTL(t *testing.T) {
+	ts := newTestServer(t)
+
+	do(t, "PUT", ts.URL+"/v1/keys/short?ttl=20ms", "", "v")
+	do(t, "PUT", ts.URL+"/v1/keys/seconds?ttl=60", "", "v")
+	time.Sleep(40 * time.Millisecond)
+
+	if code, _ := do(t, "GET", ts.URL+"/v1/keys/short", "", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected expired key, got %d", code)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/v1/keys/seconds", "", ""); code != http.StatusOK {
+		t.Fatalf("Expected live key, got %d", code)
+	}
+	for _, ttl := range []string{"soon", "0", "-1", "NaN", "Inf", "1e10", "1e400"} {
+		if code, _ := do(t, "PUT", ts.URL+"/v1/keys/bad?ttl="+ttl, "", "v"); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for ttl %s, got %d", ttl, code)
+		}
+	}
+}
+
+func TestServerMaxValueSize(t *testing.T) {
+	ts := newTestServer(t, WithMaxValueSize(4))
+
+	if code, _ := do(t, "PUT", ts.URL+"/v1/keys/k", "", "too large"); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d", code)
+	}
+}
+
+func TestServerSimilar(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, key := range []string{"hello", "hallo", "help", "world"} {
+		do(t, "PUT", ts.URL+"/v1/keys/"+key, "", "v:"+key)
+	}
+
+	code, body := do(t, "GET", ts.URL+"/v1/similar?key=helo", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET similar returned %d %s", code, body)
+	}
+	var match Match
+	if err := json.Unmarshal([]byte(body), &match); err != nil {
+		t.Fatal(err)
+	}
+	if match.Key != "hello" || string(match.Value) != "v:hello" {
+		t.Fatalf("Unexpected match %+v", match)
+	}
+
+	code, body = do(t, "GET", ts.URL+"/v1/similar/top?key=hello&k=2", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET top returned %d %s", code, body)
+	}
+	var top struct{ Matches []Match }
+	if err := json.Unmarshal([]byte(body), &top); err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Matches) != 2 || top.Matches[0].Key != "hello" {
+		t.Fatalf("Unexpected top matches %+v", top.Matches)
+	}
+
+	if code, _ := do(t, "GET", ts.URL+"/v1/similar?key=zzzzzzzz", "", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 without match, got %d", code)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/v1/similar/top?key=hello&k=0", "", ""); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for k=0, got %d", code)
+	}
+}
+
+func TestServerStatsAndNamespaces(t *testing.T) {
+	ts := newTestServer(t)
+
+	do(t, "PUT", ts.URL+"/v1/keys/a", "one", "v")
+	do(t, "PUT", ts.URL+"/v1/keys/b", "one", "v")
+	do(t, "PUT", ts.URL+"/v1/keys/a", "two", "v")
+	do(t, "GET", ts.URL+"/v1/keys/a", "one", "")
+
+	_, body := do(t, "GET", ts.URL+"/v1/stats", "", "")
+	var stats StatsResponse
+	json.Unmarshal([]byte(body), &stats)
+	if stats.Entries != 3 || stats.Sets != 3 || stats.Hits != 1 {
+		t.Fatalf("Unexpected cache stats %+v", stats)
+	}
+
+	_, body = do(t, "GET", ts.URL+"/v1/stats", "two", "")
+	json.Unmarshal([]byte(body), &stats)
+	if stats.Namespace != "two" || stats.Entries != 1 || stats.Hits != 0 {
+		t.Fatalf("Unexpected namespace stats %+v", stats)
+	}
+
+	_, body = do(t, "GET", ts.URL+"/v1/namespaces", "", "")
+	var resp struct{ Namespaces []Namespace }
+	json.Unmarshal([]byte(body), &resp)
+	if len(resp.Namespaces) != 2 || resp.Namespaces[0] != (Namespace{"one", 2}) {
+		t.Fatalf("Unexpected namespaces %+v", resp.Namespaces)
+	}
+}
@@ -2,6 +2,7 @@ package synapse
 
 import (
 	"context"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -11,169 +12,418 @@ import (
 // Shard represents a single shard of the cache
 type Shard[K comparable, V any] struct {
 	mu             sync.RWMutex
-	data           map[K]*Entry[K, V]
-	keys           []K // For similarity search iteration
+	partitions     map[string]*partition[K, V]
+	size           int // Total number of entries across partitions
 	evictionPolicy eviction.EvictionPolicy
 	maxSize        int
+	quotas         map[string]int // Per-shard namespace quotas
+	defaultQuota   int
 	similarity     SimilarityFunc[K]
-	threshold      float64
 	ttl            time.Duration
+	grace          time.Duration // Stale-while-revalidate grace period
+	negativeTTL    time.Duration // How long keys found absent are remembered
 	stats          *shardStats
 	enableStats    bool
+	onEvict        EvictionCallback[K, V]
+	index          int          // Position of the shard, for logging
+	logger         *slog.Logger // nil without logging
+	events         *eventBus[K, V]
+	pending        []Event[K, V]    // Events awaiting delivery, guarded by mu
+	origin         string           // Origin of the operation holding mu
+	queueMu        sync.Mutex       // Guards queue and delivering
+	queue          []delivery[K, V] // Batches awaiting delivery, in order
+	delivering     bool             // Whether a goroutine is draining queue
+	touchMu        sync.Mutex       // Guards access tracking under the read lock
 }
 
+// partition holds the entries of a single namespace within a shard, so that
+// the same key can be stored independently in different namespaces
+type partition[K comparable, V any] struct {
+	data  map[K]*Entry[K, V]
+	keys  *keyList[K]               // Insertion order, for similarity search and eviction
+	tags  map[string]map[K]struct{} // Reverse index from tag to keys
+	stats *shardStats
+
+	absent map[K]time.Time // Negatively cached keys and their expiry
+}
+
+// EvictionKey identifies an entry across namespaces. It is the key type
+// reported to the eviction policy, as entries of different namespaces may
+// share a key. Its eviction group is the shard holding the entry.
+type EvictionKey[K comparable] struct {
+	Namespace string
+	Key       K
+
+	shard int
+}
+
+// EvictionGroup implements eviction.GroupedKey
+func (k EvictionKey[K]) EvictionGroup() int {
+	return k.shard
+}
+
+// policyKey returns the key reported to the eviction policy for a key of
+// this shard
+func (s *Shard[K, V]) policyKey(namespace string, key K) nsKey[K] {
+	return nsKey[K]{Namespace: namespace, Key: key, shard: s.index}
+}
+
+// nsKey is the short name used within the package
+type nsKey[K comparable] = EvictionKey[K]
+
 // newShard creates a new cache shard
-func newShard[K comparable, V any](maxSize int, similarity SimilarityFunc[K], threshold float64, ttl time.Duration, policy eviction.EvictionPolicy, enableStats bool) *Shard[K, V] {
+func newShard[K comparable, V any](maxSize int, similarity SimilarityFunc[K], ttl time.Duration, policy eviction.EvictionPolicy, enableStats bool) *Shard[K, V] {
 	s := &Shard[K, V]{
-		data:           make(map[K]*Entry[K, V]),
-		keys:           make([]K, 0),
+		partitions:     make(map[string]*partition[K, V]),
 		evictionPolicy: policy,
 		maxSize:        maxSize,
 		similarity:     similarity,
-		threshold:      threshold,
 		ttl:            ttl,
 		enableStats:    enableStats,
 	}
 	if enableStats {
-		s.stats = newShardStats()
+		s.stats = newHistogramStats()
 	}
 	return s
 }
 
+// partition returns the partition for a namespace, creating it if needed;
+// the caller must hold the write lock
+func (s *Shard[K, V]) partition(namespace string) *partition[K, V] {
+	p, ok := s.partitions[namespace]
+	if !ok {
+		p = &partition[K, V]{
+			data: make(map[K]*Entry[K, V]),
+			keys: newKeyList[K](),
+			tags: make(map[string]map[K]struct{}),
+		}
+		if s.enableStats {
+			p.stats = newShardStats()
+		}
+		s.partitions[namespace] = p
+	}
+	return p
+}
+
+// indexTags adds an entry's tags to the reverse tag index
+func (p *partition[K, V]) indexTags(entry *Entry[K, V]) {
+	for _, tag := range entry.Tags {
+		keys, ok := p.tags[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			p.tags[tag] = keys
+		}
+		keys[entry.Key] = struct{}{}
+	}
+}
+
+// unindexTags removes an entry's tags from the reverse tag index
+func (p *partition[K, V]) unindexTags(entry *Entry[K, V]) {
+	for _, tag := range entry.Tags {
+		keys := p.tags[tag]
+		delete(keys, entry.Key)
+		if len(keys) == 0 {
+			delete(p.tags, tag)
+		}
+	}
+}
+
+// record applies a statistics update to the shard and, if given, to the
+// namespace partition
+func (s *Shard[K, V]) record(p *partition[K, V], update func(*shardStats)) {
+	if !s.enableStats {
+		return
+	}
+	update(s.stats)
+	if p != nil {
+		update(p.stats)
+	}
+}
+
 // get retrieves a value by exact key match
 func (s *Shard[K, V]) get(ctx context.Context, key K) (V, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	entry, ok := s.getEntry(ctx, key)
+	return entry.Value, ok
+}
+
+// getEntry retrieves a copy of the entry for an exact key match
+func (s *Shard[K, V]) getEntry(ctx context.Context, key K) (Entry[K, V], bool) {
+	entry, status := s.lookup(ctx, key)
+	return entry, status == LookupHit
+}
 
+// lookup retrieves a copy of the entry for an exact key match and reports
+// whether the key was found, negatively cached or missing
+func (s *Shard[K, V]) lookup(ctx context.Context, key K) (Entry[K, V], LookupStatus) {
 	// Check context cancellation
 	select {
 	case <-ctx.Done():
-		var zero V
-		return zero, false
+		return Entry[K, V]{}, LookupMiss
 	default:
 	}
 
+	if s.stats != nil {
+		defer s.stats.getLatency.observeSince(time.Now())
+	}
+
 	namespace := GetNamespace(ctx)
 
-	entry, ok := s.data[key]
+	s.mu.RLock()
+	if p := s.partitions[namespace]; p != nil && p.isAbsent(key) {
+		s.record(p, (*shardStats).recordNegativeHit)
+		s.mu.RUnlock()
+		return Entry[K, V]{}, LookupNegative
+	}
+	entry, ok := s.lookupLocked(namespace, key)
+	var result Entry[K, V]
+	if ok {
+		result = s.snapshot(entry)
+	}
+	s.mu.RUnlock()
+
+	if entry != nil && !ok {
+		s.removeExpired(namespace, entry)
+	}
+
 	if !ok {
-		if s.enableStats {
-			s.stats.recordMiss()
-		}
-		var zero V
-		return zero, false
+		return result, LookupMiss
 	}
+	return result, LookupHit
+}
 
-	// Check namespace match
-	if namespace != "" && entry.Namespace != namespace {
-		if s.enableStats {
-			s.stats.recordMiss()
-		}
-		var zero V
-		return zero, false
+// peek retrieves a copy of a live entry without recording the access
+func (s *Shard[K, V]) peek(ctx context.Context, key K) (Entry[K, V], bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := s.partitions[GetNamespace(ctx)]
+	if p == nil {
+		return Entry[K, V]{}, false
 	}
 
-	// Check expiration
-	if entry.IsExpired() {
-		if s.enableStats {
-			s.stats.recordExpired()
-			s.stats.recordMiss()
-		}
-		var zero V
-		return zero, false
+	entry, ok := p.data[key]
+	if !ok || entry.IsExpired() {
+		return Entry[K, V]{}, false
 	}
+	return s.snapshot(entry), true
+}
 
-	// Update access tracking
+// touch records an access to an entry. Readers share the read lock, so
+// access tracking is serialized separately.
+func (s *Shard[K, V]) touch(entry *Entry[K, V]) {
+	s.touchMu.Lock()
+	defer s.touchMu.Unlock()
 	entry.Touch()
-	if s.evictionPolicy != nil {
-		s.evictionPolicy.OnAccess(key)
+}
+
+// snapshot returns a copy of an entry that is safe to take under the read
+// lock while other readers record accesses
+func (s *Shard[K, V]) snapshot(entry *Entry[K, V]) Entry[K, V] {
+	s.touchMu.Lock()
+	defer s.touchMu.Unlock()
+	return *entry
+}
+
+// lookupLocked looks up a live entry and records the access; the caller must
+// hold at least the read lock. If the entry exists but has expired, it is
+// returned with ok set to false so the caller can remove it with
+// removeExpired once the read lock is released.
+func (s *Shard[K, V]) lookupLocked(namespace string, key K) (*Entry[K, V], bool) {
+	p := s.partitions[namespace]
+	if p == nil {
+		s.record(nil, (*shardStats).recordMiss)
+		return nil, false
+	}
+
+	entry, ok := p.data[key]
+	if !ok {
+		s.record(p, (*shardStats).recordMiss)
+		return nil, false
+	}
+
+	// Check expiration. Within the stale-while-revalidate grace period the
+	// expired value is still served while the cache reloads it.
+	if entry.IsExpired() && !s.inGrace(entry) {
+		s.record(p, (*shardStats).recordExpired)
+		s.record(p, (*shardStats).recordMiss)
+		return entry, false
 	}
 
-	if s.enableStats {
-		s.stats.recordHit()
+	// Update access tracking
+	s.touch(entry)
+	if s.evictionPolicy != nil {
+		s.evictionPolicy.OnAccess(s.policyKey(namespace, key))
 	}
 
-	return entry.Value, true
+	s.record(p, (*shardStats).recordHit)
+
+	return entry, true
 }
 
-// getSimilar finds the most similar key above the threshold
-func (s *Shard[K, V]) getSimilar(ctx context.Context, key K) (V, K, float64, bool) {
+// inGrace reports whether an expired entry may still be served while it is
+// being refreshed
+func (s *Shard[K, V]) inGrace(entry *Entry[K, V]) bool {
+	return s.grace > 0 && time.Now().Before(entry.ExpiresAt.Add(s.grace))
+}
+
+// similarResult is the outcome of a similarity search of one shard
+type similarResult[K comparable, V any] struct {
+	entry Entry[K, V]
+	score float64
+	found bool
+	// nearMiss is the best score below the threshold, if any candidates
+	// were scored
+	nearMiss   float64
+	candidates int
+	// similarityTime is the time spent in the similarity function, only
+	// measured for traced searches
+	similarityTime time.Duration
+}
+
+// getSimilar finds the most similar key scoring at least threshold within
+// the context's namespace among the entries accepted by opts and returns a
+// copy of its entry. If timed is set, the time spent in the similarity
+// function is measured.
+func (s *Shard[K, V]) getSimilar(ctx context.Context, key K, threshold float64, opts *SimilarOptions, timed bool) similarResult[K, V] {
+	if s.stats != nil {
+		defer s.stats.similarLatency.observeSince(time.Now())
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	var result similarResult[K, V]
+
 	// Check context cancellation
 	select {
 	case <-ctx.Done():
-		var zeroV V
-		var zeroK K
-		return zeroV, zeroK, 0, false
+		return result
 	default:
 	}
 
-	if s.enableStats {
-		s.stats.recordSimilarSearch()
-	}
-
 	namespace := GetNamespace(ctx)
+	p := s.partitions[namespace]
+
+	s.record(p, (*shardStats).recordSimilarSearch)
 
-	var bestKey K
-	var bestValue V
+	var best *Entry[K, V]
 	bestScore := 0.0
-	found := false
 
-	for _, k := range s.keys {
-		entry := s.data[k]
+	if p == nil {
+		return result
+	}
 
-		// Check namespace match
-		if namespace != "" && entry.Namespace != namespace {
-			continue
-		}
+	for k := range p.keys.all() {
+		entry := p.data[k]
 
 		// Check expiration
 		if entry.IsExpired() {
 			continue
 		}
 
+		// Skip candidates rejected by the metadata filters
+		if !opts.accepts(entry.Metadata) {
+			continue
+		}
+
 		// Check context cancellation periodically
 		select {
 		case <-ctx.Done():
-			var zeroV V
-			var zeroK K
-			return zeroV, zeroK, 0, false
+			return similarResult[K, V]{}
 		default:
 		}
 
 		// Compute similarity
 		if s.similarity != nil {
+			var start time.Time
+			if timed {
+				start = time.Now()
+			}
 			score := s.similarity(key, k)
-			if score >= s.threshold && score > bestScore {
-				bestKey = k
-				bestValue = entry.Value
-				bestScore = score
-				found = true
+			if timed {
+				result.similarityTime += time.Since(start)
+			}
+
+			if score >= threshold {
+				if score > bestScore {
+					best = entry
+					bestScore = score
+				}
+			} else if result.candidates == 0 || score > result.nearMiss {
+				result.nearMiss = score
 			}
+			result.candidates++
 		}
 	}
 
-	if found {
-		// Update access tracking
-		entry := s.data[bestKey]
-		entry.Touch()
-		if s.evictionPolicy != nil {
-			s.evictionPolicy.OnAccess(bestKey)
+	if best == nil {
+		if result.candidates > 0 && s.stats != nil {
+			s.stats.nearMissScores.observe(result.nearMiss)
 		}
-		if s.enableStats {
-			s.stats.recordSimilarHit()
+		return result
+	}
+
+	// Update access tracking
+	s.touch(best)
+	if s.evictionPolicy != nil {
+		s.evictionPolicy.OnAccess(s.policyKey(namespace, best.Key))
+	}
+	s.record(p, (*shardStats).recordSimilarHit)
+	if s.stats != nil {
+		s.stats.similarScores.observe(bestScore)
+	}
+
+	result.entry = s.snapshot(best)
+	result.score = bestScore
+	result.found = true
+	return result
+}
+
+// topSimilar returns copies of up to k entries of the context's namespace
+// scoring at least threshold, best first. Unlike getSimilar it does not
+// record accesses, since callers may discard most of the candidates.
+func (s *Shard[K, V]) topSimilar(ctx context.Context, key K, k int, threshold float64, opts *SimilarOptions) []SimilarMatch[K, V] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := s.partitions[GetNamespace(ctx)]
+	s.record(p, (*shardStats).recordSimilarSearch)
+
+	if p == nil || s.similarity == nil || k <= 0 {
+		return nil
+	}
+
+	var matches []SimilarMatch[K, V]
+	i := 0
+	for candidate := range p.keys.all() {
+		// Check context cancellation periodically
+		if i%256 == 0 && ctx.Err() != nil {
+			return nil
 		}
+		i++
+
+		entry := p.data[candidate]
+		if entry.IsExpired() || !opts.accepts(entry.Metadata) {
+			continue
+		}
+
+		score := s.similarity(key, candidate)
+		if score < threshold {
+			continue
+		}
+		matches = insertMatch(matches, SimilarMatch[K, V]{Entry: s.snapshot(entry), Score: score}, k)
 	}
 
-	return bestValue, bestKey, bestScore, found
+	if len(matches) > 0 {
+		s.record(p, (*shardStats).recordSimilarHit)
+	}
+	return matches
 }
 
 // set stores a value
-func (s *Shard[K, V]) set(ctx context.Context, key K, value V) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Shard[K, V]) set(ctx context.Context, key K, value V, opts *SetOptions) error {
+	s.lock(ctx)
+	defer s.unlock()
 
 	// Check context cancellation
 	select {
@@ -182,48 +432,189 @@ func (s *Shard[K, V]) set(ctx context.Context, key K, value V) error {
 	default:
 	}
 
+	return s.setLocked(GetNamespace(ctx), key, value, opts)
+}
+
+// replace stores a reloaded value for a key of the context's namespace if
+// its entry is still the one last updated at updatedAt, so that a reload
+// never undoes a write or delete made while it was in flight. It reports
+// whether the value was stored.
+func (s *Shard[K, V]) replace(ctx context.Context, key K, updatedAt time.Time, value V, opts *SetOptions) (bool, error) {
+	s.lock(ctx)
+	defer s.unlock()
+
+	namespace := GetNamespace(ctx)
+	if !s.unchangedLocked(namespace, key, updatedAt) {
+		return false, nil
+	}
+	return true, s.setLocked(namespace, key, value, opts)
+}
+
+// deleteUnchanged removes a key of the context's namespace if its entry is
+// still the one last updated at updatedAt
+func (s *Shard[K, V]) deleteUnchanged(ctx context.Context, key K, updatedAt time.Time) bool {
+	s.lock(ctx)
+	defer s.unlock()
+
 	namespace := GetNamespace(ctx)
+	if !s.unchangedLocked(namespace, key, updatedAt) {
+		return false
+	}
+	_, ok := s.deleteLocked(namespace, key)
+	return ok
+}
+
+// unchangedLocked reports whether a key is stored, possibly expired, with
+// the given update time; the caller must hold the write lock
+func (s *Shard[K, V]) unchangedLocked(namespace string, key K, updatedAt time.Time) bool {
+	p := s.partitions[namespace]
+	if p == nil {
+		return false
+	}
+	entry, ok := p.data[key]
+	return ok && entry.UpdatedAt.Equal(updatedAt)
+}
+
+// setLocked stores a value; the caller must hold the write lock
+func (s *Shard[K, V]) setLocked(namespace string, key K, value V, opts *SetOptions) error {
+	p := s.partition(namespace)
+
+	// An expired entry is replaced rather than updated
+	if entry, ok := p.data[key]; ok && entry.IsExpired() {
+		s.removeLocked(p, key, EvictionReasonExpired)
+	}
+
+	if entry, ok := p.data[key]; ok && opts.IfNewer && !opts.updateTime().After(entry.UpdatedAt) {
+		return ErrStale
+	}
+	delete(p.absent, key)
 
 	// Check if key already exists
-	if entry, ok := s.data[key]; ok {
+	if entry, ok := p.data[key]; ok {
 		entry.Value = value
+		entry.Metadata = opts.metadata()
+		p.unindexTags(entry)
+		entry.Tags = opts.tags()
+		p.indexTags(entry)
 		entry.Touch()
-		if s.evictionPolicy != nil {
-			s.evictionPolicy.OnAccess(key)
+		entry.UpdatedAt = entry.AccessedAt
+		if !opts.UpdatedAt.IsZero() {
+			entry.UpdatedAt = opts.UpdatedAt
 		}
-		if s.enableStats {
-			s.stats.recordSet()
+		// Overwriting an entry replaces its TTL with the write's, falling
+		// back to the cache's; without either the entry no longer expires.
+		// KeepTTL leaves the expiry as it is.
+		switch {
+		case opts.KeepTTL:
+		case opts.TTL > 0:
+			entry.ExpiresAt = entry.AccessedAt.Add(opts.TTL)
+		case s.ttl > 0:
+			entry.ExpiresAt = entry.AccessedAt.Add(s.ttl)
+		default:
+			entry.ExpiresAt = time.Time{}
+		}
+		if s.evictionPolicy != nil {
+			s.evictionPolicy.OnAccess(s.policyKey(namespace, key))
 		}
+		s.record(p, (*shardStats).recordSet)
+		s.emit(Event[K, V]{Type: EventUpdate, Entry: *entry, Time: entry.UpdatedAt})
 		return nil
 	}
 
+	// Evict from the namespace itself if it is over quota, so that one
+	// namespace cannot push out the entries of another
+	if quota := s.quota(namespace); quota > 0 && len(p.data) >= quota {
+		s.evictFrom(namespace, p)
+	}
+
 	// Evict if necessary
-	if s.maxSize > 0 && len(s.data) >= s.maxSize {
+	if s.maxSize > 0 && s.size >= s.maxSize {
 		if err := s.evict(); err != nil {
 			return err
 		}
 	}
 
 	// Create new entry
-	entry := newEntry(key, value, s.ttl, namespace)
-	s.data[key] = entry
-	s.keys = append(s.keys, key)
+	ttl := s.ttl
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	entry := newEntry(key, value, ttl, namespace)
+	entry.Metadata = opts.metadata()
+	entry.Tags = opts.tags()
+	if !opts.UpdatedAt.IsZero() {
+		entry.UpdatedAt = opts.UpdatedAt
+	}
+	p.data[key] = entry
+	p.indexTags(entry)
+	p.keys.pushBack(key)
+	s.size++
 
 	if s.evictionPolicy != nil {
-		s.evictionPolicy.OnAdd(key, entry.AccessCount, entry.CreatedAt, entry.AccessedAt)
+		s.evictionPolicy.OnAdd(s.policyKey(namespace, key), entry.AccessCount, entry.CreatedAt, entry.AccessedAt)
 	}
 
-	if s.enableStats {
-		s.stats.recordSet()
-	}
+	s.record(p, (*shardStats).recordSet)
+	s.emit(Event[K, V]{Type: EventSet, Entry: *entry, Time: entry.UpdatedAt})
 
 	return nil
 }
 
-// delete removes a key from the shard
+// setAbsent negatively caches a key of the context's namespace that a load
+// found missing, unless a live entry has been stored in the meantime
+func (s *Shard[K, V]) setAbsent(ctx context.Context, key K) {
+	if s.negativeTTL <= 0 {
+		return
+	}
+
+	s.lock(ctx)
+	defer s.unlock()
+
+	p := s.partition(GetNamespace(ctx))
+	if entry, ok := p.data[key]; ok {
+		if !entry.IsExpired() {
+			return
+		}
+		s.removeLocked(p, key, EvictionReasonExpired)
+		s.record(p, (*shardStats).recordExpired)
+	}
+
+	if p.absent == nil {
+		p.absent = make(map[K]time.Time)
+	} else if s.maxSize > 0 && len(p.absent) >= s.maxSize {
+		p.pruneAbsent(s.maxSize)
+	}
+	p.absent[key] = time.Now().Add(s.negativeTTL)
+}
+
+// isAbsent reports whether a key is negatively cached; the caller must hold
+// at least the read lock
+func (p *partition[K, V]) isAbsent(key K) bool {
+	expiry, ok := p.absent[key]
+	return ok && time.Now().Before(expiry)
+}
+
+// pruneAbsent drops expired negatively cached keys and, if that is not
+// enough, arbitrary ones until fewer than limit remain
+func (p *partition[K, V]) pruneAbsent(limit int) {
+	now := time.Now()
+	for key, expiry := range p.absent {
+		if !now.Before(expiry) {
+			delete(p.absent, key)
+		}
+	}
+	for key := range p.absent {
+		if len(p.absent) < limit {
+			break
+		}
+		delete(p.absent, key)
+	}
+}
+
+// delete removes a key from the context's namespace
 func (s *Shard[K, V]) delete(ctx context.Context, key K) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.lock(ctx)
+	defer s.unlock()
 
 	// Check context cancellation
 	select {
@@ -232,78 +623,495 @@ func (s *Shard[K, V]) delete(ctx context.Context, key K) bool {
 	default:
 	}
 
-	if _, ok := s.data[key]; !ok {
+	_, ok := s.deleteLocked(GetNamespace(ctx), key)
+	return ok
+}
+
+// deleteOlder removes a key of the context's namespace unless its entry was
+// updated at or after t
+func (s *Shard[K, V]) deleteOlder(ctx context.Context, key K, t time.Time) bool {
+	s.lock(ctx)
+	defer s.unlock()
+
+	namespace := GetNamespace(ctx)
+	if p := s.partitions[namespace]; p != nil {
+		if entry, ok := p.data[key]; ok && !entry.UpdatedAt.Before(t) {
+			return false
+		}
+	}
+	_, ok := s.deleteLocked(namespace, key)
+	return ok
+}
+
+// expire sets the expiration time of a key in the context's namespace
+func (s *Shard[K, V]) expire(ctx context.Context, key K, ttl time.Duration) bool {
+	s.lock(ctx)
+	defer s.unlock()
+
+	p := s.partitions[GetNamespace(ctx)]
+	if p == nil {
 		return false
 	}
 
-	delete(s.data, key)
+	entry, ok := p.data[key]
+	if !ok {
+		return false
+	}
+	if entry.IsExpired() {
+		s.removeLocked(p, key, EvictionReasonExpired)
+		s.record(p, (*shardStats).recordExpired)
+		return false
+	}
 
-	// Remove from keys slice
-	for i, k := range s.keys {
-		if k == key {
-			s.keys = append(s.keys[:i], s.keys[i+1:]...)
-			break
-		}
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(ttl)
+	} else {
+		entry.ExpiresAt = time.Time{}
+	}
+	return true
+}
+
+// deleteLocked removes a key and returns its entry; the caller must hold the
+// write lock
+func (s *Shard[K, V]) deleteLocked(namespace string, key K) (*Entry[K, V], bool) {
+	p := s.partitions[namespace]
+	if p == nil {
+		return nil, false
+	}
+
+	entry, ok := p.data[key]
+	if !ok {
+		return nil, false
 	}
 
+	s.removeLocked(p, key, EvictionReasonDeleted)
+	s.record(p, (*shardStats).recordDelete)
+
+	return entry, true
+}
+
+// removeLocked removes an existing key from the partition and the eviction
+// policy; the caller must hold the write lock
+func (s *Shard[K, V]) removeLocked(p *partition[K, V], key K, reason EvictionReason) {
+	entry := p.data[key]
+	delete(p.data, key)
+	p.unindexTags(entry)
+	s.size--
+
+	p.keys.remove(key)
+
 	if s.evictionPolicy != nil {
-		s.evictionPolicy.OnRemove(key)
+		s.evictionPolicy.OnRemove(s.policyKey(entry.Namespace, key))
 	}
 
-	if s.enableStats {
-		s.stats.recordDelete()
+	s.emitRemoval(entry, reason)
+}
+
+// removeExpired removes an entry found to be expired under the read lock,
+// unless it has been replaced or removed in the meantime
+func (s *Shard[K, V]) removeExpired(namespace string, entry *Entry[K, V]) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	p := s.partitions[namespace]
+	if p == nil || p.data[entry.Key] != entry {
+		return
 	}
+	s.removeLocked(p, entry.Key, EvictionReasonExpired)
+}
 
-	return true
+// removeWhere removes every entry matching pred from the namespaces accepted
+// by match in a single pass and returns the number of entries removed. A nil
+// pred removes every entry and negatively cached key.
+func (s *Shard[K, V]) removeWhere(match func(namespace string) bool, pred func(*Entry[K, V]) bool) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	count := 0
+	for namespace, p := range s.partitions {
+		if !match(namespace) {
+			continue
+		}
+
+		for k := range p.keys.all() {
+			entry := p.data[k]
+			if pred != nil && !pred(entry) {
+				continue
+			}
+
+			delete(p.data, k)
+			p.keys.remove(k)
+			p.unindexTags(entry)
+			if s.evictionPolicy != nil {
+				s.evictionPolicy.OnRemove(s.policyKey(namespace, k))
+			}
+			s.emitRemoval(entry, EvictionReasonCleared)
+			s.record(p, (*shardStats).recordDelete)
+			count++
+		}
+
+		if pred == nil {
+			p.absent = nil
+		}
+	}
+	s.size -= count
+
+	return count
+}
+
+// invalidateTag removes every entry of the namespace carrying tag and returns
+// the number of entries removed
+func (s *Shard[K, V]) invalidateTag(namespace, tag string) int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	p := s.partitions[namespace]
+	if p == nil {
+		return 0
+	}
+
+	keys := p.tags[tag]
+	count := 0
+	for key := range keys {
+		// removeLocked drops the key from the index we are ranging over,
+		// which is safe for Go maps
+		s.removeLocked(p, key, EvictionReasonCleared)
+		s.record(p, (*shardStats).recordDelete)
+		count++
+	}
+
+	return count
+}
+
+// lock takes the write lock on behalf of the operation carried by ctx
+func (s *Shard[K, V]) lock(ctx context.Context) {
+	s.mu.Lock()
+	s.origin = GetOrigin(ctx)
+}
+
+// emit queues an event for delivery once the write lock is released; the
+// caller must hold the write lock
+func (s *Shard[K, V]) emit(ev Event[K, V]) {
+	if s.onEvict == nil && s.logger == nil && !s.events.enabled() {
+		return
+	}
+	ev.Origin = s.origin
+	s.pending = append(s.pending, ev)
+}
+
+// emitRemoval queues the event for a removed entry; the caller must hold the
+// write lock
+func (s *Shard[K, V]) emitRemoval(entry *Entry[K, V], reason EvictionReason) {
+	s.emit(Event[K, V]{Type: removalEvent(reason), Entry: *entry, Reason: reason, Time: time.Now()})
+}
+
+// delivery is a batch of events queued by one write operation
+type delivery[K comparable, V any] struct {
+	events  []Event[K, V]
+	onEvict EvictionCallback[K, V]
+}
+
+// unlock releases the write lock and then delivers the events queued while it
+// was held to the eviction callback and subscribers. Batches are queued
+// before the write lock is released, so they are delivered in the order they
+// happened, by whichever goroutine finds the queue idle. No lock is held
+// while delivering, so callbacks and subscribers may call back into the
+// cache; their own events are delivered once they return.
+func (s *Shard[K, V]) unlock() {
+	pending := s.pending
+	s.pending = nil
+	s.origin = ""
+	if len(pending) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	s.queueMu.Lock()
+	s.queue = append(s.queue, delivery[K, V]{pending, s.onEvict})
+	owner := !s.delivering
+	s.delivering = true
+	s.queueMu.Unlock()
+	s.mu.Unlock()
+
+	if owner {
+		s.deliver()
+	}
+}
+
+// deliver drains the delivery queue until it is empty
+func (s *Shard[K, V]) deliver() {
+	drained := false
+	defer func() {
+		// Hand the queue over to the next writer if a callback panics
+		if !drained {
+			s.queueMu.Lock()
+			s.delivering = false
+			s.queueMu.Unlock()
+		}
+	}()
+
+	for {
+		s.queueMu.Lock()
+		queue := s.queue
+		s.queue = nil
+		if len(queue) == 0 {
+			s.delivering = false
+			drained = true
+			s.queueMu.Unlock()
+			return
+		}
+		s.queueMu.Unlock()
+
+		for _, d := range queue {
+			for _, ev := range d.events {
+				removal := ev.Type != EventSet && ev.Type != EventUpdate
+				if removal && s.logger != nil {
+					s.logRemoval(ev)
+				}
+				if removal && d.onEvict != nil {
+					d.onEvict(ev.Entry.Key, ev.Entry.Value, ev.Reason)
+				}
+				s.events.publish(ev)
+			}
+		}
+	}
+}
+
+// getMany looks up the keys at the given positions under a single read lock,
+// writing the outcome into results
+func (s *Shard[K, V]) getMany(ctx context.Context, keys []K, positions []int, results []Result[V]) {
+	namespace := GetNamespace(ctx)
+
+	s.mu.RLock()
+
+	var expired []*Entry[K, V]
+	for _, i := range positions {
+		entry, ok := s.lookupLocked(namespace, keys[i])
+		if ok {
+			results[i].Value, results[i].Found = entry.Value, true
+		} else if entry != nil {
+			expired = append(expired, entry)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, entry := range expired {
+		s.removeExpired(namespace, entry)
+	}
+}
+
+// setMany stores the items at the given positions under a single write lock,
+// writing any per-item error into errs
+func (s *Shard[K, V]) setMany(ctx context.Context, items []Item[K, V], opts *SetOptions, positions []int, errs []error) {
+	s.lock(ctx)
+	defer s.unlock()
+
+	namespace := GetNamespace(ctx)
+	for _, i := range positions {
+		errs[i] = s.setLocked(namespace, items[i].Key, items[i].Value, opts)
+	}
+}
+
+// deleteMany removes the keys at the given positions under a single write
+// lock, writing the removed values into results
+func (s *Shard[K, V]) deleteMany(ctx context.Context, keys []K, positions []int, results []Result[V]) {
+	s.lock(ctx)
+	defer s.unlock()
+
+	namespace := GetNamespace(ctx)
+	for _, i := range positions {
+		if entry, ok := s.deleteLocked(namespace, keys[i]); ok {
+			results[i].Value = entry.Value
+			results[i].Found = true
+		}
+	}
 }
 
 // evict removes an entry based on the eviction policy
 func (s *Shard[K, V]) evict() error {
-	if s.evictionPolicy == nil {
-		// No eviction policy, just remove the first key
-		if len(s.keys) > 0 {
-			key := s.keys[0]
-			delete(s.data, key)
-			s.keys = s.keys[1:]
-			if s.enableStats {
-				s.stats.recordEviction()
-			}
+	if p, key, ok := s.victim(); ok {
+		s.removeLocked(p, key, EvictionReasonCapacity)
+		s.record(p, (*shardStats).recordEviction)
+	}
+	return nil
+}
+
+// victim returns the entry the eviction policy picks among the entries of
+// this shard. Without a policy, or if the policy picks none of them, it
+// returns the oldest entry. The policy is shared by all shards, so it may pick
+// a key held by another shard; that key is left alone.
+func (s *Shard[K, V]) victim() (*partition[K, V], K, bool) {
+	if s.evictionPolicy != nil {
+		var selected any
+		var ok bool
+		accept := func(key any) bool {
+			k, isKey := key.(nsKey[K])
+			return isKey && s.holds(k)
+		}
+		if grouped, isGrouped := s.evictionPolicy.(eviction.GroupedPolicy); isGrouped {
+			selected, ok = grouped.SelectVictimIn(s.index, accept)
+		} else if scoped, isScoped := s.evictionPolicy.(eviction.ScopedPolicy); isScoped {
+			selected, ok = scoped.SelectVictimFunc(accept)
+		} else {
+			selected, ok = s.evictionPolicy.SelectVictim()
+		}
+		if key, isKey := selected.(nsKey[K]); ok && isKey && s.holds(key) {
+			return s.partitions[key.Namespace], key.Key, true
 		}
-		return nil
 	}
+	return s.oldest()
+}
 
-	victim, ok := s.evictionPolicy.SelectVictim()
-	if !ok {
-		return nil
+// holds reports whether the shard stores the key
+func (s *Shard[K, V]) holds(key nsKey[K]) bool {
+	p := s.partitions[key.Namespace]
+	return p != nil && p.data[key.Key] != nil
+}
+
+// oldest returns the first key of any namespace that is oldest
+func (s *Shard[K, V]) oldest() (*partition[K, V], K, bool) {
+	var oldest *partition[K, V]
+	var oldestKey K
+	for _, p := range s.partitions {
+		key, ok := p.keys.front()
+		if !ok {
+			continue
+		}
+		if oldest == nil || p.data[key].CreatedAt.Before(oldest.data[oldestKey].CreatedAt) {
+			oldest, oldestKey = p, key
+		}
 	}
+	return oldest, oldestKey, oldest != nil
+}
+
+// quota returns the per-shard entry limit of a namespace, or 0 if unlimited
+func (s *Shard[K, V]) quota(namespace string) int {
+	if quota, ok := s.quotas[namespace]; ok {
+		return quota
+	}
+	return s.defaultQuota
+}
 
-	key, ok := victim.(K)
+// evictFrom removes an entry of the given namespace. The eviction policy
+// chooses the victim if it supports scoped selection; otherwise the oldest
+// entry of the namespace is removed.
+func (s *Shard[K, V]) evictFrom(namespace string, p *partition[K, V]) {
+	victim, ok := p.keys.front()
 	if !ok {
+		return
+	}
+
+	accept := func(key any) bool {
+		k, isKey := key.(nsKey[K])
+		return isKey && k.Namespace == namespace && p.data[k.Key] != nil
+	}
+	var selected any
+	var found bool
+	if grouped, ok := s.evictionPolicy.(eviction.GroupedPolicy); ok {
+		selected, found = grouped.SelectVictimIn(s.index, accept)
+	} else if scoped, ok := s.evictionPolicy.(eviction.ScopedPolicy); ok {
+		selected, found = scoped.SelectVictimFunc(accept)
+	}
+	if found {
+		victim = selected.(nsKey[K]).Key
+	}
+
+	s.removeLocked(p, victim, EvictionReasonCapacity)
+	s.record(p, (*shardStats).recordEviction)
+}
+
+// entries returns copies of the live entries in the namespace
+func (s *Shard[K, V]) entries(namespace string) []Entry[K, V] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := s.partitions[namespace]
+	if p == nil {
 		return nil
 	}
 
-	delete(s.data, key)
+	result := make([]Entry[K, V], 0, p.keys.len())
+	for k := range p.keys.all() {
+		entry := p.data[k]
 
-	// Remove from keys slice
-	for i, k := range s.keys {
-		if k == key {
-			s.keys = append(s.keys[:i], s.keys[i+1:]...)
-			break
+		// Skip expired entries
+		if entry.IsExpired() {
+			continue
 		}
+
+		snapshot := s.snapshot(entry)
+		result = append(result, snapshot.clone())
 	}
+	return result
+}
 
-	s.evictionPolicy.OnRemove(key)
+// len returns the number of entries in the shard
+func (s *Shard[K, V]) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
 
-	if s.enableStats {
-		s.stats.recordEviction()
+// info returns the size, capacity and statistics of the shard
+func (s *Shard[K, V]) info(index int) ShardInfo {
+	info := ShardInfo{Index: index, Len: s.len(), Capacity: s.maxSize}
+	if s.stats != nil {
+		info.Stats = s.stats.snapshot()
+		info.Histograms = s.stats.histograms()
 	}
+	return info
+}
 
-	return nil
+// namespaceLen returns the number of entries in the namespace
+func (s *Shard[K, V]) namespaceLen(namespace string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if p := s.partitions[namespace]; p != nil {
+		return len(p.data)
+	}
+	return 0
 }
 
-// len returns the number of entries in the shard
-func (s *Shard[K, V]) len() int {
+// namespaceLiveLen returns the number of unexpired entries in the namespace
+func (s *Shard[K, V]) namespaceLiveLen(namespace string) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.data)
+
+	p := s.partitions[namespace]
+	if p == nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range p.data {
+		if !entry.IsExpired() {
+			n++
+		}
+	}
+	return n
+}
+
+// namespaceStats returns the statistics of the namespace
+func (s *Shard[K, V]) namespaceStats(namespace string) Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if p := s.partitions[namespace]; p != nil && p.stats != nil {
+		return p.stats.snapshot()
+	}
+	return Stats{}
+}
+
+// namespaces returns the namespaces holding at least one entry
+func (s *Shard[K, V]) namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.partitions))
+	for namespace, p := range s.partitions {
+		if len(p.data) > 0 {
+			names = append(names, namespace)
+		}
+	}
+	return names
 }
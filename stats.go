@@ -2,18 +2,89 @@ package synapse
 
 import (
 	"sync/atomic"
+	"time"
 )
 
 // Stats contains cache performance statistics
 type Stats struct {
-	Hits            uint64
-	Misses          uint64
-	Sets            uint64
-	Deletes         uint64
+	Hits    uint64
+	Misses  uint64
+	Sets    uint64
+	Deletes uint64
+	// SimilarSearches and SimilarHits count GetSimilar and TopSimilar calls
+	// for the whole cache; per shard and per namespace they count searches
+	// of a single shard, which every call makes once per shard
 	SimilarSearches uint64
 	SimilarHits     uint64
 	Evictions       uint64
 	Expired         uint64
+	// NegativeHits counts lookups answered by a negatively cached key; they
+	// are counted as neither hits nor misses
+	NegativeHits uint64
+
+	// Elapsed is the time over which the statistics were collected
+	Elapsed time.Duration
+}
+
+// HitRatio returns the fraction of exact lookups that found a value.
+// Negative hits count as lookups that did not.
+func (s Stats) HitRatio() float64 {
+	return ratio(s.Hits, s.Hits+s.Misses+s.NegativeHits)
+}
+
+// SimilarHitRatio returns the fraction of similarity searches that found a
+// match
+func (s Stats) SimilarHitRatio() float64 {
+	return ratio(s.SimilarHits, s.SimilarSearches)
+}
+
+// PerSecond returns the rate of a counter over Elapsed, e.g.
+// stats.PerSecond(stats.Evictions)
+func (s Stats) PerSecond(n uint64) float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / s.Elapsed.Seconds()
+}
+
+// Sub returns the statistics collected since prev, an earlier snapshot of
+// the same cache, so that ratios and rates can be computed over an interval
+// rather than the cache's lifetime
+func (s Stats) Sub(prev Stats) Stats {
+	return Stats{
+		Hits:            s.Hits - prev.Hits,
+		Misses:          s.Misses - prev.Misses,
+		Sets:            s.Sets - prev.Sets,
+		Deletes:         s.Deletes - prev.Deletes,
+		SimilarSearches: s.SimilarSearches - prev.SimilarSearches,
+		SimilarHits:     s.SimilarHits - prev.SimilarHits,
+		Evictions:       s.Evictions - prev.Evictions,
+		Expired:         s.Expired - prev.Expired,
+		NegativeHits:    s.NegativeHits - prev.NegativeHits,
+		Elapsed:         s.Elapsed - prev.Elapsed,
+	}
+}
+
+// ratio returns n/total, or 0 if total is 0
+func ratio(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// add accumulates the counters of other into s
+func (s *Stats) add(other Stats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Sets += other.Sets
+	s.Deletes += other.Deletes
+	s.SimilarSearches += other.SimilarSearches
+	s.SimilarHits += other.SimilarHits
+	s.Evictions += other.Evictions
+	s.Expired += other.Expired
+	s.NegativeHits += other.NegativeHits
+	s.Elapsed = max(s.Elapsed, other.Elapsed)
 }
 
 // shardStats contains per-shard statistics using atomic counters
@@ -26,11 +97,30 @@ type shardStats struct {
 	similarHits     atomic.Uint64
 	evictions       atomic.Uint64
 	expired         atomic.Uint64
+	negativeHits    atomic.Uint64
+
+	started time.Time
+
+	// Histograms are only kept per shard, not per namespace
+	getLatency     *histogram
+	similarLatency *histogram
+	similarScores  *histogram
+	nearMissScores *histogram
 }
 
 // newShardStats creates a new shard stats tracker
 func newShardStats() *shardStats {
-	return &shardStats{}
+	return &shardStats{started: time.Now()}
+}
+
+// newHistogramStats creates a stats tracker that also keeps histograms
+func newHistogramStats() *shardStats {
+	s := newShardStats()
+	s.getLatency = newLatencyHistogram()
+	s.similarLatency = newLatencyHistogram()
+	s.similarScores = newScoreHistogram()
+	s.nearMissScores = newScoreHistogram()
+	return s
 }
 
 // recordHit increments the hit counter
@@ -73,6 +163,11 @@ func (s *shardStats) recordExpired() {
 	s.expired.Add(1)
 }
 
+// recordNegativeHit increments the negative hit counter
+func (s *shardStats) recordNegativeHit() {
+	s.negativeHits.Add(1)
+}
+
 // snapshot returns a snapshot of current statistics
 func (s *shardStats) snapshot() Stats {
 	return Stats{
@@ -84,5 +179,21 @@ func (s *shardStats) snapshot() Stats {
 		SimilarHits:     s.similarHits.Load(),
 		Evictions:       s.evictions.Load(),
 		Expired:         s.expired.Load(),
+		NegativeHits:    s.negativeHits.Load(),
+		Elapsed:         time.Since(s.started),
+	}
+}
+
+// histograms returns a snapshot of the histograms, which are zero unless the
+// tracker was created with newHistogramStats
+func (s *shardStats) histograms() Histograms {
+	if s.getLatency == nil {
+		return Histograms{}
+	}
+	return Histograms{
+		GetLatency:     s.getLatency.snapshot(),
+		SimilarLatency: s.similarLatency.snapshot(),
+		SimilarScores:  s.similarScores.snapshot(),
+		NearMissScores: s.nearMissScores.snapshot(),
 	}
 }
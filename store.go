@@ -0,0 +1,21 @@
+package synapse
+
+import (
+	"context"
+)
+
+// Store is the common interface of caches. It is implemented by Cache, by
+// the remote client and by the wrappers of the decorators package, so that
+// code can be written against any of them and tested with a fake.
+type Store[K comparable, V any] interface {
+	// Get retrieves a value by exact key match
+	Get(ctx context.Context, key K) (V, bool)
+	// Set stores a value
+	Set(ctx context.Context, key K, value V, opts ...SetOption) error
+	// GetSimilar finds the most similar key above the threshold
+	GetSimilar(ctx context.Context, key K, opts ...SimilarOption) (V, K, float64, bool)
+	// Delete removes a key
+	Delete(ctx context.Context, key K) bool
+	// Len returns the number of entries
+	Len() int
+}
@@ -2,21 +2,36 @@ package synapse
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"hash/fnv"
+	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/kolosys/synapse/eviction"
 )
 
+// ErrStale is returned by Set with IfNewer when the stored entry is at least
+// as recent as the write
+var ErrStale = errors.New("synapse: write is not newer than the stored entry")
+
 // EvictionPolicy is re-exported from the eviction package
 type EvictionPolicy = eviction.EvictionPolicy
 
 // Cache is a generic similarity-based cache with sharding
 type Cache[K comparable, V any] struct {
 	shards     []*Shard[K, V]
+	events     *eventBus[K, V]
 	similarity SimilarityFunc[K]
 	threshold  float64
 	options    *Options
+	backing    *backing[K, V] // nil without a backend
+	tuner      *tuner         // nil without threshold tuning
+	hash       Hasher[K]
+
+	// searches tracks similarity searches across all shards, counting each
+	// call once; nil without stats
+	searches *shardStats
 }
 
 // New creates a new cache with the given options
@@ -28,14 +43,33 @@ func New[K comparable, V any](opts ...Option) *Cache[K, V] {
 
 	c := &Cache[K, V]{
 		shards:    make([]*Shard[K, V], options.NumShards),
+		events:    newEventBus[K, V](),
 		threshold: options.SimilarityThreshold,
 		options:   options,
+		hash:      newHasher[K](options),
 	}
 
 	// Initialize shards
-	maxSizePerShard := options.MaxSize / options.NumShards
-	if maxSizePerShard == 0 {
-		maxSizePerShard = 1
+	maxSizePerShard := perShard(options.MaxSize, options.NumShards)
+
+	// Quotas are enforced per shard, so a quota needs at least one entry in
+	// every shard to mean what it says
+	var quotas map[string]int
+	if len(options.NamespaceQuotas) > 0 {
+		quotas = make(map[string]int, len(options.NamespaceQuotas))
+		for namespace, quota := range options.NamespaceQuotas {
+			if quota < options.NumShards {
+				panic(fmt.Sprintf("synapse: quota %d of namespace %q is smaller than the %d shards", quota, namespace, options.NumShards))
+			}
+			quotas[namespace] = perShard(quota, options.NumShards)
+		}
+	}
+	var defaultQuota int
+	if options.DefaultNamespaceQuota > 0 {
+		if options.DefaultNamespaceQuota < options.NumShards {
+			panic(fmt.Sprintf("synapse: default namespace quota %d is smaller than the %d shards", options.DefaultNamespaceQuota, options.NumShards))
+		}
+		defaultQuota = perShard(options.DefaultNamespaceQuota, options.NumShards)
 	}
 
 	for i := 0; i < options.NumShards; i++ {
@@ -47,16 +81,46 @@ func New[K comparable, V any](opts ...Option) *Cache[K, V] {
 		c.shards[i] = newShard[K, V](
 			maxSizePerShard,
 			c.similarity,
-			c.threshold,
 			options.TTL,
 			policy,
 			options.EnableStats,
 		)
+		c.shards[i].quotas = quotas
+		c.shards[i].defaultQuota = defaultQuota
+		c.shards[i].negativeTTL = options.NegativeTTL
+		c.shards[i].events = c.events
+		c.shards[i].index = i
+		c.shards[i].logger = options.Logger
+	}
+
+	if options.TargetPrecision > 0 {
+		c.tuner = newTuner(options)
+	}
+
+	if options.EnableStats {
+		c.searches = newHistogramStats()
+	}
+
+	if options.Backend != nil {
+		c.backing = newBacking[K, V](options)
+		for _, shard := range c.shards {
+			shard.grace = options.StaleWhileRevalidate
+		}
 	}
 
 	return c
 }
 
+// perShard divides a cache-wide limit evenly across shards, allowing at least
+// one entry per shard
+func perShard(limit, numShards int) int {
+	n := limit / numShards
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
 // WithSimilarity sets the similarity function for the cache
 func (c *Cache[K, V]) WithSimilarity(fn SimilarityFunc[K]) *Cache[K, V] {
 	c.similarity = fn
@@ -66,64 +130,409 @@ func (c *Cache[K, V]) WithSimilarity(fn SimilarityFunc[K]) *Cache[K, V] {
 	return c
 }
 
+// WithEvictionCallback sets a callback that is invoked whenever an entry is
+// removed from the cache by eviction, expiration, deletion or invalidation
+func (c *Cache[K, V]) WithEvictionCallback(fn EvictionCallback[K, V]) *Cache[K, V] {
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.onEvict = fn
+		shard.mu.Unlock()
+	}
+	return c
+}
+
 // getShard returns the shard for a given key
 func (c *Cache[K, V]) getShard(key K) *Shard[K, V] {
-	h := fnv.New64a()
-	// Use string representation of key for hashing
-	// This is a simple approach; for production, you might want a more sophisticated method
-	h.Write([]byte(keyToString(key)))
-	hash := h.Sum64()
-	return c.shards[int(hash%uint64(len(c.shards)))]
+	return c.shards[c.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard for a given key
+func (c *Cache[K, V]) shardIndex(key K) int {
+	return int(c.hash(key) % uint64(len(c.shards)))
 }
 
-// Get retrieves a value by exact key match
+// Get retrieves a value by exact key match. With WithReadThrough, a missing
+// key is loaded from the backend and cached. With WithRefreshAhead or
+// WithStaleWhileRevalidate, entries close to or past their expiry are
+// reloaded in the background.
 func (c *Cache[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	v, status := c.Lookup(ctx, key)
+	return v, status == LookupHit || status == LookupLoaded
+}
+
+// Lookup is Get reporting whether the value was cached, loaded from the
+// backend, negatively cached or missing. With WithNegativeTTL, a key the
+// backend does not have is remembered as absent, so later lookups return
+// LookupNegative without loading it again.
+func (c *Cache[K, V]) Lookup(ctx context.Context, key K) (V, LookupStatus) {
+	tracer := c.options.Tracer
+	if tracer == nil {
+		return c.lookup(ctx, key)
+	}
+
+	ctx, span := tracer.Start(ctx, "synapse.Get")
+	defer span.End()
+
+	v, status := c.lookup(ctx, key)
+	span.SetAttributes(
+		Attribute{AttrShard, int64(c.shardIndex(key))},
+		Attribute{AttrNamespace, GetNamespace(ctx)},
+		Attribute{AttrHit, status == LookupHit || status == LookupLoaded},
+		Attribute{AttrLookupStatus, status.String()},
+	)
+	return v, status
+}
+
+// lookup implements Lookup
+func (c *Cache[K, V]) lookup(ctx context.Context, key K) (V, LookupStatus) {
 	shard := c.getShard(key)
-	return shard.get(ctx, key)
+	entry, status := shard.lookup(ctx, key)
+	switch status {
+	case LookupHit:
+		if c.backing != nil {
+			c.maybeRefresh(ctx, shard, entry)
+		}
+		return entry.Value, LookupHit
+	case LookupNegative:
+		return entry.Value, LookupNegative
+	}
+	if c.backing == nil || !c.options.ReadThrough {
+		return entry.Value, LookupMiss
+	}
+
+	v, ok, err := c.backing.fetch(ctx, key)
+	if err != nil {
+		// A failed load says nothing about the key, so it is not cached
+		c.backing.report(err)
+		return v, LookupMiss
+	}
+	if !ok {
+		shard.setAbsent(ctx, key)
+		return v, LookupMiss
+	}
+	if err := shard.set(ctx, key, v, NewSetOptions(ctx)); err != nil {
+		c.backing.report(err)
+	}
+	return v, LookupLoaded
+}
+
+// GetEntry retrieves a copy of the entry for an exact key match, including
+// its metadata, timestamps, access count and namespace. Modifying the
+// returned entry has no effect on the cache.
+func (c *Cache[K, V]) GetEntry(ctx context.Context, key K) (Entry[K, V], bool) {
+	shard := c.getShard(key)
+	entry, ok := shard.getEntry(ctx, key)
+	if !ok {
+		return Entry[K, V]{}, false
+	}
+	return entry.clone(), true
 }
 
-// Set stores a value
-func (c *Cache[K, V]) Set(ctx context.Context, key K, value V) error {
+// Peek retrieves a copy of the live entry for an exact key match without
+// updating its access time, the eviction policy or statistics
+func (c *Cache[K, V]) Peek(ctx context.Context, key K) (Entry[K, V], bool) {
 	shard := c.getShard(key)
-	return shard.set(ctx, key, value)
+	entry, ok := shard.peek(ctx, key)
+	if !ok {
+		return Entry[K, V]{}, false
+	}
+	return entry.clone(), true
+}
+
+// Set stores a value. Metadata attached to ctx with WithMetadata is stored
+// on the entry, merged with any metadata given through opts. With
+// WithWriteThrough, the value is only cached once the backend has stored it;
+// with WithWriteBehind, the write is queued for the backend.
+func (c *Cache[K, V]) Set(ctx context.Context, key K, value V, opts ...SetOption) error {
+	tracer := c.options.Tracer
+	if tracer == nil {
+		return c.set(ctx, key, value, opts)
+	}
+
+	ctx, span := tracer.Start(ctx, "synapse.Set")
+	defer span.End()
+
+	err := c.set(ctx, key, value, opts)
+	span.SetAttributes(
+		Attribute{AttrShard, int64(c.shardIndex(key))},
+		Attribute{AttrNamespace, GetNamespace(ctx)},
+	)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
 }
 
-// GetSimilar finds the most similar key above the threshold
-func (c *Cache[K, V]) GetSimilar(ctx context.Context, key K) (V, K, float64, bool) {
+// set implements Set
+func (c *Cache[K, V]) set(ctx context.Context, key K, value V, opts []SetOption) error {
+	if c.backing != nil && c.options.WriteMode == WriteThrough {
+		if err := c.backing.store(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	shard := c.getShard(key)
+	if err := shard.set(ctx, key, value, NewSetOptions(ctx, opts...)); err != nil {
+		return err
+	}
+
+	if c.backing != nil && c.options.WriteMode == WriteBehind {
+		return c.backing.store(ctx, key, value)
+	}
+	return nil
+}
+
+// GetSimilar finds the most similar key above the threshold within the
+// context's namespace. Candidates whose metadata is rejected by opts are
+// skipped before their similarity is computed.
+func (c *Cache[K, V]) GetSimilar(ctx context.Context, key K, opts ...SimilarOption) (V, K, float64, bool) {
+	entry, score, ok := c.getSimilar(ctx, key, NewSimilarOptions(opts...))
+	return entry.Value, entry.Key, score, ok
+}
+
+// GetSimilarEntry finds the most similar key above the threshold within the
+// context's namespace and returns a copy of its entry along with the score.
+// Modifying the returned entry has no effect on the cache.
+func (c *Cache[K, V]) GetSimilarEntry(ctx context.Context, key K, opts ...SimilarOption) (Entry[K, V], float64, bool) {
+	entry, score, ok := c.getSimilar(ctx, key, NewSimilarOptions(opts...))
+	if !ok {
+		return Entry[K, V]{}, 0, false
+	}
+	return entry.clone(), score, true
+}
+
+// getSimilar searches every shard for the most similar entry
+func (c *Cache[K, V]) getSimilar(ctx context.Context, key K, opts *SimilarOptions) (Entry[K, V], float64, bool) {
+	tracer := c.options.Tracer
+	if tracer == nil {
+		result := c.search(ctx, key, opts, false)
+		return result.entry, result.score, result.found
+	}
+
+	ctx, span := tracer.Start(ctx, "synapse.GetSimilar")
+	defer span.End()
+
+	result := c.search(ctx, key, opts, true)
+	span.SetAttributes(
+		Attribute{AttrNamespace, GetNamespace(ctx)},
+		Attribute{AttrHit, result.found},
+		Attribute{AttrThreshold, c.Threshold(ctx)},
+		Attribute{AttrScore, result.score},
+		Attribute{AttrCandidates, int64(result.candidates)},
+		Attribute{AttrSimilarityTime, result.similarityTime.Nanoseconds()},
+	)
+	return result.entry, result.score, result.found
+}
+
+// search searches every shard for the most similar entry. The result holds
+// the best score below the threshold as nearMiss when nothing was found,
+// and the candidates and similarity time of all shards.
+func (c *Cache[K, V]) search(ctx context.Context, key K, opts *SimilarOptions, timed bool) similarResult[K, V] {
+	if c.searches != nil {
+		c.searches.recordSimilarSearch()
+		defer c.searches.similarLatency.observeSince(time.Now())
+	}
+
 	// For similarity search, we need to search across all shards
 	// In a production implementation, you might want to use LSH or other indexing
 
-	var bestValue V
-	var bestKey K
-	bestScore := 0.0
-	found := false
+	var best similarResult[K, V]
+	threshold := c.Threshold(ctx)
 
-	for _, shard := range c.shards {
-		v, k, score, ok := shard.getSimilar(ctx, key)
-		if ok && score > bestScore {
-			bestValue = v
-			bestKey = k
-			bestScore = score
-			found = true
+	// Slow searches are logged along with the shard that took longest
+	logSlow := c.options.Logger != nil && c.options.SlowSimilarity > 0
+	var start time.Time
+	var slowest int
+	var slowestTime time.Duration
+	if logSlow {
+		start = time.Now()
+	}
+
+	for i, shard := range c.shards {
+		var shardStart time.Time
+		if logSlow {
+			shardStart = time.Now()
+		}
+		result := shard.getSimilar(ctx, key, threshold, opts, timed)
+		if logSlow {
+			if d := time.Since(shardStart); d > slowestTime {
+				slowest, slowestTime = i, d
+			}
+		}
+		if result.found && result.score > best.score {
+			best.entry = result.entry
+			best.score = result.score
+			best.found = true
 		}
+		if result.candidates > 0 && (best.candidates == 0 || result.nearMiss > best.nearMiss) {
+			best.nearMiss = result.nearMiss
+		}
+		best.candidates += result.candidates
+		best.similarityTime += result.similarityTime
 
 		// Check for context cancellation
 		select {
 		case <-ctx.Done():
-			var zeroV V
-			var zeroK K
-			return zeroV, zeroK, 0, false
+			return similarResult[K, V]{}
 		default:
 		}
 	}
 
-	return bestValue, bestKey, bestScore, found
+	if !best.found && best.candidates > 0 && c.searches != nil {
+		c.searches.nearMissScores.observe(best.nearMiss)
+	}
+
+	if logSlow {
+		if elapsed := time.Since(start); elapsed >= c.options.SlowSimilarity {
+			c.options.Logger.LogAttrs(ctx, slog.LevelWarn, "slow similarity search",
+				slog.String("namespace", GetNamespace(ctx)),
+				KeyHashAttr(key),
+				slog.Duration("duration", elapsed),
+				slog.Int("candidates", best.candidates),
+				slog.Bool("hit", best.found),
+				slog.Float64("threshold", threshold),
+				slog.Int("slowest_shard", slowest),
+				slog.Duration("slowest_shard_duration", slowestTime),
+			)
+		}
+	}
+
+	if best.found {
+		if c.searches != nil {
+			c.searches.recordSimilarHit()
+			c.searches.similarScores.observe(best.score)
+		}
+		c.events.publish(Event[K, V]{
+			Type:   EventSimilarHit,
+			Entry:  best.entry,
+			Query:  key,
+			Score:  best.score,
+			Time:   time.Now(),
+			Origin: GetOrigin(ctx),
+		})
+	}
+
+	return best
+}
+
+// SimilarMatch is an entry found by a similarity search along with its score
+type SimilarMatch[K comparable, V any] struct {
+	Entry Entry[K, V]
+	Score float64
+}
+
+// TopSimilar returns up to k entries of the context's namespace whose keys
+// score at least the threshold against key, best first. Candidates whose
+// metadata is rejected by opts are skipped. Unlike GetSimilar, it does not
+// update access times or the eviction policy. Modifying the returned entries
+// has no effect on the cache.
+func (c *Cache[K, V]) TopSimilar(ctx context.Context, key K, k int, opts ...SimilarOption) []SimilarMatch[K, V] {
+	if k <= 0 {
+		return nil
+	}
+	options := NewSimilarOptions(opts...)
+
+	if c.searches != nil {
+		c.searches.recordSimilarSearch()
+	}
+
+	threshold := c.Threshold(ctx)
+
+	var matches []SimilarMatch[K, V]
+	for _, shard := range c.shards {
+		for _, m := range shard.topSimilar(ctx, key, k, threshold, options) {
+			matches = insertMatch(matches, m, k)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	if len(matches) > 0 && c.searches != nil {
+		c.searches.recordSimilarHit()
+	}
+
+	for i := range matches {
+		matches[i].Entry = matches[i].Entry.clone()
+	}
+	return matches
+}
+
+// insertMatch inserts m into matches, kept sorted by descending score and
+// capped at k entries
+func insertMatch[K comparable, V any](matches []SimilarMatch[K, V], m SimilarMatch[K, V], k int) []SimilarMatch[K, V] {
+	if len(matches) == k && m.Score <= matches[k-1].Score {
+		return matches
+	}
+
+	i, _ := slices.BinarySearchFunc(matches, m.Score, func(e SimilarMatch[K, V], score float64) int {
+		// Equal scores keep insertion order
+		if e.Score >= score {
+			return -1
+		}
+		return 1
+	})
+	matches = slices.Insert(matches, i, m)
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
 }
 
-// Delete removes a key from the cache
+// Delete removes a key from the context's namespace. With WithWriteThrough
+// or WithWriteBehind the key is also removed from the backend; backend
+// errors are passed to the backend error handler.
 func (c *Cache[K, V]) Delete(ctx context.Context, key K) bool {
+	tracer := c.options.Tracer
+	if tracer == nil {
+		return c.delete(ctx, key)
+	}
+
+	ctx, span := tracer.Start(ctx, "synapse.Delete")
+	defer span.End()
+
+	deleted := c.delete(ctx, key)
+	span.SetAttributes(
+		Attribute{AttrShard, int64(c.shardIndex(key))},
+		Attribute{AttrNamespace, GetNamespace(ctx)},
+		Attribute{AttrHit, deleted},
+	)
+	return deleted
+}
+
+// delete implements Delete
+func (c *Cache[K, V]) delete(ctx context.Context, key K) bool {
+	shard := c.getShard(key)
+	deleted := shard.delete(ctx, key)
+
+	if c.backing != nil && ctx.Err() == nil {
+		if err := c.backing.delete(ctx, key); err != nil {
+			c.backing.report(err)
+		}
+	}
+	return deleted
+}
+
+// DeleteIfOlder removes a key of the context's namespace unless its entry
+// was updated at or after t, and reports whether it was removed. Like IfNewer
+// for Set, it lets replayed deletes lose against newer local writes.
+func (c *Cache[K, V]) DeleteIfOlder(ctx context.Context, key K, t time.Time) bool {
 	shard := c.getShard(key)
-	return shard.delete(ctx, key)
+	deleted := shard.deleteOlder(ctx, key, t)
+
+	if deleted && c.backing != nil && ctx.Err() == nil {
+		if err := c.backing.delete(ctx, key); err != nil {
+			c.backing.report(err)
+		}
+	}
+	return deleted
+}
+
+// Expire makes a key of the context's namespace expire ttl from now, or
+// never if ttl is zero or negative. It reports whether the key was found.
+func (c *Cache[K, V]) Expire(ctx context.Context, key K, ttl time.Duration) bool {
+	shard := c.getShard(key)
+	return shard.expire(ctx, key, ttl)
 }
 
 // Len returns the total number of entries in the cache
@@ -135,6 +544,15 @@ func (c *Cache[K, V]) Len() int {
 	return total
 }
 
+// Cap returns the maximum number of entries across all shards
+func (c *Cache[K, V]) Cap() int {
+	total := 0
+	for _, shard := range c.shards {
+		total += shard.maxSize
+	}
+	return total
+}
+
 // Stats returns aggregated statistics from all shards
 // Returns zero values if stats are not enabled
 func (c *Cache[K, V]) Stats() Stats {
@@ -145,23 +563,53 @@ func (c *Cache[K, V]) Stats() Stats {
 	var stats Stats
 	for _, shard := range c.shards {
 		if shard.stats != nil {
-			shardStats := shard.stats.snapshot()
-			stats.Hits += shardStats.Hits
-			stats.Misses += shardStats.Misses
-			stats.Sets += shardStats.Sets
-			stats.Deletes += shardStats.Deletes
-			stats.SimilarSearches += shardStats.SimilarSearches
-			stats.SimilarHits += shardStats.SimilarHits
-			stats.Evictions += shardStats.Evictions
-			stats.Expired += shardStats.Expired
+			stats.add(shard.stats.snapshot())
 		}
 	}
+	// A search spans every shard, so it is tracked once for the whole cache
+	searches := c.searches.snapshot()
+	stats.SimilarSearches = searches.SimilarSearches
+	stats.SimilarHits = searches.SimilarHits
 	return stats
 }
 
-// keyToString converts a key to a string for hashing
-// This is a simple implementation; for production use, consider a more robust approach
-func keyToString[K comparable](key K) string {
-	// Use fmt.Sprintf for a simple string representation
-	return fmt.Sprintf("%v", key)
+// Histograms returns the latency and score distributions of the cache.
+// Returns zero values if stats are not enabled.
+func (c *Cache[K, V]) Histograms() Histograms {
+	if !c.options.EnableStats {
+		return Histograms{}
+	}
+
+	var histograms Histograms
+	for _, shard := range c.shards {
+		if shard.stats != nil {
+			histograms.GetLatency.add(shard.stats.getLatency.snapshot())
+		}
+	}
+	// Searches span every shard, so they are tracked for the whole cache
+	searches := c.searches.histograms()
+	histograms.SimilarLatency = searches.SimilarLatency
+	histograms.SimilarScores = searches.SimilarScores
+	histograms.NearMissScores = searches.NearMissScores
+	return histograms
+}
+
+// ShardInfo describes the size, capacity and statistics of a single shard
+type ShardInfo struct {
+	Index    int
+	Len      int
+	Capacity int
+	// Stats and Histograms are zero unless stats are enabled
+	Stats      Stats
+	Histograms Histograms
+}
+
+// Shards returns the size, capacity and statistics of every shard, to find
+// hotspots that the aggregate Stats hide
+func (c *Cache[K, V]) Shards() []ShardInfo {
+	infos := make([]ShardInfo, len(c.shards))
+	for i, shard := range c.shards {
+		infos[i] = shard.info(i)
+	}
+	return infos
 }
@@ -1,8 +1,16 @@
 package synapse
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -177,6 +185,119 @@ func TestCacheWithMaxSize(t *testing.T) {
 	}
 }
 
+// unscopedPolicy hides the ScopedPolicy methods of the policy it wraps
+type unscopedPolicy struct {
+	eviction.EvictionPolicy
+}
+
+func TestCacheSharedUnscopedPolicy(t *testing.T) {
+	policy := eviction.NewLRU(40)
+	cache := New[int, int](
+		WithShards(4),
+		WithMaxSize(40),
+		WithEviction(unscopedPolicy{policy}),
+	)
+	ctx := context.Background()
+
+	// The policy's victim is often held by another shard than the full one
+	for i := 0; i < 400; i++ {
+		cache.Set(ctx, i, i)
+	}
+
+	for _, info := range cache.Shards() {
+		if info.Len > info.Capacity {
+			t.Fatalf("Shard %d holds %d entries, more than its capacity %d", info.Index, info.Len, info.Capacity)
+		}
+	}
+	// Every cached key is still tracked by the policy, so it can be evicted
+	if policy.Len() != cache.Len() {
+		t.Fatalf("Expected the policy to track all %d keys, got %d", cache.Len(), policy.Len())
+	}
+}
+
+func TestCacheEvictionFullShardOldestElsewhere(t *testing.T) {
+	policy := eviction.NewLRU(20)
+	cache := New[int, int](
+		WithShards(2),
+		WithMaxSize(20),
+		WithEviction(policy),
+		WithHasher(Hasher[int](func(key int) uint64 { return uint64(key) })),
+	)
+	ctx := context.Background()
+
+	// The least recently used keys are the odd keys of shard 1; shard 0
+	// then fills up with even keys
+	for i := 1; i < 20; i += 2 {
+		cache.Set(ctx, i, i)
+	}
+	for i := 0; i < 20; i += 2 {
+		cache.Set(ctx, i, i)
+	}
+	cache.Get(ctx, 0)
+
+	cache.Set(ctx, 20, 20)
+
+	// Shard 0 evicts its own least recently used key
+	if _, ok := cache.Get(ctx, 2); ok {
+		t.Fatal("Expected key 2 to be evicted")
+	}
+	for i := 1; i < 20; i += 2 {
+		if _, ok := cache.Get(ctx, i); !ok {
+			t.Fatalf("Expected key %d of the other shard to be kept", i)
+		}
+	}
+	if n := cache.Len(); n != 20 || policy.Len() != 20 {
+		t.Fatalf("Expected 20 entries tracked by the policy, got %d and %d", n, policy.Len())
+	}
+}
+
+func TestCacheEvictionWithoutExpiredKeys(t *testing.T) {
+	policy := eviction.NewTTL(time.Hour)
+	defer policy.Close()
+	cache := New[int, int](
+		WithShards(1),
+		WithMaxSize(5),
+		WithNamespaceQuota("small", 2),
+		WithEviction(policy),
+	)
+	small := WithNamespace(context.Background(), "small")
+
+	// No key has expired, yet the shard and the quota still make room
+	for i := 0; i < 10; i++ {
+		cache.Set(context.Background(), i, i)
+		cache.Set(small, i, i)
+	}
+	if n := cache.NamespaceLen("small"); n != 2 {
+		t.Fatalf("Expected the quota to hold 2 entries, got %d", n)
+	}
+	if n := cache.Len(); n != 5 {
+		t.Fatalf("Expected 5 entries, got %d", n)
+	}
+}
+
+// keyRecorder is an eviction policy recording the keys it is given
+type keyRecorder struct {
+	eviction.EvictionPolicy
+	added []any
+}
+
+func (r *keyRecorder) OnAdd(key any, accessCount uint64, createdAt, accessedAt time.Time) {
+	r.added = append(r.added, key)
+	r.EvictionPolicy.OnAdd(key, accessCount, createdAt, accessedAt)
+}
+
+func TestCacheEvictionKeys(t *testing.T) {
+	policy := &keyRecorder{EvictionPolicy: eviction.NewLRU(10)}
+	cache := New[string, int](WithShards(1), WithEviction(policy))
+
+	cache.Set(WithNamespace(context.Background(), "tenant"), "key", 1)
+
+	want := []any{EvictionKey[string]{Namespace: "tenant", Key: "key"}}
+	if !slices.Equal(policy.added, want) {
+		t.Fatalf("Expected policy keys %v, got %v", want, policy.added)
+	}
+}
+
 func TestCacheWithMetadata(t *testing.T) {
 	cache := New[string, string]()
 
@@ -351,3 +472,1941 @@ func TestCacheStatsEviction(t *testing.T) {
 		t.Fatal("Expected at least 1 eviction")
 	}
 }
+
+func TestCacheRange(t *testing.T) {
+	cache := New[string, int]()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		cache.Set(ctx, fmt.Sprintf("key%d", i), i)
+	}
+
+	seen := make(map[string]int)
+	cache.Range(ctx, func(e Entry[string, int]) bool {
+		seen[e.Key] = e.Value
+		return true
+	})
+	if len(seen) != 10 {
+		t.Fatalf("Expected 10 entries, got %d", len(seen))
+	}
+
+	// Early termination
+	visited := 0
+	cache.Range(ctx, func(e Entry[string, int]) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("Expected Range to stop after 3 entries, got %d", visited)
+	}
+
+	// Mutating the entry copy must not affect the cache
+	cache.Range(ctx, func(e Entry[string, int]) bool {
+		e.Value = -1
+		e.Metadata["mutated"] = true
+		return true
+	})
+	if val, _ := cache.Get(ctx, "key1"); val != 1 {
+		t.Fatalf("Expected 1, got %d", val)
+	}
+}
+
+func TestCacheKeysAndAll(t *testing.T) {
+	cache := New[string, string](
+		WithTTL(50 * time.Millisecond),
+	)
+
+	ctx1 := WithNamespace(context.Background(), "ns1")
+	ctx2 := WithNamespace(context.Background(), "ns2")
+
+	cache.Set(ctx1, "a", "1")
+	cache.Set(ctx1, "b", "2")
+	cache.Set(ctx2, "c", "3")
+
+	if keys := cache.Keys(ctx1); len(keys) != 2 {
+		t.Fatalf("Expected 2 keys in ns1, got %v", keys)
+	}
+
+	all := make(map[string]string)
+	for k, v := range cache.All(ctx2) {
+		all[k] = v
+	}
+	if len(all) != 1 || all["c"] != "3" {
+		t.Fatalf("Expected only c=3 in ns2, got %v", all)
+	}
+
+	// Expired entries are skipped
+	time.Sleep(100 * time.Millisecond)
+	if keys := cache.Keys(ctx1); len(keys) != 0 {
+		t.Fatalf("Expected no live keys, got %v", keys)
+	}
+}
+
+func TestCacheBulkOperations(t *testing.T) {
+	cache := New[string, int](
+		WithShards(4),
+	)
+	ctx := context.Background()
+
+	items := make([]Item[string, int], 20)
+	for i := range items {
+		items[i] = Item[string, int]{Key: fmt.Sprintf("key%d", i), Value: i}
+	}
+	for i, err := range cache.SetMany(ctx, items) {
+		if err != nil {
+			t.Fatalf("SetMany failed for item %d: %v", i, err)
+		}
+	}
+	if cache.Len() != 20 {
+		t.Fatalf("Expected 20 entries, got %d", cache.Len())
+	}
+
+	results := cache.GetMany(ctx, []string{"key3", "missing", "key7"})
+	if !results[0].Found || results[0].Value != 3 {
+		t.Fatalf("Expected key3=3, got %+v", results[0])
+	}
+	if results[1].Found {
+		t.Fatal("Expected missing key to be reported as not found")
+	}
+	if !results[2].Found || results[2].Value != 7 {
+		t.Fatalf("Expected key7=7, got %+v", results[2])
+	}
+
+	deleted := cache.DeleteMany(ctx, []string{"key3", "key3", "missing"})
+	if !deleted[0].Found || deleted[0].Value != 3 {
+		t.Fatalf("Expected key3 to be deleted, got %+v", deleted[0])
+	}
+	if deleted[1].Found || deleted[2].Found {
+		t.Fatal("Expected duplicate and missing keys to report not found")
+	}
+	if cache.Len() != 19 {
+		t.Fatalf("Expected 19 entries, got %d", cache.Len())
+	}
+}
+
+func TestCacheBulkCancellation(t *testing.T) {
+	cache := New[string, int]()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := cache.SetMany(ctx, []Item[string, int]{{Key: "a", Value: 1}, {Key: "b", Value: 2}})
+	for _, err := range errs {
+		if err != context.Canceled {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	}
+
+	for _, r := range cache.GetMany(ctx, []string{"a"}) {
+		if r.Err != context.Canceled || r.Found {
+			t.Fatalf("Expected cancelled result, got %+v", r)
+		}
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	cache := New[string, int](
+		WithStats(true),
+	)
+	ctx := context.Background()
+
+	var evicted []string
+	cache.WithEvictionCallback(func(key string, value int, reason EvictionReason) {
+		if reason != EvictionReasonCleared {
+			t.Errorf("Expected cleared reason, got %s", reason)
+		}
+		evicted = append(evicted, key)
+	})
+
+	for i := 0; i < 10; i++ {
+		cache.Set(ctx, fmt.Sprintf("key%d", i), i)
+	}
+
+	if n := cache.Clear(ctx); n != 10 {
+		t.Fatalf("Expected 10 entries cleared, got %d", n)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Expected empty cache, got %d", cache.Len())
+	}
+	if len(evicted) != 10 {
+		t.Fatalf("Expected 10 eviction callbacks, got %d", len(evicted))
+	}
+	if stats := cache.Stats(); stats.Deletes != 10 {
+		t.Fatalf("Expected 10 deletes, got %d", stats.Deletes)
+	}
+}
+
+func TestCachePurgeNamespace(t *testing.T) {
+	policy := eviction.NewLRU(100)
+	cache := New[string, string](
+		WithEviction(policy),
+	)
+
+	ctx1 := WithNamespace(context.Background(), "tenant1")
+	ctx2 := WithNamespace(context.Background(), "tenant2")
+
+	cache.Set(ctx1, "a", "1")
+	cache.Set(ctx1, "b", "2")
+	cache.Set(ctx2, "c", "3")
+
+	if n := cache.PurgeNamespace(context.Background(), "tenant1"); n != 2 {
+		t.Fatalf("Expected 2 entries purged, got %d", n)
+	}
+	if _, ok := cache.Get(ctx1, "a"); ok {
+		t.Fatal("tenant1 entries should be purged")
+	}
+	if _, ok := cache.Get(ctx2, "c"); !ok {
+		t.Fatal("tenant2 entries should be kept")
+	}
+	if policy.Len() != 1 {
+		t.Fatalf("Expected eviction policy to track 1 key, got %d", policy.Len())
+	}
+}
+
+func TestCacheDeleteFunc(t *testing.T) {
+	cache := New[int, int]()
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		cache.Set(ctx, i, i)
+	}
+
+	n := cache.DeleteFunc(ctx, func(e Entry[int, int]) bool {
+		return e.Value%2 == 0
+	})
+	if n != 10 {
+		t.Fatalf("Expected 10 entries deleted, got %d", n)
+	}
+	for i := 0; i < 20; i++ {
+		_, ok := cache.Get(ctx, i)
+		if ok != (i%2 == 1) {
+			t.Fatalf("Unexpected presence %v for key %d", ok, i)
+		}
+	}
+}
+
+func TestCacheEvictionCallback(t *testing.T) {
+	cache := New[string, string](
+		WithMaxSize(1),
+		WithShards(1),
+	)
+	ctx := context.Background()
+
+	reasons := make(map[string]EvictionReason)
+	cache.WithEvictionCallback(func(key string, value string, reason EvictionReason) {
+		// Callbacks run without the shard lock held
+		cache.Len()
+		reasons[key] = reason
+	})
+
+	cache.Set(ctx, "a", "1")
+	cache.Set(ctx, "b", "2") // Evicts a
+	cache.Delete(ctx, "b")
+
+	if reasons["a"] != EvictionReasonCapacity {
+		t.Fatalf("Expected a to be evicted for capacity, got %s", reasons["a"])
+	}
+	if reasons["b"] != EvictionReasonDeleted {
+		t.Fatalf("Expected b to be deleted, got %s", reasons["b"])
+	}
+}
+
+func TestCacheEvictionCallbackReentry(t *testing.T) {
+	cache := New[string, string](WithShards(1))
+	ctx := context.Background()
+
+	var order []string
+	cache.WithEvictionCallback(func(key string, value string, reason EvictionReason) {
+		order = append(order, key)
+		// Writing to the same shard from the callback must not deadlock
+		switch key {
+		case "a":
+			cache.Set(ctx, "b", "2")
+			cache.Delete(ctx, "b")
+		case "b":
+			cache.Delete(ctx, "c")
+		}
+	})
+	cache.Set(ctx, "a", "1")
+	cache.Set(ctx, "c", "3")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Delete(ctx, "a")
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Delete deadlocked in a re-entrant eviction callback")
+	}
+
+	// Events caused by a callback are delivered after the callback returns
+	if strings.Join(order, ",") != "a,b,c" {
+		t.Fatalf("Expected removals a,b,c, got %v", order)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Expected an empty cache, got %d entries", cache.Len())
+	}
+}
+
+func TestCacheNamespacePartitioning(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.5),
+		WithStats(true),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+
+	ctxA := WithNamespace(context.Background(), "tenantA")
+	ctxB := WithNamespace(context.Background(), "tenantB")
+
+	// The same key is stored independently in each namespace
+	cache.Set(ctxA, "config", "a")
+	cache.Set(ctxB, "config", "b")
+
+	if val, ok := cache.Get(ctxA, "config"); !ok || val != "a" {
+		t.Fatalf("Expected a, got %s", val)
+	}
+	if val, ok := cache.Get(ctxB, "config"); !ok || val != "b" {
+		t.Fatalf("Expected b, got %s", val)
+	}
+
+	// The default namespace does not see namespaced entries
+	if _, ok := cache.Get(context.Background(), "config"); ok {
+		t.Fatal("Default namespace should not see tenant entries")
+	}
+
+	// Deleting in one namespace leaves the other untouched
+	cache.Delete(ctxA, "config")
+	if _, ok := cache.Get(ctxB, "config"); !ok {
+		t.Fatal("Delete in tenantA should not affect tenantB")
+	}
+
+	// Similarity search only considers the caller's namespace
+	cache.Set(ctxB, "configs", "b2")
+	if _, _, _, ok := cache.GetSimilar(ctxA, "configs!"); ok {
+		t.Fatal("GetSimilar should not match entries of another namespace")
+	}
+	if _, key, _, ok := cache.GetSimilar(ctxB, "configs!"); !ok || key != "configs" {
+		t.Fatalf("Expected similar match configs in tenantB, got %q", key)
+	}
+
+	if n := cache.NamespaceLen("tenantB"); n != 2 {
+		t.Fatalf("Expected 2 entries in tenantB, got %d", n)
+	}
+	if names := cache.Namespaces(); len(names) != 1 || names[0] != "tenantB" {
+		t.Fatalf("Expected only tenantB, got %v", names)
+	}
+
+	stats := cache.NamespaceStats("tenantB")
+	if stats.Sets != 2 || stats.Hits != 2 || stats.SimilarHits == 0 {
+		t.Fatalf("Unexpected tenantB stats: %+v", stats)
+	}
+
+	// Expired entries count towards NamespaceLen until they are swept
+	cache.Set(ctxB, "short", "b3", WithEntryTTL(time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	if n, live := cache.NamespaceLen("tenantB"), cache.NamespaceLiveLen("tenantB"); n != 3 || live != 2 {
+		t.Fatalf("Expected 3 stored and 2 live entries in tenantB, got %d and %d", n, live)
+	}
+}
+
+func TestCacheNamespaceQuota(t *testing.T) {
+	policy := eviction.NewLRU(100)
+	cache := New[int, string](
+		WithShards(1),
+		WithMaxSize(100),
+		WithEviction(policy),
+		WithStats(true),
+		WithNamespaceQuota("noisy", 5),
+	)
+
+	quiet := WithNamespace(context.Background(), "quiet")
+	noisy := WithNamespace(context.Background(), "noisy")
+
+	for i := 0; i < 10; i++ {
+		cache.Set(quiet, i, "quiet")
+	}
+	for i := 0; i < 50; i++ {
+		cache.Set(noisy, i, "noisy")
+	}
+
+	if n := cache.NamespaceLen("noisy"); n != 5 {
+		t.Fatalf("Expected noisy namespace capped at 5, got %d", n)
+	}
+	if n := cache.NamespaceLen("quiet"); n != 10 {
+		t.Fatalf("Expected quiet namespace untouched, got %d", n)
+	}
+
+	// The most recently written noisy keys survive
+	for i := 45; i < 50; i++ {
+		if _, ok := cache.Get(noisy, i); !ok {
+			t.Fatalf("Expected noisy key %d to be present", i)
+		}
+	}
+
+	if stats := cache.NamespaceStats("noisy"); stats.Evictions != 45 {
+		t.Fatalf("Expected 45 evictions in noisy namespace, got %d", stats.Evictions)
+	}
+	if stats := cache.NamespaceStats("quiet"); stats.Evictions != 0 {
+		t.Fatalf("Expected no evictions in quiet namespace, got %d", stats.Evictions)
+	}
+}
+
+func TestCacheDefaultNamespaceQuota(t *testing.T) {
+	cache := New[int, int](
+		WithShards(1),
+		WithDefaultNamespaceQuota(3),
+		WithNamespaceQuota("big", 10),
+	)
+
+	small := WithNamespace(context.Background(), "small")
+	big := WithNamespace(context.Background(), "big")
+
+	for i := 0; i < 20; i++ {
+		cache.Set(small, i, i)
+		cache.Set(big, i, i)
+	}
+
+	if n := cache.NamespaceLen("small"); n != 3 {
+		t.Fatalf("Expected small namespace capped at 3, got %d", n)
+	}
+	if n := cache.NamespaceLen("big"); n != 10 {
+		t.Fatalf("Expected big namespace capped at 10, got %d", n)
+	}
+}
+
+func TestCacheNamespaceQuotaTooSmall(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"namespace": WithNamespaceQuota("small", 3),
+		"default":   WithDefaultNamespaceQuota(3),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected New to panic for a quota smaller than the shard count")
+				}
+			}()
+			New[int, int](WithShards(4), opt)
+		})
+	}
+
+	// A quota of one entry per shard is accepted
+	New[int, int](WithShards(4), WithNamespaceQuota("small", 4))
+}
+
+func TestCacheEntryMetadata(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.7),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+
+	ctx := WithMetadata(context.Background(), "user", "alice")
+	ctx = WithMetadata(ctx, "role", "admin")
+	ctx = WithNamespace(ctx, "tenant")
+
+	cache.Set(ctx, "hello", "world", WithEntryMetadata("role", "owner"))
+
+	entry, ok := cache.GetEntry(ctx, "hello")
+	if !ok {
+		t.Fatal("GetEntry should find the entry")
+	}
+	if entry.Value != "world" || entry.Namespace != "tenant" {
+		t.Fatalf("Unexpected entry: %+v", entry)
+	}
+	if entry.Metadata["user"] != "alice" {
+		t.Fatalf("Expected context metadata to be stored, got %v", entry.Metadata)
+	}
+	if entry.Metadata["role"] != "owner" {
+		t.Fatalf("Expected explicit metadata to win, got %v", entry.Metadata["role"])
+	}
+	if entry.AccessCount != 1 || entry.CreatedAt.IsZero() {
+		t.Fatalf("Expected access tracking on entry, got %+v", entry)
+	}
+
+	// The returned entry is a copy
+	entry.Metadata["user"] = "mallory"
+	entry, _ = cache.GetEntry(ctx, "hello")
+	if entry.Metadata["user"] != "alice" {
+		t.Fatal("Modifying a returned entry should not affect the cache")
+	}
+
+	similar, score, ok := cache.GetSimilarEntry(ctx, "helo")
+	if !ok || similar.Key != "hello" || score < 0.7 {
+		t.Fatalf("Expected similar entry hello, got %+v (score %f)", similar, score)
+	}
+	if similar.Metadata["user"] != "alice" {
+		t.Fatalf("Expected metadata on similar entry, got %v", similar.Metadata)
+	}
+
+	// Overwriting an entry replaces its metadata
+	cache.Set(WithNamespace(context.Background(), "tenant"), "hello", "again")
+	entry, _ = cache.GetEntry(ctx, "hello")
+	if len(entry.Metadata) != 0 {
+		t.Fatalf("Expected metadata to be replaced, got %v", entry.Metadata)
+	}
+}
+
+func TestCacheSimilarityMetadataFilter(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.7),
+		WithShards(1),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ctx := context.Background()
+
+	cache.Set(ctx, "what is go?", "answer-a", WithEntryMetadata("model", "gpt-a"), WithEntryMetadata("lang", "en"))
+	cache.Set(ctx, "what is go", "answer-b", WithEntryMetadata("model", "gpt-b"), WithEntryMetadata("lang", "en"))
+
+	// Without filters the closest key wins
+	_, key, _, ok := cache.GetSimilar(ctx, "what is go?!")
+	if !ok || key != "what is go?" {
+		t.Fatalf("Expected closest key, got %q", key)
+	}
+
+	// A near-identical prompt for another model must not match
+	val, _, _, ok := cache.GetSimilar(ctx, "what is go?!", WithMetadataMatch("model", "gpt-b"))
+	if !ok || val != "answer-b" {
+		t.Fatalf("Expected answer-b, got %q", val)
+	}
+
+	_, _, _, ok = cache.GetSimilar(ctx, "what is go?!", WithMetadataMatch("model", "gpt-c"))
+	if ok {
+		t.Fatal("Expected no match for unknown model")
+	}
+
+	_, _, _, ok = cache.GetSimilar(ctx, "what is go?!",
+		WithMetadataMatch("lang", "en"),
+		WithMetadataFilter(func(md map[string]any) bool {
+			return md["model"] == "gpt-a"
+		}),
+	)
+	if !ok {
+		t.Fatal("Expected a match for combined filters")
+	}
+
+	_, _, ok = cache.GetSimilarEntry(ctx, "what is go?!", WithMetadataMatch("lang", "fr"))
+	if ok {
+		t.Fatal("Expected no match for lang fr")
+	}
+}
+
+// taggedValue is comparable, but == panics when Value holds a slice or map
+type taggedValue struct {
+	Name  string
+	Value any
+}
+
+func TestCacheMetadataMatchUncomparableFields(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.7),
+		WithShards(1),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ctx := context.Background()
+
+	cache.Set(ctx, "what is go?", "a",
+		WithEntryMetadata("model", taggedValue{Name: "gpt", Value: []string{"b"}}))
+
+	_, _, _, ok := cache.GetSimilar(ctx, "what is go?!",
+		WithMetadataMatch("model", taggedValue{Name: "gpt", Value: []string{"b"}}))
+	if !ok {
+		t.Fatal("Expected a match for an equal struct holding a slice")
+	}
+	_, _, _, ok = cache.GetSimilar(ctx, "what is go?!",
+		WithMetadataMatch("model", taggedValue{Name: "gpt", Value: map[string]int{"b": 1}}))
+	if ok {
+		t.Fatal("Expected no match for a struct holding a different value")
+	}
+}
+
+func TestCacheInvalidateTag(t *testing.T) {
+	cache := New[string, string](
+		WithShards(4),
+	)
+	ctx := WithNamespace(context.Background(), "tenant")
+	other := WithNamespace(context.Background(), "other")
+
+	cache.Set(ctx, "user:1", "a", WithTags("users", "team:1"))
+	cache.Set(ctx, "user:2", "b", WithTags("users"))
+	cache.Set(ctx, "team:1", "c", WithTags("team:1"))
+	cache.Set(other, "user:1", "d", WithTags("users"))
+
+	if n := cache.InvalidateTag(ctx, "users"); n != 2 {
+		t.Fatalf("Expected 2 entries invalidated, got %d", n)
+	}
+	if _, ok := cache.Get(ctx, "user:1"); ok {
+		t.Fatal("Tagged entry should be invalidated")
+	}
+	if _, ok := cache.Get(ctx, "team:1"); !ok {
+		t.Fatal("Untagged entry should be kept")
+	}
+	if _, ok := cache.Get(other, "user:1"); !ok {
+		t.Fatal("Entries of other namespaces should be kept")
+	}
+
+	// Retagging an entry moves it in the index
+	cache.Set(ctx, "team:1", "c2", WithTags("teams"))
+	if n := cache.InvalidateTag(ctx, "team:1"); n != 0 {
+		t.Fatalf("Expected stale tag to match nothing, got %d", n)
+	}
+	if n := cache.InvalidateTag(ctx, "teams"); n != 1 {
+		t.Fatalf("Expected 1 entry invalidated, got %d", n)
+	}
+}
+
+func TestCacheTagIndexDoesNotLeak(t *testing.T) {
+	cache := New[int, int](
+		WithShards(1),
+		WithMaxSize(10),
+	)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		cache.Set(ctx, i, i, WithTags(fmt.Sprintf("tag%d", i)))
+	}
+	cache.Delete(ctx, 99)
+	cache.DeleteFunc(ctx, func(e Entry[int, int]) bool { return e.Key == 98 })
+
+	p := cache.shards[0].partitions[""]
+	if len(p.tags) != len(p.data) {
+		t.Fatalf("Expected %d indexed tags, got %d", len(p.data), len(p.tags))
+	}
+}
+
+func TestCacheSubscribe(t *testing.T) {
+	cache := New[string, string](
+		WithShards(1),
+		WithMaxSize(2),
+		WithTTL(50*time.Millisecond),
+		WithThreshold(0.7),
+	)
+	cache.WithSimilarity(algorithms.Levenshtein)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := cache.Subscribe(ctx, nil, WithBufferSize(16))
+
+	cache.Set(ctx, "hello", "1")
+	cache.Set(ctx, "hello", "2")
+	cache.GetSimilar(ctx, "helo")
+	cache.Delete(ctx, "hello")
+	cache.Set(ctx, "a", "a")
+	cache.Set(ctx, "b", "b")
+	cache.Set(ctx, "c", "c") // Evicts a
+	time.Sleep(100 * time.Millisecond)
+	cache.Get(ctx, "b") // Removes the expired entry
+
+	expected := []EventType{EventSet, EventUpdate, EventSimilarHit, EventDelete, EventSet, EventSet, EventEvict, EventSet, EventExpire}
+	for i, want := range expected {
+		select {
+		case ev := <-events:
+			if ev.Type != want {
+				t.Fatalf("Event %d: expected %s, got %s", i, want, ev.Type)
+			}
+			if ev.Type == EventSimilarHit && (ev.Query != "helo" || ev.Entry.Key != "hello") {
+				t.Fatalf("Unexpected similar hit event: %+v", ev)
+			}
+			if ev.Type == EventExpire && ev.Reason != EvictionReasonExpired {
+				t.Fatalf("Expected expired reason, got %s", ev.Reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %d (%s)", i, want)
+		}
+	}
+
+	cancel()
+	for range events {
+		// Drain until the channel is closed
+	}
+}
+
+func TestCacheSubscribeFilterAndDrop(t *testing.T) {
+	cache := New[int, int]()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deletes := cache.Subscribe(ctx, func(ev Event[int, int]) bool {
+		return ev.Type == EventDelete
+	}, WithBufferSize(100))
+	dropped := 0
+	dropping := cache.Subscribe(ctx, nil, WithBufferSize(1), WithDropHandler(func() { dropped++ }))
+
+	for i := 0; i < 10; i++ {
+		cache.Set(ctx, i, i)
+	}
+	cache.Delete(ctx, 3)
+
+	select {
+	case ev := <-deletes:
+		if ev.Type != EventDelete || ev.Entry.Key != 3 {
+			t.Fatalf("Expected delete of key 3, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for delete event")
+	}
+
+	// A full buffer drops events instead of blocking writers
+	if n := len(dropping); n != 1 {
+		t.Fatalf("Expected 1 buffered event, got %d", n)
+	}
+	if dropped != 10 {
+		t.Fatalf("Expected 10 dropped events, got %d", dropped)
+	}
+}
+
+func TestCacheConditionalWrites(t *testing.T) {
+	cache := New[string, int]()
+	ctx := context.Background()
+	now := time.Now()
+
+	cache.Set(ctx, "a", 1, WithUpdateTime(now))
+
+	// Older and equal writes lose against the stored entry
+	if err := cache.Set(ctx, "a", 2, WithUpdateTime(now.Add(-time.Second)), IfNewer()); !errors.Is(err, ErrStale) {
+		t.Fatalf("Expected ErrStale, got %v", err)
+	}
+	if err := cache.Set(ctx, "a", 2, WithUpdateTime(now), IfNewer()); !errors.Is(err, ErrStale) {
+		t.Fatalf("Expected ErrStale, got %v", err)
+	}
+	if err := cache.Set(ctx, "a", 3, WithUpdateTime(now.Add(time.Second)), IfNewer()); err != nil {
+		t.Fatalf("Expected a newer write to succeed, got %v", err)
+	}
+	if err := cache.Set(ctx, "b", 1, WithUpdateTime(now.Add(-time.Hour)), IfNewer()); err != nil {
+		t.Fatalf("Expected a write of a new key to succeed, got %v", err)
+	}
+	if v, _ := cache.Get(ctx, "a"); v != 3 {
+		t.Fatalf("Expected 3, got %d", v)
+	}
+
+	if cache.DeleteIfOlder(ctx, "a", now) {
+		t.Fatal("Expected an older delete to keep the entry")
+	}
+	if !cache.DeleteIfOlder(ctx, "a", now.Add(2*time.Second)) {
+		t.Fatal("Expected a newer delete to remove the entry")
+	}
+	if cache.DeleteIfOlder(ctx, "missing", now) {
+		t.Fatal("Expected no delete of a missing key")
+	}
+}
+
+func TestCacheSubscribeBlocking(t *testing.T) {
+	cache := New[int, int]()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := cache.Subscribe(ctx, nil, WithBufferSize(0), WithBlocking())
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			cache.Set(ctx, i, i)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+	<-done
+}
+
+func TestCacheTopSimilar(t *testing.T) {
+	cache := New[string, string](WithThreshold(0.5))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ctx := context.Background()
+
+	for _, key := range []string{"hello", "hallo", "hell", "help", "world"} {
+		cache.Set(ctx, key, key, WithEntryMetadata("len", len(key)))
+	}
+
+	matches := cache.TopSimilar(ctx, "hello", 3)
+	if len(matches) != 3 {
+		t.Fatalf("Expected 3 matches, got %d", len(matches))
+	}
+	if matches[0].Entry.Key != "hello" || matches[0].Score != 1 {
+		t.Fatalf("Expected exact match first, got %+v", matches[0])
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Score > matches[i-1].Score {
+			t.Fatalf("Matches not sorted by score: %+v", matches)
+		}
+	}
+
+	// Everything above the threshold when k is large
+	if matches := cache.TopSimilar(ctx, "hello", 10); len(matches) != 4 {
+		t.Fatalf("Expected 4 matches above threshold, got %d", len(matches))
+	}
+
+	matches = cache.TopSimilar(ctx, "hello", 10, WithMetadataMatch("len", 4))
+	if len(matches) != 2 {
+		t.Fatalf("Expected 2 matches with metadata filter, got %d", len(matches))
+	}
+
+	if matches := cache.TopSimilar(ctx, "hello", 0); matches != nil {
+		t.Fatalf("Expected no matches for k=0, got %v", matches)
+	}
+}
+
+func TestCacheEntryTTL(t *testing.T) {
+	cache := New[string, string](WithTTL(time.Hour))
+	ctx := context.Background()
+
+	cache.Set(ctx, "short", "v", WithEntryTTL(20*time.Millisecond))
+	cache.Set(ctx, "long", "v")
+
+	entry, _ := cache.Peek(ctx, "long")
+	if time.Until(entry.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("Expected cache TTL, got expiry %v", entry.ExpiresAt)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "short"); ok {
+		t.Fatal("Expected entry TTL to override cache TTL")
+	}
+
+	// An update with a TTL resets the expiry
+	cache.Set(ctx, "long", "v2", WithEntryTTL(20*time.Millisecond))
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "long"); ok {
+		t.Fatal("Expected updated entry to expire")
+	}
+
+	// Without a cache TTL, an update without a TTL removes the expiry
+	cache = New[string, string]()
+	cache.Set(ctx, "key", "v1", WithEntryTTL(20*time.Millisecond))
+	cache.Set(ctx, "key", "v2")
+	time.Sleep(40 * time.Millisecond)
+	if v, ok := cache.Get(ctx, "key"); !ok || v != "v2" {
+		t.Fatalf("Expected update without TTL to persist, got %q, %v", v, ok)
+	}
+
+	// KeepTTL keeps the expiry of the overwritten entry
+	cache.Set(ctx, "key", "v3", WithEntryTTL(20*time.Millisecond))
+	cache.Set(ctx, "key", "v4", KeepTTL())
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "key"); ok {
+		t.Fatal("Expected KeepTTL to keep the expiry")
+	}
+}
+
+func TestCacheExpire(t *testing.T) {
+	cache := New[string, string]()
+	ctx := context.Background()
+
+	if cache.Expire(ctx, "missing", time.Second) {
+		t.Fatal("Expected Expire to report a missing key")
+	}
+
+	cache.Set(ctx, "key", "value")
+	if !cache.Expire(ctx, "key", 20*time.Millisecond) {
+		t.Fatal("Expected Expire to find the key")
+	}
+	if entry, _ := cache.Peek(ctx, "key"); entry.ExpiresAt.IsZero() {
+		t.Fatal("Expected an expiry time")
+	}
+
+	// A zero TTL removes the expiry again
+	cache.Expire(ctx, "key", 0)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "key"); !ok {
+		t.Fatal("Expected key to persist")
+	}
+
+	cache.Expire(ctx, "key", 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "key"); ok {
+		t.Fatal("Expected key to expire")
+	}
+}
+
+// waitFor polls cond until it returns true or the timeout elapses
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for condition")
+}
+
+// mapBackend is an in-memory Backend that can be made to fail
+type mapBackend struct {
+	mu       sync.Mutex
+	data     map[string]string
+	failures int // Number of calls left to fail
+	loads    int
+	stores   int
+	block    chan struct{} // Load waits on it after reading, if set
+}
+
+func newMapBackend() *mapBackend {
+	return &mapBackend{data: make(map[string]string)}
+}
+
+func (b *mapBackend) fail() error {
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("backend unavailable")
+	}
+	return nil
+}
+
+func (b *mapBackend) Load(ctx context.Context, key string) (string, bool, error) {
+	b.mu.Lock()
+	b.loads++
+	if err := b.fail(); err != nil {
+		b.mu.Unlock()
+		return "", false, err
+	}
+	v, ok := b.data[GetNamespace(ctx)+"/"+key]
+	block := b.block
+	b.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	return v, ok, nil
+}
+
+func (b *mapBackend) Store(ctx context.Context, key, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stores++
+	if err := b.fail(); err != nil {
+		return err
+	}
+	b.data[GetNamespace(ctx)+"/"+key] = value
+	return nil
+}
+
+func (b *mapBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.fail(); err != nil {
+		return err
+	}
+	delete(b.data, GetNamespace(ctx)+"/"+key)
+	return nil
+}
+
+func (b *mapBackend) get(key string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.data[key]
+	return v, ok
+}
+
+func TestCacheReadThrough(t *testing.T) {
+	backend := newMapBackend()
+	backend.data["tenant/key"] = "stored"
+
+	var reported []error
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithReadThrough(),
+		WithBackendRetry(2, 0),
+		WithBackendErrorHandler(func(err error) { reported = append(reported, err) }),
+	)
+	ctx := WithNamespace(context.Background(), "tenant")
+
+	for i := 0; i < 2; i++ {
+		if v, ok := cache.Get(ctx, "key"); !ok || v != "stored" {
+			t.Fatalf("Get = %q, %v", v, ok)
+		}
+	}
+	if backend.loads != 1 {
+		t.Fatalf("Expected loaded value to be cached, got %d loads", backend.loads)
+	}
+
+	// Read-through alone does not write to the backend
+	cache.Set(ctx, "other", "v")
+	if backend.stores != 0 {
+		t.Fatal("Expected no backend writes without a write mode")
+	}
+
+	backend.failures = 2
+	if _, ok := cache.Get(ctx, "missing"); ok {
+		t.Fatal("Expected a miss when the backend fails")
+	}
+	var backendErr *BackendError
+	if len(reported) != 1 || !errors.As(reported[0], &backendErr) || backendErr.Op != "load" {
+		t.Fatalf("Expected a reported load error, got %v", reported)
+	}
+}
+
+func TestCacheWriteThrough(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithWriteThrough(),
+		WithBackendRetry(3, time.Millisecond),
+	)
+	ctx := context.Background()
+
+	// Transient failures are retried
+	backend.failures = 2
+	if err := cache.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if v, _ := backend.get("/key"); v != "value" {
+		t.Fatalf("Expected value in backend, got %q", v)
+	}
+
+	backend.failures = 3
+	if err := cache.Set(ctx, "key", "new"); err == nil {
+		t.Fatal("Expected Set to fail when the backend does")
+	}
+	if v, _ := cache.Get(ctx, "key"); v != "value" {
+		t.Fatalf("Expected failed write not to be cached, got %q", v)
+	}
+
+	cache.Delete(ctx, "key")
+	if _, ok := backend.get("/key"); ok {
+		t.Fatal("Expected key to be deleted from the backend")
+	}
+}
+
+func TestCacheBulkWriteThrough(t *testing.T) {
+	backend := newMapBackend()
+	backend.data["/stored"] = "s"
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithReadThrough(),
+		WithWriteThrough(),
+		WithBackendRetry(1, 0),
+	)
+	ctx := context.Background()
+
+	errs := cache.SetMany(ctx, []Item[string, string]{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("SetMany item %d failed: %v", i, err)
+		}
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok := backend.get("/" + key); !ok {
+			t.Fatalf("Expected %s in backend", key)
+		}
+	}
+
+	// An item the backend rejects is not cached
+	backend.failures = 1
+	errs = cache.SetMany(ctx, []Item[string, string]{{"d", "4"}})
+	if errs[0] == nil {
+		t.Fatal("Expected SetMany to report the backend failure")
+	}
+	if _, ok := cache.Peek(ctx, "d"); ok {
+		t.Fatal("Expected rejected item not to be cached")
+	}
+
+	// Misses are loaded from the backend
+	results := cache.GetMany(ctx, []string{"a", "stored", "missing"})
+	if !results[0].Found || !results[1].Found || results[1].Value != "s" || results[2].Found {
+		t.Fatalf("Unexpected GetMany results: %+v", results)
+	}
+
+	cache.DeleteMany(ctx, []string{"a"})
+	if _, ok := backend.get("/a"); ok {
+		t.Fatal("Expected DeleteMany to delete from the backend")
+	}
+	cache.DeleteFunc(ctx, func(e Entry[string, string]) bool { return e.Key == "b" })
+	if _, ok := backend.get("/b"); ok {
+		t.Fatal("Expected DeleteFunc to delete from the backend")
+	}
+
+	// Clear only drops cached copies
+	cache.Clear(ctx)
+	if _, ok := backend.get("/c"); !ok {
+		t.Fatal("Expected Clear to leave the backend untouched")
+	}
+}
+
+func TestCacheBulkWriteBehind(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithWriteBehind(time.Hour),
+	)
+	ctx := context.Background()
+
+	cache.SetMany(ctx, []Item[string, string]{{"a", "1"}, {"b", "2"}})
+	cache.DeleteMany(ctx, []string{"b"})
+	if backend.stores != 0 {
+		t.Fatal("Expected writes to be queued")
+	}
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if v, _ := backend.get("/a"); v != "1" {
+		t.Fatalf("Expected a in backend, got %q", v)
+	}
+	if _, ok := backend.get("/b"); ok {
+		t.Fatal("Expected queued delete of b")
+	}
+}
+
+func TestCacheWriteBehind(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithReadThrough(),
+		WithWriteBehind(time.Hour),
+	)
+	ctx := context.Background()
+
+	cache.Set(ctx, "a", "1")
+	cache.Set(ctx, "a", "2")
+	cache.Set(ctx, "b", "1")
+	cache.Delete(ctx, "b")
+
+	if backend.stores != 0 {
+		t.Fatal("Expected writes to be queued")
+	}
+
+	// Queued writes are visible to read-through before they are flushed
+	cache.Delete(ctx, "a")
+	cache.Set(ctx, "a", "3")
+	cache.shards[cache.shardIndex("a")].delete(ctx, "a")
+	if v, ok := cache.Get(ctx, "a"); !ok || v != "3" {
+		t.Fatalf("Expected queued value, got %q, %v", v, ok)
+	}
+
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if backend.stores != 1 {
+		t.Fatalf("Expected writes to be coalesced, got %d stores", backend.stores)
+	}
+	if v, _ := backend.get("/a"); v != "3" {
+		t.Fatalf("Expected last value in backend, got %q", v)
+	}
+	if _, ok := backend.get("/b"); ok {
+		t.Fatal("Expected queued delete to be applied")
+	}
+
+	// The flusher runs on its own
+	timed := New[string, string](WithBackend[string, string](backend), WithWriteBehind(10*time.Millisecond))
+	defer timed.Close()
+	timed.Set(ctx, "c", "1")
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := backend.get("/c"); !ok {
+		t.Fatal("Expected periodic flush")
+	}
+}
+
+func TestCacheBackendTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected New to panic for a mismatched backend")
+		}
+	}()
+	New[string, int](WithBackend[string, string](newMapBackend()))
+}
+
+func (b *mapBackend) put(key, value string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data[key] = value
+}
+
+func (b *mapBackend) loadCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loads
+}
+
+func TestCacheRefreshAhead(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithTTL(100*time.Millisecond),
+		WithRefreshAhead(0.5),
+	)
+	ctx := context.Background()
+
+	cache.Set(ctx, "key", "v1", WithTags("t"))
+	backend.put("/key", "v2")
+
+	// Before the refresh point nothing is reloaded
+	cache.Get(ctx, "key")
+	if backend.loadCount() != 0 {
+		t.Fatal("Expected no reload early in the lifetime")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := cache.Get(ctx, "key"); v != "v1" {
+		t.Fatalf("Expected current value while refreshing, got %q", v)
+	}
+	cache.Close()
+
+	entry, ok := cache.Peek(ctx, "key")
+	if !ok || entry.Value != "v2" {
+		t.Fatalf("Expected refreshed value, got %q, %v", entry.Value, ok)
+	}
+	if len(entry.Tags) != 1 || time.Until(entry.ExpiresAt) < 80*time.Millisecond {
+		t.Fatalf("Expected refresh to keep tags and restart the TTL: %+v", entry)
+	}
+}
+
+func TestCacheRefreshKeepsNewerWrites(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithTTL(100*time.Millisecond),
+		WithRefreshAhead(0.5),
+	)
+	ctx := context.Background()
+
+	cache.Set(ctx, "deleted", "v1")
+	cache.Set(ctx, "overwritten", "v1")
+	backend.put("/deleted", "v2")
+	backend.put("/overwritten", "v2")
+
+	block := make(chan struct{})
+	backend.mu.Lock()
+	backend.block = block
+	backend.mu.Unlock()
+
+	time.Sleep(60 * time.Millisecond)
+	cache.Get(ctx, "deleted")
+	cache.Get(ctx, "overwritten")
+	waitFor(t, func() bool { return backend.loadCount() == 2 })
+
+	// Writes made while the reloads are in flight win
+	cache.shards[cache.shardIndex("deleted")].delete(ctx, "deleted")
+	cache.Set(ctx, "overwritten", "mine")
+	close(block)
+	cache.Close()
+
+	if _, ok := cache.Peek(ctx, "deleted"); ok {
+		t.Fatal("Expected the refresh not to restore a deleted entry")
+	}
+	if entry, _ := cache.Peek(ctx, "overwritten"); entry.Value != "mine" {
+		t.Fatalf("Expected the newer write to be kept, got %q", entry.Value)
+	}
+
+	// No refresh starts once the cache is closed
+	time.Sleep(60 * time.Millisecond)
+	cache.Get(ctx, "overwritten")
+	time.Sleep(10 * time.Millisecond)
+	if n := backend.loadCount(); n != 2 {
+		t.Fatalf("Expected no refresh after Close, got %d loads", n)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithTTL(20*time.Millisecond),
+		WithStaleWhileRevalidate(time.Second),
+	)
+	ctx := context.Background()
+
+	cache.Set(ctx, "key", "old")
+	cache.Set(ctx, "gone", "old")
+	backend.put("/key", "new")
+	time.Sleep(40 * time.Millisecond)
+
+	// Expired entries are served during the grace period and reloaded
+	if v, ok := cache.Get(ctx, "key"); !ok || v != "old" {
+		t.Fatalf("Expected stale value, got %q, %v", v, ok)
+	}
+	if _, ok := cache.Get(ctx, "gone"); !ok {
+		t.Fatal("Expected stale value for a key the backend no longer has")
+	}
+	cache.Close()
+
+	if v, _ := cache.Get(ctx, "key"); v != "new" {
+		t.Fatalf("Expected reloaded value, got %q", v)
+	}
+	if _, ok := cache.Peek(ctx, "gone"); ok {
+		t.Fatal("Expected entry missing from the backend to be removed")
+	}
+
+	// Without a backend expired entries are not served
+	plain := New[string, string](WithTTL(10*time.Millisecond), WithStaleWhileRevalidate(time.Second))
+	plain.Set(ctx, "key", "v")
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := plain.Get(ctx, "key"); ok {
+		t.Fatal("Expected expired entry without a backend")
+	}
+}
+
+func TestCacheNegativeReadThrough(t *testing.T) {
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithBackend[string, string](backend),
+		WithReadThrough(),
+		WithNegativeTTL(50*time.Millisecond),
+		WithStats(true),
+	)
+	ctx := context.Background()
+
+	if _, status := cache.Lookup(ctx, "missing"); status != LookupMiss {
+		t.Fatalf("Expected first lookup to miss, got %v", status)
+	}
+	if _, status := cache.Lookup(ctx, "missing"); status != LookupNegative {
+		t.Fatalf("Expected negative hit, got %v", status)
+	}
+	if _, ok := cache.Get(ctx, "missing"); ok {
+		t.Fatal("Expected Get to report a negative hit as not found")
+	}
+	if backend.loadCount() != 1 {
+		t.Fatalf("Expected 1 backend load, got %d", backend.loadCount())
+	}
+
+	stats := cache.Stats()
+	if stats.NegativeHits != 2 || stats.Misses != 1 || stats.Hits != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// The negative entry expires and the key is loaded again
+	backend.put("/missing", "found")
+	time.Sleep(60 * time.Millisecond)
+	if v, status := cache.Lookup(ctx, "missing"); status != LookupLoaded || v != "found" {
+		t.Fatalf("Expected loaded value, got %q, %v", v, status)
+	}
+	if _, status := cache.Lookup(ctx, "missing"); status != LookupHit {
+		t.Fatalf("Expected hit, got %v", status)
+	}
+
+	// Backend errors are not negatively cached
+	backend.failures = 3
+	if _, status := cache.Lookup(ctx, "broken"); status != LookupMiss {
+		t.Fatalf("Expected miss on backend error, got %v", status)
+	}
+	if _, status := cache.Lookup(ctx, "broken"); status != LookupMiss {
+		t.Fatalf("Expected failed load not to be negatively cached, got %v", status)
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	cache := New[string, string](WithNegativeTTL(time.Minute))
+	ctx := context.Background()
+
+	calls := 0
+	load := func(ctx context.Context, key string) (string, bool, error) {
+		calls++
+		switch key {
+		case "present":
+			return "value", true, nil
+		case "broken":
+			return "", false, errors.New("load failed")
+		}
+		return "", false, nil
+	}
+
+	if v, status, err := cache.GetOrLoad(ctx, "present", load); err != nil || status != LookupLoaded || v != "value" {
+		t.Fatalf("Expected loaded value, got %q, %v, %v", v, status, err)
+	}
+	if _, status, _ := cache.GetOrLoad(ctx, "present", load); status != LookupHit {
+		t.Fatalf("Expected hit, got %v", status)
+	}
+
+	cache.GetOrLoad(ctx, "absent", load)
+	if _, status, _ := cache.GetOrLoad(ctx, "absent", load); status != LookupNegative {
+		t.Fatalf("Expected negative hit, got %v", status)
+	}
+	if _, _, err := cache.GetOrLoad(ctx, "broken", load); err == nil {
+		t.Fatal("Expected loader error")
+	}
+	if calls != 3 {
+		t.Fatalf("Expected 3 loader calls, got %d", calls)
+	}
+
+	// Storing a negatively cached key replaces the negative entry
+	cache.Set(ctx, "absent", "now present")
+	if v, status, _ := cache.GetOrLoad(ctx, "absent", load); status != LookupHit || v != "now present" {
+		t.Fatalf("Expected stored value, got %q, %v", v, status)
+	}
+
+	// Negative entries are per namespace and removed by Clear
+	cache.GetOrLoad(ctx, "gone", load)
+	other := WithNamespace(ctx, "other")
+	if _, status, _ := cache.GetOrLoad(other, "gone", load); status != LookupMiss {
+		t.Fatalf("Expected miss in another namespace, got %v", status)
+	}
+	cache.Clear(ctx)
+	if _, status, _ := cache.GetOrLoad(ctx, "gone", load); status != LookupMiss {
+		t.Fatalf("Expected Clear to drop negative entries, got %v", status)
+	}
+}
+
+func TestCacheShardsAndHistograms(t *testing.T) {
+	cache := New[string, string](WithShards(4), WithMaxSize(100), WithStats(true), WithThreshold(0.5))
+	cache.WithSimilarity(func(a, b string) float64 {
+		if a[:1] == b[:1] {
+			return 0.9
+		}
+		return 0
+	})
+	ctx := context.Background()
+
+	if cache.Cap() != 100 {
+		t.Fatalf("Expected capacity 100, got %d", cache.Cap())
+	}
+
+	for _, key := range []string{"a1", "b1", "c1", "d1"} {
+		cache.Set(ctx, key, "v")
+		cache.Get(ctx, key)
+	}
+	cache.GetSimilar(ctx, "a2")
+	cache.GetSimilar(ctx, "z")
+
+	shards := cache.Shards()
+	if len(shards) != 4 {
+		t.Fatalf("Expected 4 shards, got %d", len(shards))
+	}
+	entries, hits := 0, uint64(0)
+	for i, shard := range shards {
+		if shard.Index != i || shard.Capacity != 25 {
+			t.Fatalf("Unexpected shard info: %+v", shard)
+		}
+		entries += shard.Len
+		hits += shard.Stats.Hits
+	}
+	if entries != 4 || hits != 4 {
+		t.Fatalf("Expected shards to add up to 4 entries and hits, got %d and %d", entries, hits)
+	}
+
+	histograms := cache.Histograms()
+	if histograms.GetLatency.Count != 4 {
+		t.Fatalf("Expected 4 lookup latencies, got %d", histograms.GetLatency.Count)
+	}
+	if histograms.SimilarLatency.Count != 2 {
+		t.Fatalf("Expected 2 search latencies, got %d", histograms.SimilarLatency.Count)
+	}
+	scores := histograms.SimilarScores
+	if scores.Count != 1 || scores.Sum != 0.9 || len(scores.Counts) != len(scores.Bounds)+1 {
+		t.Fatalf("Unexpected score histogram: %+v", scores)
+	}
+
+	// Histograms are not collected without stats
+	if New[string, string]().Histograms().GetLatency.Count != 0 {
+		t.Fatal("Expected no histograms without stats")
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	// Every latency lands in the bucket bounding it, within the bucket width
+	for _, d := range []time.Duration{0, 1, 7, 8, 15, 16, 17, 999, time.Microsecond, 3 * time.Millisecond, 68 * time.Second} {
+		i := latencyIndex(d.Seconds())
+		upper := time.Duration(math.Round(latencyBounds[i] * 1e9))
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = time.Duration(math.Round(latencyBounds[i-1] * 1e9))
+		}
+		if d < lower || d >= upper {
+			t.Fatalf("Latency %v in bucket [%v, %v)", d, lower, upper)
+		}
+		if d >= 16 && float64(upper-lower) > float64(d)/latencySubBuckets {
+			t.Fatalf("Bucket [%v, %v) too wide for %v", lower, upper, d)
+		}
+	}
+	if latencyIndex(time.Hour.Seconds()) != len(latencyBounds) {
+		t.Fatal("Expected long latencies in the overflow bucket")
+	}
+
+	for _, score := range []float64{0, 0.5, 0.8, 0.801, 1} {
+		i := scoreIndex(score)
+		if score > scoreBounds[i] || (i > 0 && score <= scoreBounds[i-1]) {
+			t.Fatalf("Score %v in bucket %d", score, i)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.observe((time.Duration(i) * time.Microsecond).Seconds())
+	}
+	snap := h.snapshot()
+
+	if snap.Count != 1000 {
+		t.Fatalf("Expected 1000 observations, got %d", snap.Count)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Microsecond}, {0.9, 900 * time.Microsecond}, {0.99, 990 * time.Microsecond}} {
+		got := time.Duration(snap.Quantile(tc.q) * 1e9)
+		if diff := got - tc.want; diff < -tc.want/8 || diff > tc.want/8 {
+			t.Fatalf("Expected p%v near %v, got %v", tc.q*100, tc.want, got)
+		}
+	}
+	if mean := time.Duration(snap.Mean() * 1e9); mean < 500*time.Microsecond || mean > 501*time.Microsecond {
+		t.Fatalf("Expected mean 500.5µs, got %v", mean)
+	}
+
+	coarse := snap.Rebucket([]float64{1e-4, 1e-3})
+	if coarse.Count != 1000 || coarse.Counts[0]+coarse.Counts[1]+coarse.Counts[2] != 1000 {
+		t.Fatalf("Expected rebucketing to keep every observation: %+v", coarse.Counts)
+	}
+	if coarse.Counts[0] < 90 || coarse.Counts[0] > 110 {
+		t.Fatalf("Expected about 100 observations up to 100µs, got %d", coarse.Counts[0])
+	}
+
+	if (Histogram{}).Quantile(0.5) != 0 {
+		t.Fatal("Expected 0 for an empty histogram")
+	}
+}
+
+func TestStatsRatiosAndNearMisses(t *testing.T) {
+	cache := New[string, string](WithShards(2), WithStats(true), WithThreshold(0.8))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ctx := context.Background()
+
+	cache.Set(ctx, "hello", "v")
+	cache.Get(ctx, "hello")
+	cache.Get(ctx, "nope")
+	before, beforeHist := cache.Stats(), cache.Histograms()
+
+	cache.GetSimilar(ctx, "hallo") // 0.8, a hit
+	cache.GetSimilar(ctx, "help")  // 0.6, a near miss
+	cache.Get(ctx, "hello")
+	time.Sleep(time.Millisecond)
+	after, afterHist := cache.Stats(), cache.Histograms()
+
+	if r := before.HitRatio(); r != 0.5 {
+		t.Fatalf("Expected hit ratio 0.5, got %v", r)
+	}
+	if r := after.SimilarHitRatio(); r != 0.5 {
+		t.Fatalf("Expected similar hit ratio 0.5, got %v", r)
+	}
+	near := afterHist.NearMissScores
+	if near.Count != 1 || near.Sum != 0.6 || near.Quantile(1) != 0.6 {
+		t.Fatalf("Expected one near miss scoring 0.6, got %+v", near)
+	}
+
+	delta := after.Sub(before)
+	if delta.Hits != 1 || delta.Misses != 0 || delta.HitRatio() != 1 {
+		t.Fatalf("Unexpected interval stats: %+v", delta)
+	}
+	histDelta := afterHist.Sub(beforeHist)
+	if histDelta.GetLatency.Count != 1 || histDelta.SimilarLatency.Count != 2 {
+		t.Fatalf("Expected interval histograms, got %d and %d", histDelta.GetLatency.Count, histDelta.SimilarLatency.Count)
+	}
+	if delta.Elapsed <= 0 || delta.PerSecond(delta.Hits) <= 0 {
+		t.Fatalf("Expected a positive interval rate, got %v over %v", delta.PerSecond(delta.Hits), delta.Elapsed)
+	}
+}
+
+// scoreKeys is a similarity function scoring a candidate by the number
+// encoded in its key, e.g. "0.75"
+func scoreKeys(_, candidate string) float64 {
+	var score float64
+	fmt.Sscan(candidate, &score)
+	return score
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestThresholdTuning(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.7),
+		WithThresholdTuning(0.9),
+		WithTuningWindow(10),
+		WithTuningStep(0.05),
+	)
+	cache.WithSimilarity(scoreKeys)
+	ctx := context.Background()
+
+	cache.Set(ctx, "0.72", "v")
+	if _, _, _, ok := cache.GetSimilar(ctx, "query"); !ok {
+		t.Fatal("Expected a match at the initial threshold")
+	}
+
+	// Half of the hits are bad, but all from 0.76 up are good
+	for i := 1; i <= 10; i++ {
+		score := 0.7 + float64(i)/100
+		cache.Feedback(ctx, "query", fmt.Sprint(score), score >= 0.755)
+		if i < 10 && cache.Threshold(ctx) != 0.7 {
+			t.Fatal("Expected no decision before the window is full")
+		}
+	}
+
+	// The cutoff of 0.76 is further than one step
+	if th := cache.Threshold(ctx); !approx(th, 0.75) {
+		t.Fatalf("Expected threshold 0.75, got %v", th)
+	}
+	if _, _, _, ok := cache.GetSimilar(ctx, "query"); ok {
+		t.Fatal("Expected the raised threshold to reject the match")
+	}
+
+	// A window of good hits lowers it again
+	for i := 0; i < 10; i++ {
+		cache.Feedback(ctx, "query", "0.8", true)
+	}
+	if th := cache.Threshold(ctx); !approx(th, 0.7) {
+		t.Fatalf("Expected threshold 0.7, got %v", th)
+	}
+
+	history := cache.ThresholdHistory()
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 decisions, got %d", len(history))
+	}
+	if d := history[0]; d.Old != 0.7 || !approx(d.New, 0.75) || d.Precision != 0.5 || d.Samples != 10 {
+		t.Fatalf("Unexpected first decision: %+v", d)
+	}
+	if d := history[1]; !approx(d.Old, 0.75) || d.Precision != 1 {
+		t.Fatalf("Unexpected second decision: %+v", d)
+	}
+}
+
+func TestThresholdTuningScopes(t *testing.T) {
+	cache := New[string, string](
+		WithThreshold(0.7),
+		WithThresholdTuning(0.9),
+		WithNamespaceThresholds(),
+		WithTuningWindow(2),
+		WithTuningStep(0.1),
+		WithThresholdBounds(0.6, 0.75),
+	)
+	cache.WithSimilarity(scoreKeys)
+	a := WithNamespace(context.Background(), "a")
+	b := WithNamespace(context.Background(), "b")
+
+	// The cutoff cannot be met, so the threshold rises a step, to the bound
+	cache.Feedback(a, "q", "0.9", false)
+	cache.Feedback(a, "q", "0.8", false)
+	if th := cache.Threshold(a); th != 0.75 {
+		t.Fatalf("Expected threshold capped at 0.75, got %v", th)
+	}
+	if th := cache.Threshold(b); th != 0.7 {
+		t.Fatalf("Expected other namespace unchanged, got %v", th)
+	}
+	if h := cache.ThresholdHistory(); len(h) != 1 || h[0].Namespace != "a" {
+		t.Fatalf("Unexpected history: %+v", h)
+	}
+
+	// Without tuning, Feedback is ignored
+	plain := New[string, string](WithThreshold(0.7))
+	plain.WithSimilarity(scoreKeys)
+	for i := 0; i < 100; i++ {
+		plain.Feedback(a, "q", "0.8", false)
+	}
+	if plain.Threshold(a) != 0.7 || plain.ThresholdHistory() != nil {
+		t.Fatal("Expected Feedback to be ignored without tuning")
+	}
+}
+
+func TestThresholdCutoff(t *testing.T) {
+	window := []feedbackSample{{0.9, true}, {0.85, true}, {0.85, false}, {0.8, true}, {0.7, false}}
+	// Precision from 0.85 up is 2/3, from 0.8 up 3/4, from 0.7 up 3/5
+	if cut, ok := cutoff(window, 0.75); !ok || cut != 0.8 {
+		t.Fatalf("Expected cutoff 0.8, got %v, %v", cut, ok)
+	}
+	if cut, ok := cutoff(window, 0.7); !ok || cut != 0.8 {
+		t.Fatalf("Expected cutoff 0.8, got %v, %v", cut, ok)
+	}
+	if cut, ok := cutoff(window, 1); !ok || cut != 0.9 {
+		t.Fatalf("Expected cutoff 0.9, got %v, %v", cut, ok)
+	}
+	if _, ok := cutoff([]feedbackSample{{0.9, false}}, 0.5); ok {
+		t.Fatal("Expected no cutoff without good reports")
+	}
+}
+
+// recordingTracer collects finished spans
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	attrs  map[string]any
+	err    error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &recordedSpan{tracer: t, name: name, attrs: make(map[string]any)}
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func TestCacheTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	cache := New[string, string](WithShards(4), WithThreshold(0.5), WithTracer(tracer))
+	cache.WithSimilarity(algorithms.Levenshtein)
+	ctx := WithNamespace(context.Background(), "tenant")
+
+	cache.Set(ctx, "hello", "world")
+	cache.Set(ctx, "goodbye", "world")
+	cache.Get(ctx, "hello")
+	cache.Get(ctx, "missing")
+	cache.GetSimilar(ctx, "hallo")
+	cache.Delete(ctx, "hello")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	cache.Set(cancelled, "key", "value")
+
+	names := make([]string, len(tracer.spans))
+	for i, s := range tracer.spans {
+		names[i] = s.name
+	}
+	want := []string{"synapse.Set", "synapse.Set", "synapse.Get", "synapse.Get", "synapse.GetSimilar", "synapse.Delete", "synapse.Set"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("Expected spans %v, got %v", want, names)
+	}
+
+	get := tracer.spans[2].attrs
+	if get[AttrHit] != true || get[AttrLookupStatus] != "hit" || get[AttrNamespace] != "tenant" {
+		t.Fatalf("Unexpected Get attributes: %v", get)
+	}
+	if get[AttrShard] != int64(cache.shardIndex("hello")) {
+		t.Fatalf("Expected shard %d, got %v", cache.shardIndex("hello"), get[AttrShard])
+	}
+	if miss := tracer.spans[3].attrs; miss[AttrHit] != false || miss[AttrLookupStatus] != "miss" {
+		t.Fatalf("Unexpected Get miss attributes: %v", miss)
+	}
+
+	similar := tracer.spans[4].attrs
+	if similar[AttrHit] != true || similar[AttrScore] != 0.8 || similar[AttrThreshold] != 0.5 {
+		t.Fatalf("Unexpected GetSimilar attributes: %v", similar)
+	}
+	if similar[AttrCandidates] != int64(2) {
+		t.Fatalf("Expected 2 candidates, got %v", similar[AttrCandidates])
+	}
+	if d, ok := similar[AttrSimilarityTime].(int64); !ok || d <= 0 {
+		t.Fatalf("Expected similarity time, got %v", similar[AttrSimilarityTime])
+	}
+
+	if del := tracer.spans[5].attrs; del[AttrHit] != true {
+		t.Fatalf("Unexpected Delete attributes: %v", del)
+	}
+	if tracer.spans[6].err == nil {
+		t.Fatal("Expected the failed Set to record its error")
+	}
+}
+
+// logRecords decodes the JSON records written by a slog.JSONHandler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestCacheLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	backend := newMapBackend()
+	cache := New[string, string](
+		WithShards(1),
+		WithMaxSize(1),
+		WithTTL(10*time.Millisecond),
+		WithBackend[string, string](backend),
+		WithReadThrough(),
+		WithBackendRetry(1, time.Millisecond),
+		WithLogger(logger),
+		WithSlowSimilarityThreshold(time.Millisecond),
+		WithThreshold(0.1),
+	)
+	cache.WithSimilarity(func(a, b string) float64 {
+		time.Sleep(2 * time.Millisecond)
+		return 0.5
+	})
+	ctx := WithNamespace(context.Background(), "tenant")
+
+	cache.Set(ctx, "secret-1", "v")
+	cache.Set(ctx, "secret-2", "v") // evicts secret-1
+	cache.GetSimilar(ctx, "secret-query")
+	time.Sleep(20 * time.Millisecond)
+	cache.Peek(ctx, "secret-2")
+	backend.failures = 1
+	cache.Get(ctx, "secret-2") // expired, then a failed load
+	cache.GetOrLoad(ctx, "secret-3", func(context.Context, string) (string, bool, error) {
+		return "", false, errors.New("loader down")
+	})
+
+	if strings.Contains(buf.String(), "secret-") {
+		t.Fatalf("Expected keys to be hashed in logs:\n%s", buf.String())
+	}
+
+	byMsg := make(map[string]map[string]any)
+	for _, rec := range logRecords(t, &buf) {
+		byMsg[rec["msg"].(string)] = rec
+	}
+
+	evicted := byMsg["entry evicted"]
+	if evicted == nil || evicted["level"] != "DEBUG" || evicted["shard"] != 0.0 || evicted["namespace"] != "tenant" {
+		t.Fatalf("Unexpected eviction record: %v", evicted)
+	}
+	if want := fmt.Sprintf("%016x", hashKey("secret-1")); evicted["key_hash"] != want {
+		t.Fatalf("Expected key hash %s, got %v", want, evicted["key_hash"])
+	}
+	if byMsg["entry expired"] == nil {
+		t.Fatal("Expected an expiration record")
+	}
+	if rec := byMsg["backend error"]; rec == nil || rec["level"] != "ERROR" || rec["op"] != "load" {
+		t.Fatalf("Unexpected backend error record: %v", rec)
+	}
+	if rec := byMsg["load failed"]; rec == nil || rec["level"] != "WARN" || rec["error"] != "loader down" {
+		t.Fatalf("Unexpected load failure record: %v", rec)
+	}
+	slow := byMsg["slow similarity search"]
+	if slow == nil || slow["level"] != "WARN" || slow["candidates"] != 1.0 || slow["hit"] != true || slow["slowest_shard"] != 0.0 {
+		t.Fatalf("Unexpected slow search record: %v", slow)
+	}
+}
+
+func TestCacheHasher(t *testing.T) {
+	ctx := context.Background()
+	cache := New[string, int](
+		WithShards(4),
+		WithHasher(Hasher[string](func(key string) uint64 { return uint64(len(key)) })),
+	)
+	for _, key := range []string{"a", "bb", "ccc", "dddd", "eeeee"} {
+		cache.Set(ctx, key, len(key))
+	}
+
+	lens := []int{1, 2, 1, 1}
+	for i, info := range cache.Shards() {
+		if info.Len != lens[i] {
+			t.Fatalf("Expected shard %d to hold %d keys, got %d", i, lens[i], info.Len)
+		}
+	}
+	if v, ok := cache.Get(ctx, "eeeee"); !ok || v != 5 {
+		t.Fatalf("Expected 5, got %d, %v", v, ok)
+	}
+}
+
+func TestCacheHasherTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected New to panic for a mismatched hasher")
+		}
+	}()
+	New[string, int](WithHasher(Hasher[int](func(key int) uint64 { return uint64(key) })))
+}
+
+// spreadsKeys reports whether the default hasher puts keys in every shard
+func spreadsKeys[K comparable](t *testing.T, key func(i int) K) {
+	t.Helper()
+	ctx := context.Background()
+	cache := New[K, int](WithShards(8), WithMaxSize(10000))
+	for i := range 1000 {
+		cache.Set(ctx, key(i), i)
+	}
+	for i := range 1000 {
+		if v, ok := cache.Get(ctx, key(i)); !ok || v != i {
+			t.Fatalf("Expected %d, got %d, %v", i, v, ok)
+		}
+	}
+	for _, info := range cache.Shards() {
+		if info.Len == 0 {
+			t.Fatalf("Expected keys in shard %d", info.Index)
+		}
+	}
+}
+
+func TestDefaultHasher(t *testing.T) {
+	type pair struct{ A, B string }
+
+	spreadsKeys(t, func(i int) string { return fmt.Sprintf("key%d", i) })
+	spreadsKeys(t, func(i int) int { return i })
+	spreadsKeys(t, func(i int) uint16 { return uint16(i) })
+	spreadsKeys(t, func(i int) [16]byte { return [16]byte{byte(i), byte(i >> 8)} })
+	spreadsKeys(t, func(i int) pair { return pair{fmt.Sprint(i), "x"} })
+
+	// Struct keys formatting alike are still told apart
+	ctx := context.Background()
+	cache := New[pair, int]()
+	cache.Set(ctx, pair{"a b", "c"}, 1)
+	cache.Set(ctx, pair{"a", "b c"}, 2)
+	if v, _ := cache.Get(ctx, pair{"a b", "c"}); v != 1 {
+		t.Fatalf("Expected 1, got %d", v)
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", cache.Len())
+	}
+}
+
+func TestKeyList(t *testing.T) {
+	l := newKeyList[int]()
+	for i := range 6 {
+		l.pushBack(i)
+	}
+	l.remove(0) // head
+	l.remove(3) // middle
+	l.remove(5) // tail
+	l.remove(7) // absent
+
+	if got := slices.Collect(l.all()); !slices.Equal(got, []int{1, 2, 4}) {
+		t.Fatalf("Expected [1 2 4], got %v", got)
+	}
+	if front, ok := l.front(); !ok || front != 1 {
+		t.Fatalf("Expected front 1, got %d, %v", front, ok)
+	}
+
+	// Removing the visited key keeps iteration going
+	var visited []int
+	for k := range l.all() {
+		visited = append(visited, k)
+		l.remove(k)
+	}
+	if !slices.Equal(visited, []int{1, 2, 4}) || l.len() != 0 {
+		t.Fatalf("Expected to visit and remove [1 2 4], got %v with %d left", visited, l.len())
+	}
+	if _, ok := l.front(); ok {
+		t.Fatal("Expected an empty list")
+	}
+
+	l.pushBack(9)
+	if got := slices.Collect(l.all()); !slices.Equal(got, []int{9}) {
+		t.Fatalf("Expected [9], got %v", got)
+	}
+}
+
+func TestEvictionOrderAfterDelete(t *testing.T) {
+	ctx := context.Background()
+	cache := New[string, int](WithShards(1), WithMaxSize(3))
+	cache.Set(ctx, "a", 1)
+	cache.Set(ctx, "b", 2)
+	cache.Set(ctx, "c", 3)
+	cache.Delete(ctx, "a")
+	cache.Set(ctx, "d", 4)
+	cache.Set(ctx, "e", 5)
+
+	// b is the oldest remaining key
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if got := cache.Keys(ctx); !slices.Equal(got, []string{"c", "d", "e"}) {
+		t.Fatalf("Expected [c d e], got %v", got)
+	}
+}
@@ -0,0 +1,261 @@
+// Package synapsetest provides an in-memory fake of synapse.Store for tests
+// of code built on top of a cache
+package synapsetest
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/kolosys/synapse"
+)
+
+// Call records a call made to a Fake
+type Call[K comparable] struct {
+	// Op is the method called, e.g. "Get" or "GetSimilar"
+	Op        string
+	Namespace string
+	Key       K
+}
+
+// Fake is a deterministic in-memory synapse.Store. It has no capacity limit,
+// sharding or expiration. Similarity searches scan the entries in insertion
+// order and ties go to the oldest entry, so results do not depend on map
+// iteration order. Without a similarity function only exact keys match.
+type Fake[K comparable, V any] struct {
+	mu         sync.Mutex
+	similarity synapse.SimilarityFunc[K]
+	threshold  float64
+	namespaces map[string]*fakeNamespace[K, V]
+	stubs      map[stubKey[K]]K
+	calls      []Call[K]
+}
+
+// fakeNamespace holds the entries of a namespace in insertion order
+type fakeNamespace[K comparable, V any] struct {
+	data map[K]*fakeEntry[V]
+	keys []K
+}
+
+// fakeEntry is a stored value with its metadata
+type fakeEntry[V any] struct {
+	value    V
+	metadata map[string]any
+}
+
+// stubKey identifies a scripted similarity result
+type stubKey[K comparable] struct {
+	namespace string
+	query     K
+}
+
+// FakeOption is a function that configures a Fake
+type FakeOption[K comparable] func(*fakeOptions[K])
+
+// fakeOptions contains the options of NewFake
+type fakeOptions[K comparable] struct {
+	similarity synapse.SimilarityFunc[K]
+	threshold  float64
+}
+
+// WithSimilarity makes the fake score candidates with fn, accepting scores of
+// at least threshold
+func WithSimilarity[K comparable](fn synapse.SimilarityFunc[K], threshold float64) FakeOption[K] {
+	return func(o *fakeOptions[K]) {
+		o.similarity = fn
+		o.threshold = threshold
+	}
+}
+
+// NewFake creates an empty fake
+func NewFake[K comparable, V any](opts ...FakeOption[K]) *Fake[K, V] {
+	options := &fakeOptions[K]{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &Fake[K, V]{
+		similarity: options.similarity,
+		threshold:  options.threshold,
+		namespaces: make(map[string]*fakeNamespace[K, V]),
+		stubs:      make(map[stubKey[K]]K),
+	}
+}
+
+// StubSimilar makes GetSimilar for query in the context's namespace return
+// the entry stored under match with a score of 1, as long as it exists
+func (f *Fake[K, V]) StubSimilar(ctx context.Context, query, match K) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stubs[stubKey[K]{synapse.GetNamespace(ctx), query}] = match
+}
+
+// Calls returns the calls made so far
+func (f *Fake[K, V]) Calls() []Call[K] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// Metadata returns the metadata stored with a key
+func (f *Fake[K, V]) Metadata(ctx context.Context, key K) (map[string]any, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns := f.namespaces[synapse.GetNamespace(ctx)]
+	if ns == nil {
+		return nil, false
+	}
+	entry, ok := ns.data[key]
+	if !ok {
+		return nil, false
+	}
+	return entry.metadata, true
+}
+
+// record appends a call; the caller must hold mu
+func (f *Fake[K, V]) record(ctx context.Context, op string, key K) {
+	f.calls = append(f.calls, Call[K]{Op: op, Namespace: synapse.GetNamespace(ctx), Key: key})
+}
+
+// Get implements synapse.Store
+func (f *Fake[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(ctx, "Get", key)
+
+	var zero V
+	ns := f.namespaces[synapse.GetNamespace(ctx)]
+	if ns == nil {
+		return zero, false
+	}
+	entry, ok := ns.data[key]
+	if !ok {
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set implements synapse.Store
+func (f *Fake[K, V]) Set(ctx context.Context, key K, value V, opts ...synapse.SetOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(ctx, "Set", key)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	namespace := synapse.GetNamespace(ctx)
+	ns := f.namespaces[namespace]
+	if ns == nil {
+		ns = &fakeNamespace[K, V]{data: make(map[K]*fakeEntry[V])}
+		f.namespaces[namespace] = ns
+	}
+
+	entry := &fakeEntry[V]{value: value, metadata: synapse.NewSetOptions(ctx, opts...).Metadata}
+	if _, ok := ns.data[key]; !ok {
+		ns.keys = append(ns.keys, key)
+	}
+	ns.data[key] = entry
+	return nil
+}
+
+// GetSimilar implements synapse.Store
+func (f *Fake[K, V]) GetSimilar(ctx context.Context, key K, opts ...synapse.SimilarOption) (V, K, float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(ctx, "GetSimilar", key)
+
+	var (
+		zeroV V
+		zeroK K
+	)
+
+	namespace := synapse.GetNamespace(ctx)
+	ns := f.namespaces[namespace]
+	if ns == nil {
+		return zeroV, zeroK, 0, false
+	}
+	options := synapse.NewSimilarOptions(opts...)
+
+	if match, ok := f.stubs[stubKey[K]{namespace, key}]; ok {
+		if entry, ok := ns.data[match]; ok && accepts(options, entry.metadata) {
+			return entry.value, match, 1, true
+		}
+		return zeroV, zeroK, 0, false
+	}
+
+	var (
+		best      *fakeEntry[V]
+		bestKey   K
+		bestScore float64
+	)
+	for _, k := range ns.keys {
+		entry := ns.data[k]
+		if !accepts(options, entry.metadata) {
+			continue
+		}
+
+		var score float64
+		switch {
+		case f.similarity != nil:
+			score = f.similarity(key, k)
+		case k == key:
+			score = 1
+		default:
+			continue
+		}
+
+		if score >= f.threshold && score > bestScore {
+			best, bestKey, bestScore = entry, k, score
+		}
+	}
+
+	if best == nil {
+		return zeroV, zeroK, 0, false
+	}
+	return best.value, bestKey, bestScore, true
+}
+
+// Delete implements synapse.Store
+func (f *Fake[K, V]) Delete(ctx context.Context, key K) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(ctx, "Delete", key)
+
+	ns := f.namespaces[synapse.GetNamespace(ctx)]
+	if ns == nil {
+		return false
+	}
+	if _, ok := ns.data[key]; !ok {
+		return false
+	}
+	delete(ns.data, key)
+	ns.keys = slices.DeleteFunc(ns.keys, func(k K) bool { return k == key })
+	return true
+}
+
+// Len implements synapse.Store
+func (f *Fake[K, V]) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	total := 0
+	for _, ns := range f.namespaces {
+		total += len(ns.data)
+	}
+	return total
+}
+
+// accepts reports whether metadata passes the search's metadata options
+func accepts(opts *synapse.SimilarOptions, metadata map[string]any) bool {
+	for k, want := range opts.Match {
+		got, ok := metadata[k]
+		if !ok || !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+	return opts.Filter == nil || opts.Filter(metadata)
+}
@@ -0,0 +1,61 @@
+package synapsetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/algorithms"
+)
+
+var _ synapse.Store[string, string] = (*Fake[string, string])(nil)
+
+func TestFakeExactSimilarity(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake[string, string]()
+
+	f.Set(ctx, "hello", "world")
+	if _, _, _, ok := f.GetSimilar(ctx, "helo"); ok {
+		t.Fatal("Expected only exact matches without a similarity function")
+	}
+	if _, k, score, ok := f.GetSimilar(ctx, "hello"); !ok || k != "hello" || score != 1 {
+		t.Fatalf("Expected exact match, got %q %f %v", k, score, ok)
+	}
+}
+
+func TestFakeDeterministicTies(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake[string, string](WithSimilarity(algorithms.Levenshtein, 0.5))
+
+	// "cat" and "bat" score the same against "hat"; the oldest entry wins
+	for i := 0; i < 20; i++ {
+		f.Set(ctx, "cat", "c")
+		f.Set(ctx, "bat", "b")
+		if _, k, _, _ := f.GetSimilar(ctx, "hat"); k != "cat" {
+			t.Fatalf("Expected oldest entry to win the tie, got %q", k)
+		}
+	}
+}
+
+func TestFakeStubAndMetadata(t *testing.T) {
+	ctx := synapse.WithNamespace(context.Background(), "tenant")
+	f := NewFake[string, string]()
+
+	f.Set(ctx, "answer", "42", synapse.WithEntryMetadata("model", "x"))
+	f.StubSimilar(ctx, "what is the answer", "answer")
+
+	if v, k, _, ok := f.GetSimilar(ctx, "what is the answer"); !ok || k != "answer" || v != "42" {
+		t.Fatalf("Expected stubbed match, got %q %q %v", k, v, ok)
+	}
+	if _, _, _, ok := f.GetSimilar(ctx, "what is the answer", synapse.WithMetadataMatch("model", "y")); ok {
+		t.Fatal("Expected metadata match to reject the stub")
+	}
+	if md, _ := f.Metadata(ctx, "answer"); md["model"] != "x" {
+		t.Fatalf("Expected stored metadata, got %v", md)
+	}
+
+	calls := f.Calls()
+	if len(calls) != 3 || calls[1].Op != "GetSimilar" || calls[1].Namespace != "tenant" {
+		t.Fatalf("Unexpected calls %+v", calls)
+	}
+}
@@ -0,0 +1,53 @@
+package synapse
+
+import (
+	"context"
+)
+
+// Tracer starts spans for cache operations. It mirrors the parts of the
+// OpenTelemetry tracing API the cache needs, so that an adapter to
+// OpenTelemetry or another tracing system can live outside this module.
+type Tracer interface {
+	// Start starts a span named after the operation, e.g. "synapse.Get",
+	// returning a context carrying it
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced cache operation
+type Span interface {
+	// SetAttributes attaches attributes describing the operation
+	SetAttributes(attrs ...Attribute)
+	// RecordError records an error returned by the operation
+	RecordError(err error)
+	// End completes the span
+	End()
+}
+
+// Attribute is a key-value pair attached to a span. Value is a string,
+// bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Attribute keys set on spans
+const (
+	// AttrShard is the index of the shard holding the key
+	AttrShard = "synapse.shard"
+	// AttrNamespace is the namespace of the operation
+	AttrNamespace = "synapse.namespace"
+	// AttrHit reports whether Get or GetSimilar found a value, or whether
+	// Delete removed one
+	AttrHit = "synapse.hit"
+	// AttrLookupStatus is the LookupStatus of Get
+	AttrLookupStatus = "synapse.lookup.status"
+	// AttrThreshold is the similarity threshold applied by GetSimilar
+	AttrThreshold = "synapse.similarity.threshold"
+	// AttrScore is the best score found by GetSimilar, 0 without a match
+	AttrScore = "synapse.similarity.score"
+	// AttrCandidates is the number of keys scored by GetSimilar
+	AttrCandidates = "synapse.similarity.candidates"
+	// AttrSimilarityTime is the time GetSimilar spent in the similarity
+	// function, in nanoseconds
+	AttrSimilarityTime = "synapse.similarity.duration_ns"
+)
@@ -0,0 +1,190 @@
+package synapse
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxThresholdHistory is the number of tuning decisions kept
+const maxThresholdHistory = 100
+
+// ThresholdDecision records a tuning decision taken after a window of
+// Feedback reports
+type ThresholdDecision struct {
+	Time time.Time
+	// Namespace is the namespace tuned, or empty when one threshold is tuned
+	// for the whole cache
+	Namespace string
+	Old       float64
+	New       float64
+	// Precision is the fraction of reports in the window that were good
+	Precision float64
+	Samples   int
+}
+
+// feedbackSample is a single Feedback report
+type feedbackSample struct {
+	score float64
+	good  bool
+}
+
+// tuner adjusts similarity thresholds towards a target precision
+type tuner struct {
+	options *Options
+
+	mu         sync.RWMutex
+	base       float64
+	thresholds map[string]float64          // Tuned thresholds by namespace
+	windows    map[string][]feedbackSample // Reports awaiting a decision
+	history    []ThresholdDecision
+}
+
+// newTuner creates a tuner starting from the configured threshold
+func newTuner(options *Options) *tuner {
+	return &tuner{
+		options:    options,
+		base:       options.SimilarityThreshold,
+		thresholds: make(map[string]float64),
+		windows:    make(map[string][]feedbackSample),
+	}
+}
+
+// scope returns the key under which a namespace is tuned
+func (t *tuner) scope(namespace string) string {
+	if t.options.TuneNamespaces {
+		return namespace
+	}
+	return ""
+}
+
+// threshold returns the current threshold of a namespace
+func (t *tuner) threshold(namespace string) float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if v, ok := t.thresholds[t.scope(namespace)]; ok {
+		return v
+	}
+	return t.base
+}
+
+// report records a Feedback report and decides once the window is full
+func (t *tuner) report(namespace string, score float64, good bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scope := t.scope(namespace)
+	window := append(t.windows[scope], feedbackSample{score, good})
+	if len(window) < t.options.TuningWindow {
+		t.windows[scope] = window
+		return
+	}
+	delete(t.windows, scope)
+
+	current, ok := t.thresholds[scope]
+	if !ok {
+		current = t.base
+	}
+	next, precision := t.decide(current, window)
+	t.thresholds[scope] = next
+
+	t.history = append(t.history, ThresholdDecision{
+		Time:      time.Now(),
+		Namespace: scope,
+		Old:       current,
+		New:       next,
+		Precision: precision,
+		Samples:   len(window),
+	})
+	if len(t.history) > maxThresholdHistory {
+		t.history = slices.Delete(t.history, 0, len(t.history)-maxThresholdHistory)
+	}
+}
+
+// decide returns the threshold following current given a full window of
+// reports, along with the precision of the window. Below the target, the
+// threshold rises to the lowest score at which the reported hits would have
+// met it; above the target, it is lowered to admit more matches. Either way
+// it moves by at most one step.
+func (t *tuner) decide(current float64, window []feedbackSample) (float64, float64) {
+	good := 0
+	for _, s := range window {
+		if s.good {
+			good++
+		}
+	}
+	precision := float64(good) / float64(len(window))
+	target := t.options.TargetPrecision
+	step := t.options.TuningStep
+
+	next := current
+	switch {
+	case precision < target:
+		next = current + step
+		if cut, ok := cutoff(window, target); ok && cut > current {
+			next = min(next, cut)
+		}
+	case precision > target:
+		next = current - step
+	}
+	return min(max(next, t.options.MinThreshold), t.options.MaxThreshold), precision
+}
+
+// cutoff returns the lowest score such that the reports scoring at least it
+// meet the target precision
+func cutoff(window []feedbackSample, target float64) (float64, bool) {
+	sorted := slices.Clone(window)
+	slices.SortFunc(sorted, func(a, b feedbackSample) int {
+		return cmp.Compare(b.score, a.score)
+	})
+
+	var cut float64
+	found := false
+	good := 0
+	for i, s := range sorted {
+		if s.good {
+			good++
+		}
+		// Equal scores are admitted or rejected together
+		if i+1 < len(sorted) && sorted[i+1].score == s.score {
+			continue
+		}
+		if float64(good)/float64(i+1) >= target {
+			cut, found = s.score, true
+		}
+	}
+	return cut, found
+}
+
+// Feedback reports whether matched, returned by a similarity search for
+// query in the context's namespace, was a correct match. With
+// WithThresholdTuning, every full window of reports moves the threshold
+// towards the target precision; otherwise Feedback does nothing.
+func (c *Cache[K, V]) Feedback(ctx context.Context, query, matched K, good bool) {
+	if c.tuner == nil || c.similarity == nil {
+		return
+	}
+	c.tuner.report(GetNamespace(ctx), c.similarity(query, matched), good)
+}
+
+// Threshold returns the similarity threshold applied to searches in the
+// context's namespace
+func (c *Cache[K, V]) Threshold(ctx context.Context) float64 {
+	if c.tuner == nil {
+		return c.threshold
+	}
+	return c.tuner.threshold(GetNamespace(ctx))
+}
+
+// ThresholdHistory returns the most recent threshold tuning decisions,
+// oldest first
+func (c *Cache[K, V]) ThresholdHistory() []ThresholdDecision {
+	if c.tuner == nil {
+		return nil
+	}
+	c.tuner.mu.RLock()
+	defer c.tuner.mu.RUnlock()
+	return slices.Clone(c.tuner.history)
+}
@@ -0,0 +1,319 @@
+// Package wire implements a compact binary protocol for serving a synapse
+// cache to remote clients, and the server side of it. See the client package
+// for the matching Go client.
+//
+// Every message is a frame made of a 4-byte big-endian length, an 8-byte
+// request ID, a 1-byte opcode or status and a payload. Clients may send
+// several requests without waiting for the responses; the server answers
+// them in order, echoing the request IDs.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// MaxFrameSize limits the size of a frame's payload
+const MaxFrameSize = 64 << 20
+
+// headerSize is the size of a frame header: length, request ID and opcode
+const headerSize = 4 + 8 + 1
+
+// ErrFrameTooLarge is returned for frames larger than MaxFrameSize
+var ErrFrameTooLarge = errors.New("wire: frame too large")
+
+// errMalformed is returned for payloads that cannot be decoded
+var errMalformed = errors.New("wire: malformed payload")
+
+// Op identifies the operation of a request
+type Op uint8
+
+const (
+	// OpGet reads a key
+	OpGet Op = iota + 1
+	// OpSet writes a key
+	OpSet
+	// OpDelete removes a key
+	OpDelete
+	// OpSimilar searches for the most similar key
+	OpSimilar
+	// OpLen counts the entries of the cache
+	OpLen
+	// OpStats reads the cache statistics
+	OpStats
+)
+
+// Status is the outcome of a request
+type Status uint8
+
+const (
+	// StatusOK is returned for requests that succeeded
+	StatusOK Status = iota
+	// StatusError is returned for requests that failed; the response's Err
+	// describes the failure
+	StatusError
+	// StatusStale is returned for IfNewer writes that were not newer than
+	// the stored entry
+	StatusStale
+)
+
+// Request flags, encoded as a single varint
+const (
+	flagKeepTTL = 1 << iota
+	flagIfNewer
+)
+
+// Request is a request sent by a client. Keys, values and metadata are
+// encoded with the codec shared by client and server.
+type Request struct {
+	Op        Op
+	Namespace string
+	Key       []byte
+	Value     []byte
+	Metadata  []byte
+	Tags      []string
+	// TTL overrides the cache's TTL, in nanoseconds
+	TTL int64
+	// UpdatedAt is the write time in Unix nanoseconds, or zero for now
+	UpdatedAt int64
+	// KeepTTL and IfNewer carry the synapse set options of the same names
+	KeepTTL bool
+	IfNewer bool
+	// Match holds the encoded metadata constraints of a similarity search
+	Match []byte
+}
+
+// Response is the server's answer to a request
+type Response struct {
+	Status Status
+	Err    string
+	Found  bool
+	Key    []byte
+	Value  []byte
+	Score  float64
+	// Count is the result of OpLen
+	Count uint64
+	// Stats holds the counters of OpStats in the order of synapse.Stats
+	Stats []uint64
+}
+
+// WriteFrame writes a frame with the given ID, opcode or status and payload
+func WriteFrame(w io.Writer, id uint64, code uint8, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(header[4:12], id)
+	header[12] = code
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads a frame and returns its ID, opcode or status and payload
+func ReadFrame(r io.Reader) (uint64, uint8, []byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > MaxFrameSize {
+		return 0, 0, nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint64(header[4:12]), header[12], payload, nil
+}
+
+// Marshal encodes the request's fields as a frame payload
+func (req *Request) Marshal() []byte {
+	var e encoder
+	e.string(req.Namespace)
+	e.bytes(req.Key)
+	e.bytes(req.Value)
+	e.bytes(req.Metadata)
+	e.uvarint(uint64(len(req.Tags)))
+	for _, tag := range req.Tags {
+		e.string(tag)
+	}
+	e.varint(req.TTL)
+	e.varint(req.UpdatedAt)
+	var flags uint64
+	if req.KeepTTL {
+		flags |= flagKeepTTL
+	}
+	if req.IfNewer {
+		flags |= flagIfNewer
+	}
+	e.uvarint(flags)
+	e.bytes(req.Match)
+	return e.buf
+}
+
+// Unmarshal decodes a frame payload into the request's fields
+func (req *Request) Unmarshal(payload []byte) error {
+	d := decoder{buf: payload}
+	req.Namespace = d.string()
+	req.Key = d.bytes()
+	req.Value = d.bytes()
+	req.Metadata = d.bytes()
+	if n := d.uvarint(); n > 0 && n <= uint64(len(d.buf)) {
+		req.Tags = make([]string, n)
+		for i := range req.Tags {
+			req.Tags[i] = d.string()
+		}
+	} else if n > 0 {
+		d.err = errMalformed
+	}
+	req.TTL = d.varint()
+	req.UpdatedAt = d.varint()
+	flags := d.uvarint()
+	req.KeepTTL = flags&flagKeepTTL != 0
+	req.IfNewer = flags&flagIfNewer != 0
+	req.Match = d.bytes()
+	return d.err
+}
+
+// Marshal encodes the response's fields as a frame payload
+func (resp *Response) Marshal() []byte {
+	var e encoder
+	e.string(resp.Err)
+	if resp.Found {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+	e.bytes(resp.Key)
+	e.bytes(resp.Value)
+	e.uint64(math.Float64bits(resp.Score))
+	e.uvarint(resp.Count)
+	e.uvarint(uint64(len(resp.Stats)))
+	for _, v := range resp.Stats {
+		e.uvarint(v)
+	}
+	return e.buf
+}
+
+// Unmarshal decodes a frame payload into the response's fields
+func (resp *Response) Unmarshal(payload []byte) error {
+	d := decoder{buf: payload}
+	resp.Err = d.string()
+	resp.Found = d.uvarint() == 1
+	resp.Key = d.bytes()
+	resp.Value = d.bytes()
+	resp.Score = math.Float64frombits(d.uint64())
+	resp.Count = d.uvarint()
+	if n := d.uvarint(); n > 0 && n <= uint64(len(d.buf)) {
+		resp.Stats = make([]uint64, n)
+		for i := range resp.Stats {
+			resp.Stats[i] = d.uvarint()
+		}
+	} else if n > 0 {
+		d.err = errMalformed
+	}
+	return d.err
+}
+
+// encoder appends fields to a payload
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads fields from a payload, remembering the first error
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) < 8 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errMalformed
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
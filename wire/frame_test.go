@@ -0,0 +1,69 @@
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	req := Request{
+		Namespace: "tenant",
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Tags:      []string{"a", "b"},
+		TTL:       1000,
+		UpdatedAt: -5,
+		IfNewer:   true,
+		Match:     []byte{1, 2, 3},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, 42, uint8(OpSet), req.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	id, code, payload, err := ReadFrame(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 || Op(code) != OpSet {
+		t.Fatalf("Unexpected header: id %d, op %d", id, code)
+	}
+
+	var got Request
+	if err := got.Unmarshal(payload); err != nil {
+		t.Fatal(err)
+	}
+	got.Op = OpSet
+	req.Op = OpSet
+	if !reflect.DeepEqual(got, req) {
+		t.Fatalf("Expected %+v, got %+v", req, got)
+	}
+
+	resp := Response{Found: true, Key: []byte("k"), Score: 0.75, Count: 3, Stats: []uint64{1, 2, 3}}
+	var gotResp Response
+	if err := gotResp.Unmarshal(resp.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotResp, resp) {
+		t.Fatalf("Expected %+v, got %+v", resp, gotResp)
+	}
+}
+
+func TestFrameMalformed(t *testing.T) {
+	var req Request
+	if err := req.Unmarshal([]byte{0xff}); err == nil {
+		t.Fatal("Expected an error for a truncated payload")
+	}
+
+	// A length prefix larger than the remaining payload
+	if err := req.Unmarshal([]byte{0, 10, 'a'}); err == nil {
+		t.Fatal("Expected an error for an oversized field")
+	}
+
+	header := []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 1, 1}
+	if _, _, _, err := ReadFrame(bytes.NewReader(header)); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package wire
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/kolosys/synapse"
+	"github.com/kolosys/synapse/codec"
+	"github.com/kolosys/synapse/internal/netserve"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("wire: server closed")
+
+// Options contains configuration options for a Server
+type Options struct {
+	// Codec encodes keys, values and metadata; clients must use the same
+	Codec codec.Codec
+}
+
+// Option is a function that modifies Options
+type Option func(*Options)
+
+// WithCodec sets the codec used to encode keys, values and metadata
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Codec = c
+		}
+	}
+}
+
+// Server serves a cache over the binary protocol
+type Server[K comparable, V any] struct {
+	cache   *synapse.Cache[K, V]
+	options *Options
+	srv     *netserve.Server
+}
+
+// NewServer creates a server for cache
+func NewServer[K comparable, V any](cache *synapse.Cache[K, V], opts ...Option) *Server[K, V] {
+	options := &Options{
+		Codec: codec.Gob{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s := &Server[K, V]{
+		cache:   cache,
+		options: options,
+	}
+	s.srv = netserve.New(s.serveConn)
+	return s
+}
+
+// ListenAndServe listens on addr and serves clients until Close is called
+func (s *Server[K, V]) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves clients connecting to ln until Close is called
+func (s *Server[K, V]) Serve(ln net.Listener) error {
+	if err := s.srv.Serve(ln); err != netserve.ErrClosed {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// Close stops the listeners and closes all client connections
+func (s *Server[K, V]) Close() error {
+	return s.srv.Close()
+}
+
+// serveConn answers the requests of a client in order until it disconnects.
+// Responses are flushed once no more pipelined requests are buffered.
+func (s *Server[K, V]) serveConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, 64<<10)
+	w := bufio.NewWriterSize(conn, 64<<10)
+
+	for {
+		id, code, payload, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+
+		var req Request
+		var resp Response
+		if err := req.Unmarshal(payload); err != nil {
+			resp = Response{Status: StatusError, Err: err.Error()}
+		} else {
+			req.Op = Op(code)
+			resp = s.handle(&req)
+		}
+
+		if err := WriteFrame(w, id, uint8(resp.Status), resp.Marshal()); err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle runs a request against the cache
+func (s *Server[K, V]) handle(req *Request) Response {
+	ctx := synapse.WithNamespace(context.Background(), req.Namespace)
+
+	switch req.Op {
+	case OpLen:
+		return Response{Count: uint64(s.cache.Len())}
+	case OpStats:
+		return Response{Stats: StatsValues(s.cache.Stats())}
+	}
+
+	var key K
+	if err := s.options.Codec.Unmarshal(req.Key, &key); err != nil {
+		return errorResponse(err)
+	}
+
+	switch req.Op {
+	case OpGet:
+		v, ok := s.cache.Get(ctx, key)
+		if !ok {
+			return Response{}
+		}
+		data, err := s.options.Codec.Marshal(v)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return Response{Found: true, Value: data}
+
+	case OpSet:
+		var v V
+		if err := s.options.Codec.Unmarshal(req.Value, &v); err != nil {
+			return errorResponse(err)
+		}
+		opts := []synapse.SetOption{synapse.WithTags(req.Tags...)}
+		if len(req.Metadata) > 0 {
+			var md map[string]any
+			if err := s.options.Codec.Unmarshal(req.Metadata, &md); err != nil {
+				return errorResponse(err)
+			}
+			for k, val := range md {
+				opts = append(opts, synapse.WithEntryMetadata(k, val))
+			}
+		}
+		if req.TTL > 0 {
+			opts = append(opts, synapse.WithEntryTTL(time.Duration(req.TTL)))
+		}
+		if req.UpdatedAt != 0 {
+			opts = append(opts, synapse.WithUpdateTime(time.Unix(0, req.UpdatedAt)))
+		}
+		if req.KeepTTL {
+			opts = append(opts, synapse.KeepTTL())
+		}
+		if req.IfNewer {
+			opts = append(opts, synapse.IfNewer())
+		}
+		if err := s.cache.Set(ctx, key, v, opts...); errors.Is(err, synapse.ErrStale) {
+			return Response{Status: StatusStale}
+		} else if err != nil {
+			return errorResponse(err)
+		}
+		return Response{}
+
+	case OpDelete:
+		return Response{Found: s.cache.Delete(ctx, key)}
+
+	case OpSimilar:
+		var opts []synapse.SimilarOption
+		if len(req.Match) > 0 {
+			var match map[string]any
+			if err := s.options.Codec.Unmarshal(req.Match, &match); err != nil {
+				return errorResponse(err)
+			}
+			for k, val := range match {
+				opts = append(opts, synapse.WithMetadataMatch(k, val))
+			}
+		}
+
+		v, k, score, ok := s.cache.GetSimilar(ctx, key, opts...)
+		if !ok {
+			return Response{}
+		}
+		resp := Response{Found: true, Score: score}
+		var err error
+		if resp.Key, err = s.options.Codec.Marshal(k); err != nil {
+			return errorResponse(err)
+		}
+		if resp.Value, err = s.options.Codec.Marshal(v); err != nil {
+			return errorResponse(err)
+		}
+		return resp
+
+	default:
+		return errorResponse(fmt.Errorf("wire: unknown op %d", req.Op))
+	}
+}
+
+// errorResponse returns the response reporting err
+func errorResponse(err error) Response {
+	return Response{Status: StatusError, Err: err.Error()}
+}
+
+// StatsValues flattens statistics into the order used by OpStats. Histograms
+// are not included.
+func StatsValues(stats synapse.Stats) []uint64 {
+	return []uint64{
+		stats.Hits,
+		stats.Misses,
+		stats.Sets,
+		stats.Deletes,
+		stats.SimilarSearches,
+		stats.SimilarHits,
+		stats.Evictions,
+		stats.Expired,
+		stats.NegativeHits,
+		uint64(stats.Elapsed),
+	}
+}
+
+// StatsFromValues rebuilds statistics flattened by StatsValues. Missing
+// trailing values are left zero, so older servers remain readable.
+func StatsFromValues(values []uint64) synapse.Stats {
+	fields := make([]uint64, 10)
+	copy(fields, values)
+	return synapse.Stats{
+		Hits:            fields[0],
+		Misses:          fields[1],
+		Sets:            fields[2],
+		Deletes:         fields[3],
+		SimilarSearches: fields[4],
+		SimilarHits:     fields[5],
+		Evictions:       fields[6],
+		Expired:         fields[7],
+		NegativeHits:    fields[8],
+		Elapsed:         time.Duration(fields[9]),
+	}
+}